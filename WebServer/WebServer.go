@@ -1,30 +1,34 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"regexp"
 	"seng468/WebServer/Commands"
-	"sync/atomic"
-	"time"
 
 	"golang.org/x/sync/syncmap"
 
 	"seng468/WebServer/UserSessions"
+	"seng468/WebServer/config"
 	"seng468/WebServer/logger"
+	"seng468/WebServer/metrics"
 	"seng468/WebServer/transmitter"
+	"seng468/WebServer/transnum"
+	"strconv"
 	"strings"
 	// _ "net/http/pprof"
 )
 
 type WebServer struct {
-	Name              string
-	transactionNumber int64
-	userSessions      *syncmap.Map
-	transmitter       *transmitter.Transmitter
-	logger            logger.Logger
-	validPath         *regexp.Regexp
+	Name         string
+	transNums    transnum.Allocator
+	userSessions *syncmap.Map
+	transmitter  *transmitter.Transmitter
+	logger       logger.Logger
+	validPath    *regexp.Regexp
 }
 
 func (webServer *WebServer) makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
@@ -47,7 +51,8 @@ func (webServer *WebServer) loginHandler(writer http.ResponseWriter, request *ht
 }
 
 func (webServer *WebServer) addHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle ADD").End()
 	username := request.FormValue("username")
 	amount := request.FormValue("amount")
 
@@ -68,7 +73,8 @@ func (webServer *WebServer) addHandler(writer http.ResponseWriter, request *http
 }
 
 func (webServer *WebServer) quoteHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle QUOTE").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 
@@ -92,7 +98,8 @@ func (webServer *WebServer) quoteHandler(writer http.ResponseWriter, request *ht
 }
 
 func (webServer *WebServer) buyHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle BUY").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 	amount := request.FormValue("amount")
@@ -121,7 +128,8 @@ func (webServer *WebServer) buyHandler(writer http.ResponseWriter, request *http
 }
 
 func (webServer *WebServer) commitBuyHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle COMMIT_BUY").End()
 	username := request.FormValue("username")
 
 	webServer.logger.UserCommand(webServer.Name, currTransNum, "COMMIT_BUY",
@@ -137,7 +145,7 @@ func (webServer *WebServer) commitBuyHandler(writer http.ResponseWriter, request
 
 	if !userSession.HasPendingBuys() {
 		// No pendings buys, return error
-		go webServer.logger.SystemError(webServer.Name, currTransNum, "COMMIT_BUY",
+		webServer.logger.SystemError(webServer.Name, currTransNum, "COMMIT_BUY",
 			username, nil, nil, nil, "No pending buys to commit")
 		http.Error(writer, "No pending buys to commit", 400)
 		return
@@ -178,7 +186,8 @@ func (webServer *WebServer) commitBuyHandler(writer http.ResponseWriter, request
 }
 
 func (webServer *WebServer) cancelBuyHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle CANCEL_BUY").End()
 	username := request.FormValue("username")
 
 	webServer.logger.UserCommand(webServer.Name, currTransNum, "CANCEL_BUY",
@@ -216,7 +225,8 @@ func (webServer *WebServer) cancelBuyHandler(writer http.ResponseWriter, request
 }
 
 func (webServer *WebServer) sellHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle SELL").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 	amount := request.FormValue("amount")
@@ -243,7 +253,8 @@ func (webServer *WebServer) sellHandler(writer http.ResponseWriter, request *htt
 }
 
 func (webServer *WebServer) commitSellHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle COMMIT_SELL").End()
 	username := request.FormValue("username")
 
 	webServer.logger.UserCommand(webServer.Name, currTransNum, "COMMIT_SELL",
@@ -302,7 +313,8 @@ func (webServer *WebServer) commitSellHandler(writer http.ResponseWriter, reques
 }
 
 func (webServer *WebServer) cancelSellHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle CANCEL_SELL").End()
 	username := request.FormValue("username")
 	webServer.logger.UserCommand(webServer.Name, currTransNum, "CANCEL_SELL",
 		username, nil, nil, nil)
@@ -339,7 +351,8 @@ func (webServer *WebServer) cancelSellHandler(writer http.ResponseWriter, reques
 }
 
 func (webServer *WebServer) setBuyAmountHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle SET_BUY_AMOUNT").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 	amount := request.FormValue("amount")
@@ -363,7 +376,8 @@ func (webServer *WebServer) setBuyAmountHandler(writer http.ResponseWriter, requ
 }
 
 func (webServer *WebServer) cancelSetBuyHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle CANCEL_SET_BUY").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 
@@ -386,7 +400,8 @@ func (webServer *WebServer) cancelSetBuyHandler(writer http.ResponseWriter, requ
 }
 
 func (webServer *WebServer) setBuyTriggerHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle SET_BUY_TRIGGER").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 	amount := request.FormValue("amount")
@@ -410,7 +425,8 @@ func (webServer *WebServer) setBuyTriggerHandler(writer http.ResponseWriter, req
 }
 
 func (webServer *WebServer) setSellAmountHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle SET_SELL_AMOUNT").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 	amount := request.FormValue("amount")
@@ -434,7 +450,8 @@ func (webServer *WebServer) setSellAmountHandler(writer http.ResponseWriter, req
 }
 
 func (webServer *WebServer) setSellTriggerHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle SET_SELL_TRIGGER").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 	amount := request.FormValue("amount")
@@ -457,7 +474,8 @@ func (webServer *WebServer) setSellTriggerHandler(writer http.ResponseWriter, re
 }
 
 func (webServer *WebServer) cancelSetSellHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle CANCEL_SET_SELL").End()
 	username := request.FormValue("username")
 	stock := request.FormValue("stock")
 
@@ -479,7 +497,8 @@ func (webServer *WebServer) cancelSetSellHandler(writer http.ResponseWriter, req
 }
 
 func (webServer *WebServer) dumplogHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle DUMPLOG").End()
 	username := request.FormValue("username")
 	filename := request.FormValue("filename")
 
@@ -491,13 +510,18 @@ func (webServer *WebServer) dumplogHandler(writer http.ResponseWriter, request *
 			username, nil, filename, nil)
 	}
 
-	webServer.logger.DumpLog(filename, nil)
+	if len(username) == 0 {
+		webServer.logger.DumpLog(filename, nil)
+	} else {
+		webServer.logger.DumpLog(filename, username)
+	}
 	file := webServer.transmitter.RetrieveDumplog(filename)
 	writer.Write(file)
 }
 
 func (webServer *WebServer) displaySummaryHandler(writer http.ResponseWriter, request *http.Request) {
-	currTransNum := int(atomic.AddInt64(&webServer.transactionNumber, 1))
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle DISPLAY_SUMMARY").End()
 	username := request.FormValue("username")
 
 	webServer.logger.UserCommand(webServer.Name, currTransNum, "DISPLAY_SUMMARY",
@@ -510,54 +534,252 @@ func (webServer *WebServer) displaySummaryHandler(writer http.ResponseWriter, re
 		return
 	}
 
-	resp := webServer.transmitter.MakeRequest(currTransNum, "DISPLAY_SUMMARY,"+username)
-	if resp == "-1" {
+	// The summary is a line of JSON, passed on as it is
+	resp := strings.TrimSpace(webServer.transmitter.MakeRequest(currTransNum, "DISPLAY_SUMMARY,"+username))
+	if resp == "-1" || !json.Valid([]byte(resp)) {
 		webServer.logger.SystemError(webServer.Name, currTransNum, "DISPLAY_SUMMARY",
 			username, nil, nil, nil, "Bad response from transactionserv")
 		http.Error(writer, "Invalid Request", 400)
 		return
 	}
-	lines := strings.Split(resp, ";")
-	fmt.Fprintln(writer, strings.Join(lines, "\n"))
+	writer.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(writer, resp)
+}
+
+// ledgerHandler returns a page of the user's ledger as JSON. The page starts
+// at offset (default 0) and holds up to limit entries (default 50), oldest
+// first unless order=newest.
+// LEDGER isn't a command of the audit log schema, so it isn't logged.
+func (webServer *WebServer) ledgerHandler(writer http.ResponseWriter, request *http.Request) {
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle LEDGER").End()
+	username := request.FormValue("username")
+
+	_, ok := webServer.userSessions.Load(username)
+	// User must be logged in to execute any commands.
+	if !ok {
+		http.Error(writer, "Must be logged in to perform commands", 400)
+		return
+	}
+
+	offset := formInt(request, "offset", 0)
+	limit := formInt(request, "limit", 50)
+	order := request.FormValue("order")
+	if order == "" {
+		order = "oldest"
+	}
+	if !validField(username) || offset < 0 || limit < 1 || (order != "oldest" && order != "newest") {
+		http.Error(writer, "Invalid Request", 400)
+		return
+	}
+
+	command := fmt.Sprintf("LEDGER,%s,%d,%d,%s", username, offset, limit, order)
+	resp := strings.TrimSpace(webServer.transmitter.MakeRequest(currTransNum, command))
+	if resp == "-1" || !json.Valid([]byte(resp)) {
+		fmt.Printf("error: bad ledger response for %s\n", username)
+		http.Error(writer, "Invalid Request", 400)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(writer, resp)
+}
+
+// portfolioHandler returns the value of the user's holdings at current
+// prices, what they cost and the user's equity as JSON.
+// PORTFOLIO isn't a command of the audit log schema, so it isn't logged.
+func (webServer *WebServer) portfolioHandler(writer http.ResponseWriter, request *http.Request) {
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle PORTFOLIO").End()
+	username := request.FormValue("username")
+
+	_, ok := webServer.userSessions.Load(username)
+	// User must be logged in to execute any commands.
+	if !ok {
+		http.Error(writer, "Must be logged in to perform commands", 400)
+		return
+	}
+
+	if !validField(username) {
+		http.Error(writer, "Invalid Request", 400)
+		return
+	}
+
+	resp := strings.TrimSpace(webServer.transmitter.MakeRequest(currTransNum, "PORTFOLIO,"+username))
+	if resp == "-1" || !json.Valid([]byte(resp)) {
+		fmt.Printf("error: bad portfolio response for %s\n", username)
+		http.Error(writer, "Invalid Request", 400)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(writer, resp)
+}
+
+// limitOrderHandler places a LIMIT_BUY or LIMIT_SELL of shares of stock at
+// price, returning the order as JSON with the id to cancel it by.
+// Limit orders aren't commands of the audit log schema, so they aren't logged.
+func (webServer *WebServer) limitOrderHandler(command string) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		currTransNum := webServer.transNums.Next()
+		defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle "+command).End()
+		username := request.FormValue("username")
+		stock := request.FormValue("stock")
+		shares := request.FormValue("shares")
+		price := request.FormValue("price")
+
+		_, ok := webServer.userSessions.Load(username)
+		// User must be logged in to execute any commands.
+		if !ok {
+			http.Error(writer, "Must be logged in to perform commands", 400)
+			return
+		}
+
+		n, err := strconv.Atoi(shares)
+		p, perr := strconv.ParseFloat(price, 64)
+		if !validField(username) || !validSymbol(stock) || err != nil || n < 1 || perr != nil || p <= 0 {
+			http.Error(writer, "Invalid Request", 400)
+			return
+		}
+
+		line := command + "," + username + "," + stock + "," + shares + "," + price
+		resp := strings.TrimSpace(webServer.transmitter.MakeRequest(currTransNum, line))
+		if resp == "-1" || !json.Valid([]byte(resp)) {
+			http.Error(writer, "Bad response from transactionserv", 400)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(writer, resp)
+	}
+}
+
+// cancelLimitHandler cancels the user's limit order id, returning it as JSON
+// with the shares filled before it was cancelled
+func (webServer *WebServer) cancelLimitHandler(writer http.ResponseWriter, request *http.Request) {
+	currTransNum := webServer.transNums.Next()
+	defer webServer.logger.StartSpan(webServer.Name, currTransNum, "handle CANCEL_LIMIT").End()
+	username := request.FormValue("username")
+
+	_, ok := webServer.userSessions.Load(username)
+	// User must be logged in to execute any commands.
+	if !ok {
+		http.Error(writer, "Must be logged in to perform commands", 400)
+		return
+	}
+
+	id := formInt(request, "id", -1)
+	if !validField(username) || id < 0 {
+		http.Error(writer, "Invalid Request", 400)
+		return
+	}
+
+	command := fmt.Sprintf("CANCEL_LIMIT,%s,%d", username, id)
+	resp := strings.TrimSpace(webServer.transmitter.MakeRequest(currTransNum, command))
+	if resp == "-1" || !json.Valid([]byte(resp)) {
+		http.Error(writer, "No such limit order", 400)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(writer, resp)
+}
+
+// validField reports whether value can be sent to the transaction server as
+// a field of a command. Commands are split on commas, follow their
+// transaction number after a semicolon and end at a newline, so a field
+// can't hold any of those.
+func validField(value string) bool {
+	return value != "" && !strings.ContainsAny(value, ",;\r\n")
+}
+
+// validSymbol reports whether stock is a field and a symbol of the audit log
+// schema, which is at most 3 characters
+func validSymbol(stock string) bool {
+	return validField(stock) && len(stock) <= 3
+}
+
+// formInt reads an integer form value, giving def if it is missing and -1
+// if it isn't an integer
+func formInt(request *http.Request, name string, def int) int {
+	value := request.FormValue(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// newTransNumAllocator shares transaction numbers between replicas through
+// the redis instance at transnumaddr:transnumport, if set
+func newTransNumAllocator(cfg *config.Config) transnum.Allocator {
+	if cfg.TransNumAddr == "" {
+		fmt.Println("transnumaddr not set, transaction numbers are only unique to this web server")
+		return &transnum.Counter{}
+	}
+	fmt.Printf("Leasing transaction numbers from %s:%s in blocks of %d\n", cfg.TransNumAddr, cfg.TransNumPort, cfg.TransNumBlock)
+	return transnum.NewRedisAllocator(cfg.TransNumAddr, cfg.TransNumPort, cfg.RedisPassword, cfg.TransNumBlock)
 }
 
 func main() {
-	serverAddress := ":" + os.Getenv("webport")
-	auditAddr := "http://" + os.Getenv("auditaddr") + ":" + os.Getenv("auditport")
+	var cfg config.Config
+	if err := config.Load(&cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	config.Print(os.Stdout, &cfg)
 
-	webServer := &WebServer{
-		Name:              "webserver",
-		transactionNumber: 0,
-		userSessions:      new(syncmap.Map),
-		transmitter:       transmitter.NewTransmitter(os.Getenv("transaddr"), os.Getenv("transport")),
-		logger: logger.AuditLogger{
-			Addr: auditAddr,
-			Client: http.Client{
-				Timeout: time.Second,
-			},
+	serverAddress := ":" + cfg.WebPort
+	auditAddr := cfg.AuditURL()
+
+	auditLogger := logger.AuditLogger{
+		Addr: auditAddr,
+		Client: http.Client{
+			Timeout: cfg.AuditTimeout,
 		},
-		validPath: regexp.MustCompile("^/(ADD|QUOTE|BUY|COMMIT_BUY|CANCEL_BUY|SELL|COMMIT_SELL|CANCEL_SELL|SET_BUY_AMOUNT|CANCEL_SET_BUY|SET_BUY_TRIGGER|SET_SELL_AMOUNT|SET_SELL_TRIGGER|CANCEL_SET_SELL|DUMPLOG|DISPLAY_SUMMARY|LOGIN)/$"),
+		Queue: logger.NewQueue(auditAddr, cfg.QueueOptions()),
+	}
+	webServer := &WebServer{
+		Name:         "webserver",
+		transNums:    newTransNumAllocator(&cfg),
+		userSessions: new(syncmap.Map),
+		transmitter:  transmitter.NewTransmitter(cfg.TransAddr, cfg.TransPort, cfg.TransPoolInit, cfg.TransPoolMax, auditAddr),
+		logger:       auditLogger,
+		validPath:    regexp.MustCompile("^/(ADD|QUOTE|BUY|COMMIT_BUY|CANCEL_BUY|SELL|COMMIT_SELL|CANCEL_SELL|SET_BUY_AMOUNT|CANCEL_SET_BUY|SET_BUY_TRIGGER|SET_SELL_AMOUNT|SET_SELL_TRIGGER|CANCEL_SET_SELL|DUMPLOG|DISPLAY_SUMMARY|LEDGER|PORTFOLIO|LIMIT_BUY|LIMIT_SELL|CANCEL_LIMIT|LOGIN)/$"),
 	}
 
 	http.Handle("/", http.FileServer(http.Dir("./html")))
-	http.HandleFunc("/ADD/", webServer.addHandler)
-	http.HandleFunc("/QUOTE/", webServer.quoteHandler)
-	http.HandleFunc("/BUY/", webServer.buyHandler)
-	http.HandleFunc("/COMMIT_BUY/", webServer.commitBuyHandler)
-	http.HandleFunc("/CANCEL_BUY/", webServer.cancelBuyHandler)
-	http.HandleFunc("/SELL/", webServer.sellHandler)
-	http.HandleFunc("/COMMIT_SELL/", webServer.commitSellHandler)
-	http.HandleFunc("/CANCEL_SELL/", webServer.cancelSellHandler)
-	http.HandleFunc("/SET_BUY_AMOUNT/", webServer.setBuyAmountHandler)
-	http.HandleFunc("/CANCEL_SET_BUY/", webServer.cancelSetBuyHandler)
-	http.HandleFunc("/SET_BUY_TRIGGER/", webServer.setBuyTriggerHandler)
-	http.HandleFunc("/SET_SELL_AMOUNT/", webServer.setSellAmountHandler)
-	http.HandleFunc("/SET_SELL_TRIGGER/", webServer.setSellTriggerHandler)
-	http.HandleFunc("/CANCEL_SET_SELL/", webServer.cancelSetSellHandler)
-	http.HandleFunc("/DUMPLOG/", webServer.dumplogHandler)
-	http.HandleFunc("/DISPLAY_SUMMARY/", webServer.displaySummaryHandler)
-	http.HandleFunc("/LOGIN/", webServer.loginHandler)
+	webServer.transmitter.Logger = webServer.logger
+	registerGauges(webServer, auditLogger)
+	http.Handle("/metrics", metrics.Handler())
+	readinessChecks(webServer, auditAddr).Register(http.DefaultServeMux)
+
+	http.HandleFunc("/ADD/", instrument("ADD", webServer.addHandler))
+	http.HandleFunc("/QUOTE/", instrument("QUOTE", webServer.quoteHandler))
+	http.HandleFunc("/BUY/", instrument("BUY", webServer.buyHandler))
+	http.HandleFunc("/COMMIT_BUY/", instrument("COMMIT_BUY", webServer.commitBuyHandler))
+	http.HandleFunc("/CANCEL_BUY/", instrument("CANCEL_BUY", webServer.cancelBuyHandler))
+	http.HandleFunc("/SELL/", instrument("SELL", webServer.sellHandler))
+	http.HandleFunc("/COMMIT_SELL/", instrument("COMMIT_SELL", webServer.commitSellHandler))
+	http.HandleFunc("/CANCEL_SELL/", instrument("CANCEL_SELL", webServer.cancelSellHandler))
+	http.HandleFunc("/SET_BUY_AMOUNT/", instrument("SET_BUY_AMOUNT", webServer.setBuyAmountHandler))
+	http.HandleFunc("/CANCEL_SET_BUY/", instrument("CANCEL_SET_BUY", webServer.cancelSetBuyHandler))
+	http.HandleFunc("/SET_BUY_TRIGGER/", instrument("SET_BUY_TRIGGER", webServer.setBuyTriggerHandler))
+	http.HandleFunc("/SET_SELL_AMOUNT/", instrument("SET_SELL_AMOUNT", webServer.setSellAmountHandler))
+	http.HandleFunc("/SET_SELL_TRIGGER/", instrument("SET_SELL_TRIGGER", webServer.setSellTriggerHandler))
+	http.HandleFunc("/CANCEL_SET_SELL/", instrument("CANCEL_SET_SELL", webServer.cancelSetSellHandler))
+	http.HandleFunc("/DUMPLOG/", instrument("DUMPLOG", webServer.dumplogHandler))
+	http.HandleFunc("/DISPLAY_SUMMARY/", instrument("DISPLAY_SUMMARY", webServer.displaySummaryHandler))
+	http.HandleFunc("/LEDGER/", instrument("LEDGER", webServer.ledgerHandler))
+	http.HandleFunc("/PORTFOLIO/", instrument("PORTFOLIO", webServer.portfolioHandler))
+	http.HandleFunc("/LIMIT_BUY/", instrument("LIMIT_BUY", webServer.limitOrderHandler("LIMIT_BUY")))
+	http.HandleFunc("/LIMIT_SELL/", instrument("LIMIT_SELL", webServer.limitOrderHandler("LIMIT_SELL")))
+	http.HandleFunc("/CANCEL_LIMIT/", instrument("CANCEL_LIMIT", webServer.cancelLimitHandler))
+	http.HandleFunc("/LOGIN/", instrument("LOGIN", webServer.loginHandler))
 
 	fmt.Printf("Successfully started server on %s\n", serverAddress)
-	panic(http.ListenAndServe(":"+os.Getenv("webport"), nil))
+	if err := serveUntilSignal(&http.Server{Addr: serverAddress}, cfg.DrainTimeout); err != nil {
+		panic(err)
+	}
+	auditLogger.Queue.Flush()
+	fmt.Println("Web server stopped")
 }
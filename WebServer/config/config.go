@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"seng468/WebServer/logger"
+	"time"
+)
+
+// Config holds the settings of the web server
+type Config struct {
+	WebPort        string        `key:"webport" required:"true" help:"port to serve on"`
+	DrainTimeout   time.Duration `key:"shutdowntimeout" default:"20" unit:"s" help:"time to let requests finish at shutdown"`
+	TransAddr      string        `key:"transaddr" required:"true" help:"transaction server host"`
+	TransPort      string        `key:"transport" required:"true" help:"transaction server port"`
+	TransPoolInit  int           `key:"transpoolinit" default:"100" help:"connections opened to the transaction server at startup"`
+	TransPoolMax   int           `key:"transpoolmax" default:"1500" help:"most idle connections kept to the transaction server"`
+	TransNumAddr   string        `key:"transnumaddr" help:"redis host to lease transaction numbers from, if shared between replicas"`
+	TransNumPort   string        `key:"transnumport" help:"redis port to lease transaction numbers from"`
+	TransNumBlock  int64         `key:"transnumblock" default:"1000" help:"transaction numbers leased at once"`
+	RedisPassword  string        `key:"redispassword" secret:"true" help:"redis password, if one is set"`
+	AuditAddr      string        `key:"auditaddr" required:"true"`
+	AuditPort      string        `key:"auditport" required:"true"`
+	AuditTimeout   time.Duration `key:"audittimeout" default:"1" unit:"s" help:"time to wait for the audit server to answer"`
+	AuditBatchSize int           `key:"auditbatchsize" default:"100" help:"audit events sent at once, 1 sends them one by one"`
+	AuditInterval  time.Duration `key:"auditbatchinterval" default:"250" unit:"ms" help:"longest wait to fill a batch of audit events"`
+	AuditQueueSize int           `key:"auditqueuesize" default:"10000" help:"audit events kept in memory before auditqueuepolicy applies"`
+	AuditPolicy    string        `key:"auditqueuepolicy" default:"spill" help:"spill or block once the audit queue is full"`
+	AuditSpillFile string        `key:"auditspillfile" default:"audit-spill.jsonl" help:"where undeliverable audit events are kept"`
+}
+
+// Validate checks the settings that can't be wrong on their own
+func (c *Config) Validate() error {
+	if c.TransPoolInit < 0 || c.TransPoolMax < 1 || c.TransPoolInit > c.TransPoolMax {
+		return errors.New("transpoolinit must be between 0 and transpoolmax")
+	}
+	if c.TransNumAddr != "" && c.TransNumPort == "" {
+		return errors.New("transnumport is required with transnumaddr")
+	}
+	if c.TransNumBlock < 1 || c.AuditBatchSize < 1 || c.AuditQueueSize < 1 {
+		return errors.New("block, batch and queue sizes must be at least 1")
+	}
+	if c.AuditTimeout <= 0 || c.AuditInterval <= 0 || c.DrainTimeout <= 0 {
+		return errors.New("durations must be positive")
+	}
+	_, err := logger.ParseFullPolicy(c.AuditPolicy)
+	return err
+}
+
+// AuditURL is the base URL of the audit server
+func (c *Config) AuditURL() string {
+	return "http://" + c.AuditAddr + ":" + c.AuditPort
+}
+
+// QueueOptions tunes the audit event queue
+func (c *Config) QueueOptions() logger.QueueOptions {
+	policy, _ := logger.ParseFullPolicy(c.AuditPolicy)
+	return logger.QueueOptions{
+		BatchSize: c.AuditBatchSize,
+		Interval:  c.AuditInterval,
+		MaxQueue:  c.AuditQueueSize,
+		Policy:    policy,
+		SpillPath: c.AuditSpillFile,
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"seng468/WebServer/health"
+	"seng468/WebServer/transnum"
+)
+
+// readinessChecks checks the services the web server can't handle requests without
+func readinessChecks(webServer *WebServer, auditAddr string) *health.Checker {
+	checks := new(health.Checker)
+	checks.Add("transaction server", webServer.transmitter.Ping)
+	checks.Add("audit server", health.Get(auditAddr+"/healthz"))
+	if redis, ok := webServer.transNums.(*transnum.RedisAllocator); ok {
+		checks.Add("transaction numbers", redis.Ping)
+	}
+	return checks
+}
@@ -30,11 +30,14 @@ type Logger interface {
 		filename interface{}, funds interface{})
 
 	DumpLog(filename string, username interface{})
+
+	StartSpan(server string, transNum int, name string) *Span
 }
 
 type AuditLogger struct {
 	Addr   string
 	Client http.Client
+	Queue  *Queue // queues events instead of sending them one by one, if set
 }
 
 func (al AuditLogger) DumpLog(filename string, username interface{}) {
@@ -44,6 +47,10 @@ func (al AuditLogger) DumpLog(filename string, username interface{}) {
 	if username != nil {
 		params["username"] = username.(string)
 	}
+	if al.Queue != nil {
+		// Make sure the dump includes every event logged before it
+		al.Queue.Flush()
+	}
 	al.SendLog("/dumpLog", params)
 }
 
@@ -103,7 +110,15 @@ func (al AuditLogger) SystemError(server string, transNum int, command string, u
 		params["username"] = user.(string)
 	}
 	if stock != nil {
-		params["stockSymbol"] = stock.(string)
+		// Symbols in the log schema are at most 3 characters, so a longer
+		// one is reported in the message instead
+		if symbol := stock.(string); len(symbol) <= 3 {
+			params["stockSymbol"] = symbol
+		} else if errorMsg != nil {
+			errorMsg = errorMsg.(string) + " (stock " + symbol + ")"
+		} else {
+			errorMsg = "stock " + symbol
+		}
 	}
 	if filename != nil {
 		params["filename"] = filename.(string)
@@ -147,6 +162,10 @@ func (al AuditLogger) QuoteServer(server string, transactionNum int,
 }
 
 func (al AuditLogger) SendLog(slash string, params map[string]string) {
+	if al.Queue != nil && slash != "/dumpLog" {
+		al.Queue.Add(slash, params)
+		return
+	}
 	var resp *http.Response
 	req, err := http.NewRequest("get", al.Addr+slash, nil)
 	req.Header.Set("Connection", "keep-alive")
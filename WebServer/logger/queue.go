@@ -0,0 +1,346 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FullPolicy decides what Queue.Add does once the queue is full
+type FullPolicy int
+
+const (
+	// SpillWhenFull writes the event to the spill file instead of queueing it
+	SpillWhenFull FullPolicy = iota
+	// BlockWhenFull makes Add wait for room in the queue
+	BlockWhenFull
+)
+
+// ParseFullPolicy reads a policy from "spill" or "block"
+func ParseFullPolicy(s string) (FullPolicy, error) {
+	switch s {
+	case "spill", "":
+		return SpillWhenFull, nil
+	case "block":
+		return BlockWhenFull, nil
+	}
+	return SpillWhenFull, fmt.Errorf("unknown queue policy %q", s)
+}
+
+// QueueOptions tune how events are buffered and sent
+type QueueOptions struct {
+	BatchSize int           // events per request to /batch
+	Interval  time.Duration // longest an event waits to be sent, and the retry period
+	MaxQueue  int           // events held in memory before Policy applies
+	Policy    FullPolicy
+	SpillPath string // where events go while the audit server is unreachable
+}
+
+// DefaultQueueOptions sends batches of 100 and spills past 10000 queued events
+var DefaultQueueOptions = QueueOptions{
+	BatchSize: 100,
+	Interval:  250 * time.Millisecond,
+	MaxQueue:  10000,
+	Policy:    SpillWhenFull,
+	SpillPath: "audit-spill.jsonl",
+}
+
+// Queue buffers audit events in a bounded queue and posts them from a single
+// goroutine to the audit server's /batch endpoint as JSON lines.
+// Events that can't be delivered are appended to a spill file, which is
+// replayed ahead of anything newer once the audit server is reachable again.
+// Delivery is at least once: a batch whose reply is lost may be sent twice.
+type Queue struct {
+	Addr    string
+	opts    QueueOptions
+	client  *http.Client
+	lock    sync.Mutex
+	notFull *sync.Cond
+	events  []map[string]string
+	wake    chan struct{}
+	flushes chan chan struct{}
+
+	spillLock sync.Mutex
+	spilled   int64 // bytes waiting in the spill file
+	lost      uint64
+}
+
+// NewQueue starts a queue posting to the audit server at addr.
+// Events left in the spill file by a previous run are replayed first.
+func NewQueue(addr string, opts QueueOptions) *Queue {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	if opts.MaxQueue < opts.BatchSize {
+		opts.MaxQueue = opts.BatchSize
+	}
+	q := &Queue{
+		Addr:    addr,
+		opts:    opts,
+		client:  &http.Client{Timeout: 15 * time.Second},
+		wake:    make(chan struct{}, 1),
+		flushes: make(chan chan struct{}),
+	}
+	q.notFull = sync.NewCond(&q.lock)
+	if info, err := os.Stat(opts.SpillPath); err == nil && info.Size() > 0 {
+		fmt.Printf("Replaying %d bytes of spilled audit events from %s\n", info.Size(), opts.SpillPath)
+		q.spilled = info.Size()
+	}
+	go q.run()
+	return q
+}
+
+// Add queues an event for the endpoint slash, stamped with the current time.
+// It never sends anything itself, but waits for room in the queue under
+// BlockWhenFull.
+func (q *Queue) Add(slash string, params map[string]string) {
+	event := make(map[string]string, len(params)+2)
+	for k, v := range params {
+		event[k] = v
+	}
+	event["type"] = strings.TrimPrefix(slash, "/")
+	event["timestamp"] = strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+
+	q.lock.Lock()
+	for len(q.events) >= q.opts.MaxQueue {
+		if q.opts.Policy == SpillWhenFull {
+			q.lock.Unlock()
+			q.spill([]map[string]string{event})
+			return
+		}
+		q.notFull.Wait()
+	}
+	q.events = append(q.events, event)
+	full := len(q.events) >= q.opts.BatchSize
+	q.lock.Unlock()
+
+	if full {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush waits until every event added so far has been sent or spilled
+func (q *Queue) Flush() {
+	done := make(chan struct{})
+	q.flushes <- done
+	<-done
+}
+
+// Len returns the number of events waiting in memory
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.events)
+}
+
+// Lost returns the number of events that were rejected by the audit server
+// or could neither be sent nor spilled
+func (q *Queue) Lost() uint64 {
+	return atomic.LoadUint64(&q.lost)
+}
+
+func (q *Queue) run() {
+	ticker := time.NewTicker(q.opts.Interval)
+	defer ticker.Stop()
+	for {
+		var done chan struct{}
+		select {
+		case <-ticker.C:
+		case <-q.wake:
+		case done = <-q.flushes:
+		}
+		q.send()
+		if done != nil {
+			close(done)
+		}
+	}
+}
+
+// send replays the spill file and then posts everything queued in memory.
+// Once a post fails the rest of the queue is spilled, so that older events
+// are always delivered before newer ones.
+func (q *Queue) send() {
+	if err := q.replay(); err != nil {
+		q.spill(q.take(0))
+		return
+	}
+	for {
+		batch := q.take(q.opts.BatchSize)
+		if len(batch) == 0 {
+			return
+		}
+		if err := q.post(batch); err != nil {
+			fmt.Printf("Audit server unreachable, spilling events: %v\n", err)
+			q.spill(batch)
+			q.spill(q.take(0))
+			return
+		}
+	}
+}
+
+// take removes up to n events from the front of the queue, or all if n is 0
+func (q *Queue) take(n int) []map[string]string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if n <= 0 || n > len(q.events) {
+		n = len(q.events)
+	}
+	batch := q.events[:n:n]
+	q.events = q.events[n:]
+	if len(q.events) == 0 {
+		q.events = nil
+	}
+	q.notFull.Broadcast()
+	return batch
+}
+
+func (q *Queue) post(events []map[string]string) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return q.postLines(body.Bytes(), len(events))
+}
+
+func (q *Queue) postLines(body []byte, count int) error {
+	resp, err := q.client.Post(q.Addr+"/batch", "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	reply, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		// Resending won't help, but say so rather than dropping quietly.
+		// Invalid events are listed in the reply, and the rest are stored.
+		rejected := count
+		if n, err := strconv.Atoi(resp.Header.Get("X-Rejected-Events")); err == nil {
+			rejected = n
+		}
+		atomic.AddUint64(&q.lost, uint64(rejected))
+		fmt.Printf("Audit server rejected %d of %d events: %s\n", rejected, count, strings.TrimSpace(string(reply)))
+	case resp.StatusCode != http.StatusOK:
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// spill appends events to the spill file
+func (q *Queue) spill(events []map[string]string) {
+	if len(events) == 0 {
+		return
+	}
+	q.spillLock.Lock()
+	defer q.spillLock.Unlock()
+
+	f, err := os.OpenFile(q.opts.SpillPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		q.loseEvents(events, err)
+		return
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		enc.Encode(event)
+	}
+	n, err := f.Write(body.Bytes())
+	q.spilled += int64(n)
+	if err != nil {
+		q.loseEvents(events, err)
+	}
+}
+
+// loseEvents prints events that couldn't be spilled, as a last resort
+func (q *Queue) loseEvents(events []map[string]string, err error) {
+	atomic.AddUint64(&q.lost, uint64(len(events)))
+	fmt.Printf("error: failed to spill %d audit events: %v\n", len(events), err)
+	for _, event := range events {
+		line, _ := json.Marshal(event)
+		fmt.Printf("lost audit event: %s\n", line)
+	}
+}
+
+// replay posts the spill file in batches, keeping whatever wasn't sent
+func (q *Queue) replay() error {
+	q.spillLock.Lock()
+	defer q.spillLock.Unlock()
+	if q.spilled == 0 {
+		return nil
+	}
+
+	f, err := os.Open(q.opts.SpillPath)
+	if err != nil {
+		return err
+	}
+	var sent int64
+	var batch bytes.Buffer
+	count := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			batch.Write(line)
+			count++
+		}
+		if count > 0 && (count >= q.opts.BatchSize || readErr != nil) {
+			if err = q.postLines(batch.Bytes(), count); err != nil {
+				break
+			}
+			sent += int64(batch.Len())
+			batch.Reset()
+			count = 0
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	f.Close()
+
+	if err != nil {
+		// Keep the unsent tail for the next attempt
+		if sent > 0 {
+			if dropErr := q.dropSpilled(sent); dropErr != nil {
+				fmt.Printf("error: %v\n", dropErr)
+			}
+		}
+		return err
+	}
+	fmt.Printf("Replayed %d bytes of spilled audit events\n", sent)
+	q.spilled = 0
+	return os.Truncate(q.opts.SpillPath, 0)
+}
+
+// dropSpilled removes the first n bytes of the spill file.
+// The caller must hold spillLock.
+func (q *Queue) dropSpilled(n int64) error {
+	data, err := ioutil.ReadFile(q.opts.SpillPath)
+	if err != nil {
+		return err
+	}
+	tmp := q.opts.SpillPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data[n:], 0644); err != nil {
+		return err
+	}
+	q.spilled = int64(len(data)) - n
+	return os.Rename(tmp, q.opts.SpillPath)
+}
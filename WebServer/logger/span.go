@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"strconv"
+	"time"
+)
+
+// Span times one step in handling a transaction, such as serving a request
+// or waiting on another service, and is sent to the audit server on End
+type Span struct {
+	logger   AuditLogger
+	server   string
+	transNum int
+	name     string
+	start    time.Time
+}
+
+// StartSpan starts timing the step name of transaction transNum on server
+func (al AuditLogger) StartSpan(server string, transNum int, name string) *Span {
+	return &Span{
+		logger:   al,
+		server:   server,
+		transNum: transNum,
+		name:     name,
+		start:    time.Now(),
+	}
+}
+
+// End records the span with its start time and duration, in microseconds.
+// A nil span does nothing, so loggers that don't trace can return one.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	params := map[string]string{
+		"server":         s.server,
+		"transactionNum": strconv.Itoa(s.transNum),
+		"name":           s.name,
+		"start":          strconv.FormatInt(s.start.UnixNano()/int64(time.Microsecond), 10),
+		"duration":       strconv.FormatInt(int64(time.Since(s.start)/time.Microsecond), 10),
+	}
+	s.logger.SendLog("/span", params)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"seng468/WebServer/logger"
+	"seng468/WebServer/metrics"
+)
+
+var (
+	requestCount = metrics.NewCounter("web_requests_total",
+		"Requests handled, by command and status code.", "command", "code")
+	requestLatency = metrics.NewHistogram("web_request_duration_seconds",
+		"Time taken to handle each request.", metrics.DefaultBuckets, "command")
+)
+
+// instrument counts and times the requests handled by fn
+func instrument(command string, fn func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return metrics.Instrument(command, requestCount, requestLatency, fn)
+}
+
+func registerGauges(webServer *WebServer, audit logger.AuditLogger) {
+	metrics.NewGaugeFunc("web_sessions", "Users logged in to this web server.", func() float64 {
+		n := 0
+		webServer.userSessions.Range(func(key, value interface{}) bool {
+			n++
+			return true
+		})
+		return float64(n)
+	})
+	if audit.Queue != nil {
+		metrics.NewGaugeFunc("web_audit_events_queued",
+			"Audit events waiting to be sent.", func() float64 { return float64(audit.Queue.Len()) })
+		metrics.NewGaugeFunc("web_audit_events_lost",
+			"Audit events rejected or that could not be spilled.", func() float64 { return float64(audit.Queue.Lost()) })
+	}
+}
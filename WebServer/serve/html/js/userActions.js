@@ -203,9 +203,76 @@ function displaySuccess(data) {
 	}
 
 	if (submitRequest.command.localeCompare('DISPLAY_SUMMARY') === 0) {
-		successMsg = "Display summary: \n" + data;
+		displaySummary(data);
+		return;
 	}
 
 	$('#resultsDiv').text(successMsg);
     // TODO: Open save prompt for dumplog
 }
+
+// Show the account summary returned by DISPLAY_SUMMARY as a set of tables
+function displaySummary(summary) {
+	var results = $('#resultsDiv').empty();
+	results.append($('<h3>').text('Summary for ' + summary.user));
+	if (summary.errors) {
+		results.append($('<p>').text('Could not load: ' + summary.errors.join(', ')));
+	}
+
+	results.append(summaryTable('Cash', ['Available', 'Reserved'],
+		[[money(summary.funds), money(summary.reservedFunds)]]));
+
+	var stocks = Object.keys(Object.assign({}, summary.stocks, summary.reservedStocks)).sort();
+	results.append(summaryTable('Holdings', ['Stock', 'Shares', 'Reserved shares', 'Average cost', 'Cost basis'],
+		stocks.map(function(stock) {
+			var basis = (summary.costBasis || {})[stock];
+			return [stock, (summary.stocks || {})[stock] || 0, (summary.reservedStocks || {})[stock] || 0,
+				basis ? money(basis.averageCost) : '', basis ? money(basis.cost) : ''];
+		})));
+
+	var orders = (summary.buyOrders || []).map(function(o) { return ['BUY'].concat(orderRow(o)); })
+		.concat((summary.sellOrders || []).map(function(o) { return ['SELL'].concat(orderRow(o)); }));
+	results.append(summaryTable('Pending orders', ['Action', 'Stock', 'Shares', 'Cost', 'Age'], orders));
+
+	results.append(summaryTable('Triggers', ['Action', 'Stock', 'Amount', 'Trigger price', 'Status'],
+		summary.triggers.map(function(t) {
+			return [t.action, t.stock, t.amount, t.running ? money(t.price) : '', t.running ? 'running' : 'waiting for price'];
+		})));
+
+	results.append(summaryTable('Limit orders', ['Id', 'Action', 'Stock', 'Shares', 'Filled', 'Limit price', 'Status'],
+		(summary.limitOrders || []).map(function(o) {
+			return [o.id, o.action, o.stock, o.shares, o.filled, money(o.price), o.status];
+		})));
+
+	results.append(summaryTable('Recent commands', ['Time', 'Command', 'Stock', 'Funds'],
+		summary.history.map(function(e) {
+			return [new Date(Number(e.timestamp)).toLocaleString(), e.command, e.stockSymbol || '', e.funds ? money(e.funds) : ''];
+		})));
+}
+
+function orderRow(order) {
+	return [order.stock, order.shares, money(order.cost), order.placed ? order.ageSeconds + 's' : ''];
+}
+
+function money(value) {
+	return '$' + Number(value).toFixed(2);
+}
+
+// summaryTable builds a titled table, saying so when there are no rows.
+// Cells are set as text so nothing in them is read as HTML.
+function summaryTable(title, headings, rows) {
+	var section = $('<div>').append($('<h4>').text(title));
+	if (rows.length === 0) {
+		return section.append($('<p>').text('None'));
+	}
+	var table = $('<table class="summary">');
+	var head = $('<tr>');
+	headings.forEach(function(h) { head.append($('<th>').text(h)); });
+	table.append(head);
+	rows.forEach(function(row) {
+		var tr = $('<tr>');
+		row.forEach(function(cell) { tr.append($('<td>').text(cell)); });
+		table.append(tr);
+	});
+	return section.append(table);
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serveUntilSignal serves until the process gets SIGINT or SIGTERM, then
+// stops accepting connections and waits up to drainTimeout for the requests
+// in flight to finish. An error is only returned if the server couldn't
+// be started, requests still running after drainTimeout are cut off.
+func serveUntilSignal(server *http.Server, drainTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		fmt.Printf("Received %v, draining requests\n", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Printf("error: requests still running after %v: %v\n", drainTimeout, err)
+		server.Close()
+	}
+	return nil
+}
@@ -8,8 +8,9 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"seng468/WebServer/logger"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fatih/pool"
@@ -24,12 +25,18 @@ type Transmitter struct {
 	port           string
 	connection     net.Conn
 	connectionPool pool.Pool
+	auditURL       string
+	Logger         logger.Logger // times each request as a span, if set
 }
 
-func NewTransmitter(addr string, prt string) *Transmitter {
+// NewTransmitter opens a pool of initialConns connections to the transaction
+// server at addr:prt, keeping up to maxConns idle. Dumplogs are fetched from
+// the audit server at auditURL.
+func NewTransmitter(addr string, prt string, initialConns int, maxConns int, auditURL string) *Transmitter {
 	transmitter := new(Transmitter)
 	transmitter.address = addr
 	transmitter.port = prt
+	transmitter.auditURL = auditURL
 	factory := func() (net.Conn, error) {
 		return net.DialTimeout(
 			"tcp",
@@ -38,7 +45,7 @@ func NewTransmitter(addr string, prt string) *Transmitter {
 		)
 	}
 	var err error
-	transmitter.connectionPool, err = pool.NewChannelPool(100, 1500, factory)
+	transmitter.connectionPool, err = pool.NewChannelPool(initialConns, maxConns, factory)
 
 	// This is real bad and should abort the entire webserver
 	if err != nil {
@@ -49,6 +56,9 @@ func NewTransmitter(addr string, prt string) *Transmitter {
 }
 
 func (trans *Transmitter) MakeRequest(transNum int, message string) string {
+	if trans.Logger != nil {
+		defer trans.Logger.StartSpan("webserver", transNum, "call transaction server").End()
+	}
 	prefix := strconv.Itoa(transNum)
 	message = prefix + ";" + message
 	message += "\n"
@@ -78,9 +88,36 @@ func (trans *Transmitter) MakeRequest(transNum int, message string) string {
 	return reply
 }
 
+// Ping sends a PING over a pooled connection and waits for the PONG,
+// dropping the connection from the pool if it doesn't answer
+func (trans *Transmitter) Ping() error {
+	conn, err := trans.connectionPool.Get()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+	_, err = conn.Write([]byte("0;PING\n"))
+	var reply string
+	if err == nil {
+		reply, err = bufio.NewReader(conn).ReadString('\n')
+	}
+	if err == nil && strings.TrimSpace(reply) != "PONG" {
+		err = fmt.Errorf("unexpected reply to PING %q", reply)
+	}
+	if err != nil {
+		if pc, ok := conn.(*pool.PoolConn); ok {
+			pc.MarkUnusable()
+		}
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	return nil
+}
+
 func (trans *Transmitter) RetrieveDumplog(filename string) []byte {
-	auditAddr := "http://" + os.Getenv("auditaddr") + ":" + os.Getenv("auditport")
-	resp, err := http.PostForm(auditAddr+"/dumpLogRetrieve", url.Values{"filename": {filename}})
+	resp, err := http.PostForm(trans.auditURL+"/dumpLogRetrieve", url.Values{"filename": {filename}})
 	if err != nil {
 		log.Print(err)
 	}
@@ -0,0 +1,93 @@
+package transnum
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Allocator hands out transaction numbers, starting from 1
+type Allocator interface {
+	Next() int
+}
+
+// Counter numbers transactions within this process only.
+// It is only unique when a single web server is running.
+type Counter struct {
+	last int64
+}
+
+// Next returns the next transaction number
+func (c *Counter) Next() int {
+	return int(atomic.AddInt64(&c.last, 1))
+}
+
+// RedisAllocator leases blocks of transaction numbers from a counter
+// shared by every web server replica, so numbers never collide between them.
+// Each replica hands out its block in order, so numbers always increase
+// within a replica. Numbers left in a block when a replica stops are skipped.
+type RedisAllocator struct {
+	Key       string
+	BlockSize int64
+	Pool      *redis.Pool
+	lock      sync.Mutex
+	next      int64 // next number to hand out
+	end       int64 // last number of the current block
+}
+
+// NewRedisAllocator leases blocks of blockSize numbers from the redis
+// instance at addr:port, logging in with password if it isn't empty
+func NewRedisAllocator(addr string, port string, password string, blockSize int64) *RedisAllocator {
+	return &RedisAllocator{
+		Key:       "transnum",
+		BlockSize: blockSize,
+		Pool: &redis.Pool{
+			MaxIdle:     2,
+			MaxActive:   0,
+			IdleTimeout: time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr+":"+port, redis.DialPassword(password))
+			},
+		},
+	}
+}
+
+// Next returns the next transaction number, leasing a new block when the
+// current one runs out. It retries until redis answers, rather than
+// handing out a number that could collide.
+func (a *RedisAllocator) Next() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for a.next == 0 || a.next > a.end {
+		end, err := a.lease()
+		if err != nil {
+			fmt.Println("Error leasing transaction numbers -- retrying", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		a.next = end - a.BlockSize + 1
+		a.end = end
+	}
+	n := a.next
+	a.next++
+	return int(n)
+}
+
+// lease reserves the next block and returns its last number
+func (a *RedisAllocator) lease() (int64, error) {
+	conn := a.Pool.Get()
+	defer conn.Close()
+	return redis.Int64(conn.Do("INCRBY", a.Key, a.BlockSize))
+}
+
+// Ping checks that the redis holding the counter can be reached
+func (a *RedisAllocator) Ping() error {
+	conn := a.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
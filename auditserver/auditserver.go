@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"seng468/auditserver/commands"
+	"seng468/auditserver/config"
 	"seng468/auditserver/log"
-	"sync"
+	"seng468/auditserver/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 	// _ "net/http/pprof"
 )
@@ -15,11 +25,31 @@ import (
 func auditWorker() {
 	for {
 		// receive from channel, or be blocked
-		command := <-logChannel
-		eventlog.Insert(command)
+		select {
+		case command := <-logChannel:
+			storeEvent(command)
+		case done := <-logFlushes:
+			for len(logChannel) > 0 {
+				storeEvent(<-logChannel)
+			}
+			close(done)
+		}
 	}
 }
 
+func storeEvent(command commands.Command) {
+	if err := eventlog.Insert(command); err != nil {
+		fmt.Printf("error: failed to store event: %v\n", err)
+	}
+}
+
+// flushLog waits until the worker has stored every event received so far
+func flushLog() {
+	done := make(chan struct{})
+	logFlushes <- done
+	<-done
+}
+
 func userCommandHandler(w http.ResponseWriter, r *http.Request) {
 	timestamp := makeTimestamp()
 	query := r.URL.Query()
@@ -35,6 +65,10 @@ func userCommandHandler(w http.ResponseWriter, r *http.Request) {
 		Filename:       query.Get("filename"),
 		Funds:          query.Get("funds"),
 	}
+	if err := validator.Validate(v); err != nil {
+		rejectEvent(w, err)
+		return
+	}
 	logChannel <- v
 
 	w.Write([]byte("OK"))
@@ -55,6 +89,10 @@ func quoteServerHandler(w http.ResponseWriter, r *http.Request) {
 		QuoteServerTime: query.Get("quoteServerTime"),
 		Cryptokey:       query.Get("cryptokey"),
 	}
+	if err := validator.Validate(v); err != nil {
+		rejectEvent(w, err)
+		return
+	}
 	logChannel <- v
 
 	w.Write([]byte("OK"))
@@ -73,6 +111,10 @@ func accountTransactionHandler(w http.ResponseWriter, r *http.Request) {
 		Username:       query.Get("username"),
 		Funds:          query.Get("funds"),
 	}
+	if err := validator.Validate(v); err != nil {
+		rejectEvent(w, err)
+		return
+	}
 	logChannel <- v
 
 	w.Write([]byte("OK"))
@@ -93,6 +135,10 @@ func systemEventHandler(w http.ResponseWriter, r *http.Request) {
 		Filename:       query.Get("filename"),
 		Funds:          query.Get("funds"),
 	}
+	if err := validator.Validate(v); err != nil {
+		rejectEvent(w, err)
+		return
+	}
 	logChannel <- v
 
 	w.Write([]byte("OK"))
@@ -114,11 +160,22 @@ func errorEventHandler(w http.ResponseWriter, r *http.Request) {
 		Funds:          query.Get("funds"),
 		ErrorMessage:   query.Get("errorMessage"),
 	}
+	if err := validator.Validate(v); err != nil {
+		rejectEvent(w, err)
+		return
+	}
 	logChannel <- v
 
 	w.Write([]byte("OK"))
 }
 
+// rejectEvent counts an event that failed validation and replies with a 400
+func rejectEvent(w http.ResponseWriter, err error) {
+	n := atomic.AddUint64(&invalidEvents, 1)
+	fmt.Printf("Rejected invalid event (%d so far): %v\n", n, err)
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
 func dumpLogHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	dumpfile := query.Get("filename")
@@ -128,17 +185,89 @@ func dumpLogHandler(w http.ResponseWriter, r *http.Request) {
 	//	panic(fmt.Sprintf("Names not equal %q ./test.log\n len=%v", dumpfile, len(dumpfile)))
 	//}
 
+	filter, err := parseFilter(query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+
 	file, err := os.Create(string(dumpfileB))
 	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Printf("error: %v %v\n", err, file)
+		return
 	}
+	defer file.Close()
 	fmt.Printf("Dumping log to %v, with user set as %v", dumpfileB, userLog)
 
-	mutex.Lock()
-	eventlog.Write(file)
-	mutex.Unlock()
+	// Files ending in .gz are written gzipped
+	out := bufio.NewWriter(file)
+	var dst io.Writer = out
+	var gz *gzip.Writer
+	if strings.HasSuffix(dumpfileB, ".gz") {
+		gz = gzip.NewWriter(out)
+		dst = gz
+	}
+
+	if err := eventlog.Snapshot(filter).Write(dst); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	if err := out.Flush(); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
+
+// dumpLogStreamHandler writes the log straight into the response as it is read,
+// gzipped if the client accepts it. Takes the same filters as /dumpLog.
+func dumpLogStreamHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	var dst io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		dst = gz
+	}
+
+	if err := eventlog.Snapshot(filter).Write(dst); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
 
-	file.Close()
+// parseFilter reads the optional DUMPLOG filters from the query:
+// username, transactionNum, from and to (ms timestamps), and any number of
+// type (e.g. userCommand), command (e.g. BUY) and server params.
+func parseFilter(query url.Values) (log.Filter, error) {
+	filter := log.Filter{
+		Username:       string(bytes.Trim([]byte(query.Get("username")), "\x00")),
+		TransactionNum: query.Get("transactionNum"),
+		Types:          query["type"],
+		Commands:       query["command"],
+		Servers:        query["server"],
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = strconv.ParseInt(from, 10, 64); err != nil {
+			return filter, fmt.Errorf("bad from timestamp %q", from)
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = strconv.ParseInt(to, 10, 64); err != nil {
+			return filter, fmt.Errorf("bad to timestamp %q", to)
+		}
+	}
+	return filter, nil
 }
 
 func dumpLogRetrieveHandler(w http.ResponseWriter, r *http.Request) {
@@ -150,24 +279,76 @@ func makeTimestamp() int64 {
 	return time.Now().UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
 }
 
-var eventlog = log.Log{
-	Entries: make([]commands.Command, 5000000),
-}
-var mutex sync.Mutex
+var eventlog *log.Log
+
+// spanlog holds the timing spans of transactions, apart from the events
+var spanlog *log.Log
 var logChannel = make(chan commands.Command, 10000)
+var logFlushes = make(chan chan struct{})
+
+// validator checks events as they are received, and invalidEvents counts
+// the ones it rejects
+var validator commands.Validator
+var invalidEvents uint64
 
 func main() {
-	http.HandleFunc("/userCommand", userCommandHandler)
-	http.HandleFunc("/quoteServer", quoteServerHandler)
-	http.HandleFunc("/accountTransaction", accountTransactionHandler)
-	http.HandleFunc("/systemEvent", systemEventHandler)
-	http.HandleFunc("/errorEvent", errorEventHandler)
-	http.HandleFunc("/dumpLog", dumpLogHandler)
-	http.HandleFunc("/dumpLogRetrieve", dumpLogRetrieveHandler)
-
-	fmt.Printf("Audit server listening on %s:%s\n", os.Getenv("auditaddr"), os.Getenv("auditport"))
+	validate := flag.String("validate", "", "check a dumped log file against logfile.xsd and exit")
+	anytime := flag.Bool("anytime", false, "with -validate, skip the schema's semester time limits")
+	var cfg config.Config
+	err := config.Load(&cfg, flag.CommandLine, os.Args[1:])
+	// -validate only reads a dumped file, so doesn't need the server's settings
+	if *validate != "" {
+		os.Exit(validateDump(*validate, *anytime))
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	config.Print(os.Stdout, &cfg)
+
+	if cfg.SchemaTime {
+		validator = commands.SchemaValidator
+	}
+
+	logDir := cfg.LogDir
+	opts, _ := cfg.LogOptions()
+	eventlog, err = log.Open(logDir, opts)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Loaded %d events from %s\n", eventlog.Len(), logDir)
+
+	spanlog, err = log.Open(filepath.Join(logDir, "spans"), opts)
+	if err != nil {
+		panic(err)
+	}
+
+	handle("/userCommand", userCommandHandler)
+	handle("/quoteServer", quoteServerHandler)
+	handle("/accountTransaction", accountTransactionHandler)
+	handle("/systemEvent", systemEventHandler)
+	handle("/errorEvent", errorEventHandler)
+	handle("/batch", batchHandler)
+	handle("/span", spanHandler)
+	handle("/trace", traceHandler)
+	handle("/dumpLog", dumpLogHandler)
+	handle("/dumpLogStream", dumpLogStreamHandler)
+	handle("/dumpLogRetrieve", dumpLogRetrieveHandler)
+	handle("/query", queryHandler)
+
+	http.Handle("/metrics", metrics.Handler())
+	readinessChecks().Register(http.DefaultServeMux)
+
+	fmt.Printf("Audit server listening on %s:%s\n", cfg.AuditAddr, cfg.AuditPort)
 	go auditWorker()
-	if err := http.ListenAndServe(":"+os.Getenv("auditport"), nil); err != nil {
+	if err := serveUntilSignal(&http.Server{Addr: ":" + cfg.AuditPort}, cfg.DrainTimeout); err != nil {
 		panic(err)
 	}
+	flushLog()
+	for _, l := range []*log.Log{eventlog, spanlog} {
+		if err := l.Close(); err != nil {
+			fmt.Printf("error: %v\n", err)
+		}
+	}
+	fmt.Println("Audit server stopped")
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"seng468/auditserver/commands"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// batchHandler accepts many events in one POST body, either as JSON lines
+//		{"type":"userCommand","timestamp":"1521131234567","server":"webserver",...}
+// using the same field names as the single event endpoints, or as a run of
+// XML elements in the format they are dumped in.
+// Events without a timestamp are stamped with the time they were received.
+// Events that fail validation are counted and listed in a 400 reply, with
+// their number in the X-Rejected-Events header.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	timestamp := makeTimestamp()
+	defer r.Body.Close()
+
+	body := bufio.NewReader(r.Body)
+	var events []commands.Command
+	var err error
+	if isXMLBatch(r, body) {
+		events, err = decodeXMLBatch(body)
+	} else {
+		events, err = decodeJSONBatch(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		fmt.Printf("Rejected batch: %v\n", err)
+		return
+	}
+
+	// Valid events are kept even if others in the batch are rejected
+	var rejected []string
+	for i, c := range events {
+		if span, ok := c.(*commands.Span); ok {
+			if err := storeSpan(span); err != nil {
+				rejected = append(rejected, fmt.Sprintf("event %d: %s", i+1, err.Error()))
+			}
+			continue
+		}
+		setDefaultTimestamp(c, timestamp)
+		if err := validator.Validate(c); err != nil {
+			n := atomic.AddUint64(&invalidEvents, 1)
+			fmt.Printf("Rejected invalid event (%d so far): %v\n", n, err)
+			rejected = append(rejected, fmt.Sprintf("event %d: %s", i+1, err.Error()))
+			continue
+		}
+		logChannel <- c
+	}
+	fmt.Printf("Received batch of %d events at %v\n", len(events), timestamp)
+
+	if len(rejected) > 0 {
+		w.Header().Set("X-Rejected-Events", strconv.Itoa(len(rejected)))
+		http.Error(w, strings.Join(rejected, "\n"), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+func isXMLBatch(r *http.Request, body *bufio.Reader) bool {
+	if strings.Contains(r.Header.Get("Content-Type"), "xml") {
+		return true
+	}
+	for {
+		b, err := body.Peek(1)
+		if err != nil {
+			return false
+		}
+		if b[0] == ' ' || b[0] == '\n' || b[0] == '\r' || b[0] == '\t' {
+			body.ReadByte()
+			continue
+		}
+		return b[0] == '<'
+	}
+}
+
+func decodeJSONBatch(body io.Reader) ([]commands.Command, error) {
+	var events []commands.Command
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var params map[string]string
+		if err := json.Unmarshal(text, &params); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		c, err := commandFromParams(params)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		events = append(events, c)
+	}
+	return events, scanner.Err()
+}
+
+func decodeXMLBatch(body io.Reader) ([]commands.Command, error) {
+	var events []commands.Command
+	dec := xml.NewDecoder(body)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		// Allow the batch to be wrapped in a <log> element
+		if start.Name.Local == "log" {
+			continue
+		}
+		c, err := commands.New(start.Name.Local)
+		if err != nil {
+			return nil, err
+		}
+		if err := dec.DecodeElement(c, &start); err != nil {
+			return nil, err
+		}
+		events = append(events, c)
+	}
+}
+
+// commandFromParams builds a command out of a JSON batch line
+func commandFromParams(params map[string]string) (commands.Command, error) {
+	var timestamp int64
+	if ts := params["timestamp"]; ts != "" {
+		var err error
+		timestamp, err = strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad timestamp %q", ts)
+		}
+	}
+
+	switch params["type"] {
+	case "userCommand":
+		return &commands.UserCommand{
+			Timestamp:      timestamp,
+			Server:         params["server"],
+			TransactionNum: params["transactionNum"],
+			Command:        params["command"],
+			Username:       params["username"],
+			StockSymbol:    params["stockSymbol"],
+			Filename:       params["filename"],
+			Funds:          params["funds"],
+		}, nil
+	case "quoteServer":
+		return &commands.QuoteServer{
+			Timestamp:       timestamp,
+			Server:          params["server"],
+			TransactionNum:  params["transactionNum"],
+			Username:        params["username"],
+			StockSymbol:     params["stockSymbol"],
+			Price:           params["price"],
+			QuoteServerTime: params["quoteServerTime"],
+			Cryptokey:       params["cryptokey"],
+		}, nil
+	case "accountTransaction":
+		return &commands.AccountTransaction{
+			Timestamp:      timestamp,
+			Server:         params["server"],
+			TransactionNum: params["transactionNum"],
+			Action:         params["action"],
+			Username:       params["username"],
+			Funds:          params["funds"],
+		}, nil
+	case "systemEvent":
+		return &commands.SystemEvent{
+			Timestamp:      timestamp,
+			Server:         params["server"],
+			TransactionNum: params["transactionNum"],
+			Command:        params["command"],
+			Username:       params["username"],
+			StockSymbol:    params["stockSymbol"],
+			Filename:       params["filename"],
+			Funds:          params["funds"],
+		}, nil
+	case "span":
+		start, _ := strconv.ParseInt(params["start"], 10, 64)
+		duration, _ := strconv.ParseInt(params["duration"], 10, 64)
+		return &commands.Span{
+			Server:         params["server"],
+			TransactionNum: params["transactionNum"],
+			Name:           params["name"],
+			Start:          start,
+			Duration:       duration,
+		}, nil
+	case "errorEvent":
+		return &commands.ErrorEvent{
+			Timestamp:      timestamp,
+			Server:         params["server"],
+			TransactionNum: params["transactionNum"],
+			Command:        params["command"],
+			Username:       params["username"],
+			StockSymbol:    params["stockSymbol"],
+			Filename:       params["filename"],
+			Funds:          params["funds"],
+			ErrorMessage:   params["errorMessage"],
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown event type %q", params["type"])
+}
+
+func setDefaultTimestamp(c commands.Command, timestamp int64) {
+	switch v := c.(type) {
+	case *commands.UserCommand:
+		if v.Timestamp == 0 {
+			v.Timestamp = timestamp
+		}
+	case *commands.QuoteServer:
+		if v.Timestamp == 0 {
+			v.Timestamp = timestamp
+		}
+	case *commands.AccountTransaction:
+		if v.Timestamp == 0 {
+			v.Timestamp = timestamp
+		}
+	case *commands.SystemEvent:
+		if v.Timestamp == 0 {
+			v.Timestamp = timestamp
+		}
+	case *commands.ErrorEvent:
+		if v.Timestamp == 0 {
+			v.Timestamp = timestamp
+		}
+	}
+}
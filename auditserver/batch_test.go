@@ -0,0 +1,67 @@
+package main
+
+import (
+	"seng468/auditserver/commands"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONBatch(t *testing.T) {
+	body := `{"type":"userCommand","timestamp":"10","server":"web","transactionNum":"1","command":"ADD","username":"bob","funds":"1.00"}
+
+{"type":"accountTransaction","server":"trans","transactionNum":"1","action":"add","username":"bob","funds":"1.00"}
+`
+	events, err := decodeJSONBatch(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	user, ok := events[0].(*commands.UserCommand)
+	if !ok || user.Timestamp != 10 || user.Command != "ADD" || user.Funds != "1.00" {
+		t.Errorf("bad userCommand: %+v", events[0])
+	}
+
+	setDefaultTimestamp(events[1], 20)
+	account, ok := events[1].(*commands.AccountTransaction)
+	if !ok || account.Timestamp != 20 || account.Action != "add" {
+		t.Errorf("bad accountTransaction: %+v", events[1])
+	}
+}
+
+func TestDecodeJSONBatchRejects(t *testing.T) {
+	for _, body := range []string{
+		`{"type":"nope"}`,
+		`{"type":"userCommand","timestamp":"soon"}`,
+		`{"type":"userCommand"`,
+	} {
+		if _, err := decodeJSONBatch(strings.NewReader(body)); err == nil {
+			t.Errorf("decodeJSONBatch(%q) succeeded", body)
+		}
+	}
+}
+
+func TestDecodeXMLBatch(t *testing.T) {
+	body := `<log>
+  <userCommand><timestamp>10</timestamp><server>web</server><transactionNum>1</transactionNum><command>ADD</command><username>bob</username></userCommand>
+  <errorEvent><server>trans</server><transactionNum>2</transactionNum><command>BUY</command><errorMessage>no funds</errorMessage></errorEvent>
+</log>`
+	events, err := decodeXMLBatch(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if h := commands.Describe(events[0]); h.Type != "userCommand" || h.Timestamp != 10 || h.Username != "bob" {
+		t.Errorf("bad first event: %+v", h)
+	}
+	if e, ok := events[1].(*commands.ErrorEvent); !ok || e.ErrorMessage != "no funds" {
+		t.Errorf("bad second event: %+v", events[1])
+	}
+
+	if _, err := decodeXMLBatch(strings.NewReader(`<bogus/>`)); err == nil {
+		t.Error("decodeXMLBatch accepted an unknown element")
+	}
+}
@@ -70,6 +70,17 @@ type ErrorEvent struct {
 	ErrorMessage   string   `xml:"errorMessage,omitempty"`
 }
 
+// Span is one timed step of a transaction on one server. Spans are kept
+// apart from the events above and are never part of a dumped log.
+type Span struct {
+	XMLName        xml.Name `xml:"span"`
+	Server         string   `xml:"server"`
+	TransactionNum string   `xml:"transactionNum"`
+	Name           string   `xml:"name"`
+	Start          int64    `xml:"start"`    // µs since the epoch
+	Duration       int64    `xml:"duration"` // µs
+}
+
 // String returns a string representation of userCommand
 func (u *UserCommand) String() string {
 	return string(u.Byte())
@@ -144,3 +155,86 @@ func (u *ErrorEvent) Byte() []byte {
 
 	return output
 }
+
+// String returns a string representation of Span
+func (u *Span) String() string {
+	return string(u.Byte())
+}
+
+// Byte returns byte array of Span
+func (u *Span) Byte() []byte {
+	output, err := xml.MarshalIndent(u, "  ", "    ")
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+
+	return output
+}
+
+// Decode parses a single XML encoded command, using its root element to
+// decide which type of command it is.
+func Decode(data []byte) (Command, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	c, err := New(root.XMLName.Local)
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// New returns an empty command of the type named by its XML element
+func New(name string) (Command, error) {
+	switch name {
+	case "userCommand":
+		return new(UserCommand), nil
+	case "quoteServer":
+		return new(QuoteServer), nil
+	case "accountTransaction":
+		return new(AccountTransaction), nil
+	case "systemEvent":
+		return new(SystemEvent), nil
+	case "errorEvent":
+		return new(ErrorEvent), nil
+	case "span":
+		return new(Span), nil
+	}
+	return nil, fmt.Errorf("unknown command type %q", name)
+}
+
+// Header holds the fields common to every type of command
+type Header struct {
+	Type           string
+	Timestamp      int64
+	Server         string
+	TransactionNum string
+	Command        string
+	Username       string
+}
+
+// Describe returns the common fields of c
+func Describe(c Command) Header {
+	switch v := c.(type) {
+	case *UserCommand:
+		return Header{"userCommand", v.Timestamp, v.Server, v.TransactionNum, v.Command, v.Username}
+	case *QuoteServer:
+		return Header{"quoteServer", v.Timestamp, v.Server, v.TransactionNum, "", v.Username}
+	case *AccountTransaction:
+		return Header{"accountTransaction", v.Timestamp, v.Server, v.TransactionNum, "", v.Username}
+	case *SystemEvent:
+		return Header{"systemEvent", v.Timestamp, v.Server, v.TransactionNum, v.Command, v.Username}
+	case *ErrorEvent:
+		return Header{"errorEvent", v.Timestamp, v.Server, v.TransactionNum, v.Command, v.Username}
+	case *Span:
+		return Header{"span", v.Start / 1000, v.Server, v.TransactionNum, "", ""}
+	}
+	return Header{}
+}
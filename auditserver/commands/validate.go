@@ -0,0 +1,354 @@
+package commands
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// The unixTimeLimits of logfile.xsd, Jan 1 to May 1 2018 in ms
+const (
+	SchemaMinTimestamp int64 = 1514764800000
+	SchemaMaxTimestamp int64 = 1525132800000
+)
+
+// Validator checks commands against the rules of logfile.xsd.
+// Required string fields must also be non-empty, which is stricter than the
+// schema but catches events sent without the field.
+type Validator struct {
+	// Timestamps outside of MinTimestamp to MaxTimestamp are rejected.
+	// Zero values leave that end unbounded.
+	MinTimestamp int64
+	MaxTimestamp int64
+}
+
+// SchemaValidator enforces the semester time limits of the schema
+var SchemaValidator = Validator{SchemaMinTimestamp, SchemaMaxTimestamp}
+
+var (
+	integerPattern         = regexp.MustCompile(`^[+-]?[0-9]+$`)
+	positiveIntegerPattern = regexp.MustCompile(`^\+?0*[1-9][0-9]*$`)
+	decimalPattern         = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+)
+
+// commandTypes is the commandType enumeration of the schema
+var commandTypes = map[string]bool{
+	"ADD": true, "QUOTE": true, "BUY": true, "COMMIT_BUY": true, "CANCEL_BUY": true,
+	"SELL": true, "COMMIT_SELL": true, "CANCEL_SELL": true, "SET_BUY_AMOUNT": true,
+	"CANCEL_SET_BUY": true, "SET_BUY_TRIGGER": true, "SET_SELL_AMOUNT": true,
+	"SET_SELL_TRIGGER": true, "CANCEL_SET_SELL": true, "DUMPLOG": true, "DISPLAY_SUMMARY": true,
+}
+
+type fieldRule struct {
+	name     string
+	required bool
+	check    func(v Validator, value string) error
+}
+
+func anyString(v Validator, value string) error {
+	return nil
+}
+
+func nonEmpty(v Validator, value string) error {
+	if value == "" {
+		return errors.New("is empty")
+	}
+	return nil
+}
+
+func timestamp(v Validator, value string) error {
+	if !integerPattern.MatchString(value) {
+		return errors.New("is not an integer")
+	}
+	var ts int64
+	fmt.Sscan(value, &ts)
+	if ts <= 0 || (v.MinTimestamp != 0 && ts < v.MinTimestamp) || (v.MaxTimestamp != 0 && ts > v.MaxTimestamp) {
+		return fmt.Errorf("%d is out of range", ts)
+	}
+	return nil
+}
+
+func positiveInteger(v Validator, value string) error {
+	if !positiveIntegerPattern.MatchString(value) {
+		return fmt.Errorf("%q is not a positive integer", value)
+	}
+	return nil
+}
+
+func integer(v Validator, value string) error {
+	if !integerPattern.MatchString(value) {
+		return fmt.Errorf("%q is not an integer", value)
+	}
+	return nil
+}
+
+func decimal(v Validator, value string) error {
+	if !decimalPattern.MatchString(value) {
+		return fmt.Errorf("%q is not a decimal", value)
+	}
+	return nil
+}
+
+func commandType(v Validator, value string) error {
+	if !commandTypes[value] {
+		return fmt.Errorf("%q is not a known command", value)
+	}
+	return nil
+}
+
+func stockSymbol(v Validator, value string) error {
+	if len(value) > 3 {
+		return fmt.Errorf("%q is longer than 3 characters", value)
+	}
+	return nil
+}
+
+// commandRules are shared by userCommand, systemEvent and errorEvent
+var commandRules = []fieldRule{
+	{"timestamp", true, timestamp},
+	{"server", true, nonEmpty},
+	{"transactionNum", true, positiveInteger},
+	{"command", true, commandType},
+	{"username", false, anyString},
+	{"stockSymbol", false, stockSymbol},
+	{"filename", false, anyString},
+	{"funds", false, decimal},
+}
+
+// schema holds the fields of each type of event in logfile.xsd
+var schema = map[string][]fieldRule{
+	"userCommand": commandRules,
+	"quoteServer": {
+		{"timestamp", true, timestamp},
+		{"server", true, nonEmpty},
+		{"transactionNum", true, positiveInteger},
+		{"price", true, decimal},
+		{"stockSymbol", true, func(v Validator, value string) error {
+			if err := nonEmpty(v, value); err != nil {
+				return err
+			}
+			return stockSymbol(v, value)
+		}},
+		{"username", true, nonEmpty},
+		{"quoteServerTime", true, integer},
+		{"cryptokey", true, nonEmpty},
+	},
+	"accountTransaction": {
+		{"timestamp", true, timestamp},
+		{"server", true, nonEmpty},
+		{"transactionNum", true, positiveInteger},
+		{"action", true, nonEmpty},
+		{"username", true, nonEmpty},
+		{"funds", true, decimal},
+	},
+	"systemEvent": commandRules,
+	"errorEvent": append(commandRules[:len(commandRules):len(commandRules)],
+		fieldRule{"errorMessage", false, anyString}),
+	"debugEvent": append(commandRules[:len(commandRules):len(commandRules)],
+		fieldRule{"debugMessage", false, anyString}),
+}
+
+// Validate checks the fields of c against the schema
+func (v Validator) Validate(c Command) error {
+	typ, fields := elements(c)
+	return v.validateFields(typ, fields)
+}
+
+// ValidateSpan checks that s names its transaction and step
+func ValidateSpan(s *Span) error {
+	if s.Server == "" || s.Name == "" {
+		return errors.New("span: missing server or name")
+	}
+	if err := positiveInteger(Validator{}, s.TransactionNum); err != nil {
+		return fmt.Errorf("span: transactionNum %s", err.Error())
+	}
+	if s.Start <= 0 || s.Duration < 0 {
+		return errors.New("span: start or duration out of range")
+	}
+	return nil
+}
+
+// Fields returns the elements of c keyed by name, along with its type under
+// "type", in the same form the /batch endpoint takes as a JSON line
+func Fields(c Command) map[string]string {
+	typ, fields := elements(c)
+	fields["type"] = typ
+	return fields
+}
+
+func (v Validator) validateFields(typ string, fields map[string]string) error {
+	rules, ok := schema[typ]
+	if !ok {
+		return fmt.Errorf("unknown event type %q", typ)
+	}
+	for name := range fields {
+		if !hasRule(rules, name) {
+			return fmt.Errorf("%s: unexpected element %s", typ, name)
+		}
+	}
+	for _, rule := range rules {
+		value, present := fields[rule.name]
+		if !present {
+			if rule.required {
+				return fmt.Errorf("%s: missing %s", typ, rule.name)
+			}
+			continue
+		}
+		if err := rule.check(v, value); err != nil {
+			return fmt.Errorf("%s: %s %s", typ, rule.name, err.Error())
+		}
+	}
+	return nil
+}
+
+func hasRule(rules []fieldRule, name string) bool {
+	for _, rule := range rules {
+		if rule.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// elements returns the type of c and the child elements it is dumped with
+func elements(c Command) (string, map[string]string) {
+	fields := make(map[string]string)
+	put := func(name, value string, omitempty bool) {
+		if value != "" || !omitempty {
+			fields[name] = value
+		}
+	}
+	h := Describe(c)
+	fields["timestamp"] = fmt.Sprint(h.Timestamp)
+	put("server", h.Server, false)
+	put("transactionNum", h.TransactionNum, false)
+
+	switch v := c.(type) {
+	case *UserCommand:
+		put("command", v.Command, false)
+		put("username", v.Username, true)
+		put("stockSymbol", v.StockSymbol, true)
+		put("filename", v.Filename, true)
+		put("funds", v.Funds, true)
+	case *QuoteServer:
+		put("price", v.Price, false)
+		put("stockSymbol", v.StockSymbol, false)
+		put("username", v.Username, false)
+		put("quoteServerTime", v.QuoteServerTime, false)
+		put("cryptokey", v.Cryptokey, false)
+	case *AccountTransaction:
+		put("action", v.Action, false)
+		put("username", v.Username, true)
+		put("funds", v.Funds, true)
+	case *SystemEvent:
+		put("command", v.Command, false)
+		put("username", v.Username, true)
+		put("stockSymbol", v.StockSymbol, true)
+		put("filename", v.Filename, true)
+		put("funds", v.Funds, true)
+	case *ErrorEvent:
+		put("command", v.Command, false)
+		put("username", v.Username, true)
+		put("stockSymbol", v.StockSymbol, true)
+		put("filename", v.Filename, true)
+		put("funds", v.Funds, true)
+		put("errorMessage", v.ErrorMessage, true)
+	}
+	return h.Type, fields
+}
+
+// DumpError describes an invalid event found by ValidateDump
+type DumpError struct {
+	Line  int // line of the event's start element
+	Event int // index of the event in the log, from 1
+	Err   error
+}
+
+func (e DumpError) Error() string {
+	return fmt.Sprintf("line %d, event %d: %s", e.Line, e.Event, e.Err.Error())
+}
+
+// ValidateDump checks a dumped log read from r against the schema, returning
+// the number of events checked and an error for each one that is invalid.
+// An error that stops the document from being read at all is returned as err.
+func (v Validator) ValidateDump(r io.Reader) (count int, invalid []DumpError, err error) {
+	dec := xml.NewDecoder(r)
+
+	start, err := nextStart(dec)
+	if err != nil {
+		return 0, nil, err
+	}
+	if start.Name.Local != "log" {
+		return 0, nil, fmt.Errorf("root element is %s, not log", start.Name.Local)
+	}
+
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return count, invalid, fmt.Errorf("log is not closed: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			return count, invalid, nil
+		case xml.StartElement:
+			count++
+			line, _ := dec.InputPos()
+			fields, repeated, err := readFields(dec)
+			if err != nil {
+				return count, invalid, err
+			}
+			if repeated != "" {
+				err = fmt.Errorf("%s: repeated element %s", t.Name.Local, repeated)
+			} else {
+				err = v.validateFields(t.Name.Local, fields)
+			}
+			if err != nil {
+				invalid = append(invalid, DumpError{line, count, err})
+			}
+		case xml.CharData:
+			if strings.TrimSpace(string(t)) != "" {
+				return count, invalid, fmt.Errorf("unexpected text %q in log", strings.TrimSpace(string(t)))
+			}
+		}
+	}
+}
+
+func nextStart(dec *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// readFields reads the child elements of an event up to its end element.
+// The schema uses xsd:all, so the name of any repeated element is returned.
+func readFields(dec *xml.Decoder) (fields map[string]string, repeated string, err error) {
+	fields = make(map[string]string)
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			var value string
+			if err := dec.DecodeElement(&value, &t); err != nil {
+				return nil, "", err
+			}
+			if _, seen := fields[t.Name.Local]; seen {
+				repeated = t.Name.Local
+			}
+			fields[t.Name.Local] = strings.TrimSpace(value)
+		case xml.EndElement:
+			return fields, repeated, nil
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	quote := func(edit func(q *QuoteServer)) *QuoteServer {
+		q := &QuoteServer{
+			Timestamp:       1520000000000,
+			Server:          "quoteserver",
+			TransactionNum:  "1",
+			Price:           "12.50",
+			StockSymbol:     "ABC",
+			Username:        "bob",
+			QuoteServerTime: "1520000000000",
+			Cryptokey:       "key",
+		}
+		if edit != nil {
+			edit(q)
+		}
+		return q
+	}
+
+	tests := []struct {
+		name  string
+		c     Command
+		valid bool
+	}{
+		{"quote", quote(nil), true},
+		{"quote without price", quote(func(q *QuoteServer) { q.Price = "" }), false},
+		{"quote with long stock", quote(func(q *QuoteServer) { q.StockSymbol = "ABCD" }), false},
+		{"quote with zero transNum", quote(func(q *QuoteServer) { q.TransactionNum = "0" }), false},
+		{"quote with bad server time", quote(func(q *QuoteServer) { q.QuoteServerTime = "soon" }), false},
+		{"user command", &UserCommand{Timestamp: 1, Server: "web", TransactionNum: "2", Command: "ADD", Username: "bob", Funds: "10"}, true},
+		{"user command without funds", &UserCommand{Timestamp: 1, Server: "web", TransactionNum: "2", Command: "DUMPLOG"}, true},
+		{"unknown command", &UserCommand{Timestamp: 1, Server: "web", TransactionNum: "2", Command: "STEAL"}, false},
+		{"bad funds", &SystemEvent{Timestamp: 1, Server: "trans", TransactionNum: "2", Command: "BUY", Funds: "1,000"}, false},
+		{"account without username", &AccountTransaction{Timestamp: 1, Server: "trans", TransactionNum: "3", Action: "add", Funds: "1.00"}, false},
+		{"account", &AccountTransaction{Timestamp: 1, Server: "trans", TransactionNum: "3", Action: "add", Username: "bob", Funds: "1.00"}, true},
+		{"error event", &ErrorEvent{Timestamp: 1, Server: "trans", TransactionNum: "4", Command: "SELL", ErrorMessage: "no stock"}, true},
+		{"error event with long stock", &ErrorEvent{Timestamp: 1, Server: "quoteserver", TransactionNum: "4", Command: "QUOTE", StockSymbol: "ABCDEF", ErrorMessage: "bad symbol"}, false},
+		{"user command with long stock", &UserCommand{Timestamp: 1, Server: "web", TransactionNum: "2", Command: "QUOTE", StockSymbol: "ABCD"}, false},
+		{"no timestamp", &ErrorEvent{Server: "trans", TransactionNum: "4", Command: "SELL"}, false},
+	}
+	for _, test := range tests {
+		err := Validator{}.Validate(test.c)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: Validate() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+
+	if err := SchemaValidator.Validate(quote(nil)); err != nil {
+		t.Errorf("quote inside the semester: %v", err)
+	}
+	if err := SchemaValidator.Validate(quote(func(q *QuoteServer) { q.Timestamp = 1600000000000 })); err == nil {
+		t.Error("quote after the semester was accepted")
+	}
+}
+
+func TestValidateDump(t *testing.T) {
+	dump := `<?xml version="1.0"?>
+<log>
+  <userCommand>
+    <timestamp>1520000000000</timestamp>
+    <server>web</server>
+    <transactionNum>1</transactionNum>
+    <command>ADD</command>
+    <username>bob</username>
+    <funds>10.00</funds>
+  </userCommand>
+  <quoteServer>
+    <timestamp>1520000000000</timestamp>
+    <server>quoteserver</server>
+    <transactionNum>2</transactionNum>
+    <stockSymbol>ABC</stockSymbol>
+    <username>bob</username>
+    <quoteServerTime>1520000000000</quoteServerTime>
+    <cryptokey>key</cryptokey>
+  </quoteServer>
+  <accountTransaction>
+    <timestamp>1520000000000</timestamp>
+    <server>trans</server>
+    <transactionNum>1</transactionNum>
+    <action>add</action>
+    <username>bob</username>
+    <funds>10.00</funds>
+    <funds>10.00</funds>
+  </accountTransaction>
+  <debugEvent>
+    <timestamp>1520000000000</timestamp>
+    <server>trans</server>
+    <transactionNum>3</transactionNum>
+    <command>BUY</command>
+    <debugMessage>hi</debugMessage>
+  </debugEvent>
+</log>
+`
+	count, invalid, err := SchemaValidator.ValidateDump(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("checked %d events, want 4", count)
+	}
+	if len(invalid) != 2 || invalid[0].Event != 2 || invalid[1].Event != 3 {
+		t.Fatalf("invalid = %v, want events 2 and 3", invalid)
+	}
+	if !strings.Contains(invalid[0].Error(), "missing price") {
+		t.Errorf("event 2: %v", invalid[0])
+	}
+	if !strings.Contains(invalid[1].Error(), "repeated element funds") {
+		t.Errorf("event 3: %v", invalid[1])
+	}
+
+	if _, _, err := SchemaValidator.ValidateDump(strings.NewReader(`<events></events>`)); err == nil {
+		t.Error("accepted a document without a log root")
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"seng468/auditserver/log"
+	"time"
+)
+
+// Config holds the settings of the audit server
+type Config struct {
+	AuditAddr     string        `key:"auditaddr" help:"host this server is reached at"`
+	AuditPort     string        `key:"auditport" required:"true" help:"port to serve on"`
+	DrainTimeout  time.Duration `key:"shutdowntimeout" default:"20" unit:"s" help:"time to let requests finish at shutdown"`
+	LogDir        string        `key:"auditlogdir" default:"./auditlog" help:"directory the log is stored in"`
+	Fsync         string        `key:"auditfsync" default:"interval" help:"always, interval or never"`
+	FsyncInterval time.Duration `key:"auditfsyncinterval" default:"1" unit:"s" help:"time between syncs under the interval policy"`
+	SegmentSize   int64         `key:"auditsegmentsize" default:"67108864" help:"bytes written to a log segment before starting the next"`
+	IndexCache    int           `key:"auditindexcache" default:"8" help:"sealed segment indexes kept in memory for queries"`
+	SchemaTime    bool          `key:"auditschematime" help:"reject events outside the schema's semester time limits"`
+}
+
+// Validate checks the settings that can't be wrong on their own
+func (c *Config) Validate() error {
+	_, err := c.LogOptions()
+	return err
+}
+
+// LogOptions tunes how the log is stored on disk
+func (c *Config) LogOptions() (log.Options, error) {
+	fsync, err := log.ParseFsyncPolicy(c.Fsync)
+	opts := log.Options{
+		MaxSegmentBytes: c.SegmentSize,
+		Fsync:           fsync,
+		FsyncInterval:   c.FsyncInterval,
+		IndexCache:      c.IndexCache,
+	}
+	return opts, err
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"errors"
+	"seng468/auditserver/health"
+)
+
+// readinessChecks reports the audit server unready while events are
+// arriving faster than they can be written to the log
+func readinessChecks() *health.Checker {
+	checks := new(health.Checker)
+	checks.Add("log", func() error {
+		if len(logChannel) == cap(logChannel) {
+			return errors.New("queue of events to write is full")
+		}
+		return nil
+	})
+	return checks
+}
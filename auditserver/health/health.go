@@ -0,0 +1,143 @@
+// Package health serves the /healthz and /readyz endpoints of a service.
+// /healthz only reports that the process is up and serving, while /readyz
+// also checks that the services it depends on can be reached.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Timeout bounds how long a single check may take
+var Timeout = 2 * time.Second
+
+// Check returns an error if a dependency can't be used
+type Check func() error
+
+// Checker holds the dependency checks run by /readyz
+type Checker struct {
+	lock   sync.Mutex
+	checks map[string]Check
+}
+
+// Add registers a check under name, replacing any check already there
+func (c *Checker) Add(name string, check Check) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.checks == nil {
+		c.checks = make(map[string]Check)
+	}
+	c.checks[name] = check
+}
+
+// Run runs every check at once and returns their results by name,
+// nil for those that passed. Checks taking longer than Timeout fail.
+func (c *Checker) Run() map[string]error {
+	c.lock.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.lock.Unlock()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			err := runWithTimeout(check)
+			lock.Lock()
+			results[name] = err
+			lock.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
+
+func runWithTimeout(check Check) error {
+	done := make(chan error, 1)
+	go func() { done <- check() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(Timeout):
+		return errors.New("timed out")
+	}
+}
+
+// Live handles /healthz, which succeeds as long as the server can answer
+func Live(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+// Ready handles /readyz, replying 200 if every check passes and 503 if any
+// fail, with the result of each check on its own line
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	results := c.Run()
+	names := make([]string, 0, len(results))
+	ready := true
+	for name, err := range results {
+		names = append(names, name)
+		if err != nil {
+			ready = false
+		}
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			fmt.Fprintf(w, "%s: %v\n", name, err)
+		} else {
+			fmt.Fprintf(w, "%s: ok\n", name)
+		}
+	}
+	if len(names) == 0 {
+		w.Write([]byte("OK\n"))
+	}
+}
+
+// Register serves /healthz and /readyz for c on mux
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", Live)
+	mux.HandleFunc("/readyz", c.Ready)
+}
+
+// Dial checks that a TCP connection can be opened to addr
+func Dial(addr string) Check {
+	return func() error {
+		conn, err := net.DialTimeout("tcp", addr, Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Get checks that url answers a GET request without a server error,
+// for services that serve /healthz themselves
+func Get(url string) Check {
+	client := http.Client{Timeout: Timeout}
+	return func() error {
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s replied %s", url, resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package log
+
+import (
+	"seng468/auditserver/commands"
+)
+
+// Filter selects which entries of the log are written.
+// Zero valued fields match every entry.
+type Filter struct {
+	Username       string
+	TransactionNum string
+	From           int64    // earliest timestamp, in ms
+	To             int64    // latest timestamp, in ms
+	Types          []string // e.g. userCommand, errorEvent
+	Commands       []string // e.g. BUY, COMMIT_SELL
+	Servers        []string // e.g. webserver, quoteserver
+}
+
+// Matches reports whether the entry described by h passes the filter
+func (f Filter) Matches(h commands.Header) bool {
+	if f.Username != "" && h.Username != f.Username {
+		return false
+	}
+	if f.TransactionNum != "" && h.TransactionNum != f.TransactionNum {
+		return false
+	}
+	if f.From != 0 && h.Timestamp < f.From {
+		return false
+	}
+	if f.To != 0 && h.Timestamp > f.To {
+		return false
+	}
+	if len(f.Types) > 0 && !contains(f.Types, h.Type) {
+		return false
+	}
+	if len(f.Commands) > 0 && !contains(f.Commands, h.Command) {
+		return false
+	}
+	if len(f.Servers) > 0 && !contains(f.Servers, h.Server) {
+		return false
+	}
+	return true
+}
+
+// overlaps reports whether the segment s could hold entries in the
+// filter's time range
+func (f Filter) overlaps(s segment) bool {
+	if s.count == 0 {
+		return false
+	}
+	if f.From != 0 && s.maxTime < f.From {
+		return false
+	}
+	if f.To != 0 && s.minTime > f.To {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
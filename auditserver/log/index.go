@@ -0,0 +1,252 @@
+package log
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"seng468/auditserver/commands"
+	"strings"
+	"sync"
+)
+
+// position locates one entry within the segments of the log
+type position struct {
+	segment int
+	offset  int64
+	length  int32
+}
+
+// index maps usernames and transaction numbers to the entries of a segment
+// that mention them. Only the active segment's index is kept in memory, the
+// index of every other segment is written next to it when it is sealed.
+type index struct {
+	users    map[string][]position
+	transNum map[string][]position
+}
+
+func newIndex() index {
+	return index{
+		users:    make(map[string][]position),
+		transNum: make(map[string][]position),
+	}
+}
+
+func (i index) add(h commands.Header, p position) {
+	if h.Username != "" {
+		i.users[h.Username] = append(i.users[h.Username], p)
+	}
+	if h.TransactionNum != "" {
+		i.transNum[h.TransactionNum] = append(i.transNum[h.TransactionNum], p)
+	}
+}
+
+// candidates returns the positions that could match f, or ok=false if
+// f isn't narrowed by an indexed field and the whole segment must be scanned.
+func (i index) candidates(f Filter) (positions []position, ok bool) {
+	byUser, userOk := i.users[f.Username]
+	byTrans, transOk := i.transNum[f.TransactionNum]
+	switch {
+	case f.Username != "" && f.TransactionNum != "":
+		if !userOk || !transOk {
+			return nil, true
+		}
+		if len(byUser) < len(byTrans) {
+			return byUser, true
+		}
+		return byTrans, true
+	case f.Username != "":
+		return byUser, true
+	case f.TransactionNum != "":
+		return byTrans, true
+	}
+	return nil, false
+}
+
+// indexHeader starts an index file, so the log can be opened by reading the
+// header of each sealed segment's index instead of the whole segment
+type indexHeader struct {
+	Count   int
+	MinTime int64
+	MaxTime int64
+}
+
+// indexEntry is a position within the segment an index file belongs to
+type indexEntry struct {
+	Offset int64
+	Length int32
+}
+
+// indexFile is what follows the header in an index file
+type indexFile struct {
+	Users    map[string][]indexEntry
+	TransNum map[string][]indexEntry
+}
+
+func indexPath(s segment) string {
+	return strings.TrimSuffix(s.path, ".log") + ".idx"
+}
+
+func toEntries(m map[string][]position) map[string][]indexEntry {
+	entries := make(map[string][]indexEntry, len(m))
+	for key, positions := range m {
+		list := make([]indexEntry, len(positions))
+		for n, p := range positions {
+			list[n] = indexEntry{p.offset, p.length}
+		}
+		entries[key] = list
+	}
+	return entries
+}
+
+func toPositions(m map[string][]indexEntry, segmentNum int) map[string][]position {
+	positions := make(map[string][]position, len(m))
+	for key, entries := range m {
+		list := make([]position, len(entries))
+		for n, e := range entries {
+			list[n] = position{segmentNum, e.Offset, e.Length}
+		}
+		positions[key] = list
+	}
+	return positions
+}
+
+// writeIndex stores i as the index of the sealed segment s. It is written to
+// a temporary file first, so an index file is either whole or missing.
+func writeIndex(s segment, i index) error {
+	path := indexPath(s)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	err = enc.Encode(indexHeader{s.count, s.minTime, s.maxTime})
+	if err == nil {
+		err = enc.Encode(indexFile{toEntries(i.users), toEntries(i.transNum)})
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readIndexHeader sets the count and time range of the sealed segment s from
+// its index file, without reading the index itself
+func readIndexHeader(s *segment) error {
+	f, err := os.Open(indexPath(*s))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var h indexHeader
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&h); err != nil {
+		return err
+	}
+	s.count, s.minTime, s.maxTime = h.Count, h.MinTime, h.MaxTime
+	return nil
+}
+
+// readIndex loads the index of the sealed segment s, the segmentNum-th of
+// the log
+func readIndex(s segment, segmentNum int) (index, error) {
+	f, err := os.Open(indexPath(s))
+	if err != nil {
+		return index{}, err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	var h indexHeader
+	var file indexFile
+	if err := dec.Decode(&h); err != nil {
+		return index{}, err
+	}
+	if err := dec.Decode(&file); err != nil {
+		return index{}, err
+	}
+	return index{toPositions(file.Users, segmentNum), toPositions(file.TransNum, segmentNum)}, nil
+}
+
+// rebuildIndex indexes the sealed segment s by reading it, then writes the
+// index out so it isn't read again. Used for segments sealed without one, by
+// a crash or before the log kept them.
+func rebuildIndex(s *segment, segmentNum int) (index, error) {
+	i := newIndex()
+	err := s.recover(false, func(h commands.Header, offset int64, length int32) {
+		i.add(h, position{segmentNum, offset, length})
+	})
+	if err != nil {
+		return index{}, err
+	}
+	if err := writeIndex(*s, i); err != nil {
+		fmt.Printf("error: writing index of %s: %v\n", s.path, err)
+	}
+	return i, nil
+}
+
+// indexCache holds the indexes of the sealed segments queried most recently,
+// so what stays in memory is bounded by the number of segments it keeps
+// rather than the length of the log
+type indexCache struct {
+	lock   sync.Mutex
+	size   int
+	loaded map[int]index
+	used   []int // segment numbers, least recently used first
+}
+
+func newIndexCache(size int) *indexCache {
+	if size < 1 {
+		size = 1
+	}
+	return &indexCache{size: size, loaded: make(map[int]index)}
+}
+
+// get returns the index of the sealed segment s, the segmentNum-th of the
+// log, reading it from disk if it isn't cached
+func (c *indexCache) get(s segment, segmentNum int) (index, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	i, ok := c.loaded[segmentNum]
+	if ok {
+		c.touch(segmentNum)
+		return i, nil
+	}
+	i, err := readIndex(s, segmentNum)
+	if err != nil {
+		fmt.Printf("Rebuilding index of %s: %v\n", s.path, err)
+		if i, err = rebuildIndex(&s, segmentNum); err != nil {
+			return index{}, err
+		}
+	}
+	if len(c.used) == c.size {
+		delete(c.loaded, c.used[0])
+		c.used = c.used[1:]
+	}
+	c.loaded[segmentNum] = i
+	c.used = append(c.used, segmentNum)
+	return i, nil
+}
+
+// touch moves segmentNum to the most recently used end of the list.
+// The caller must hold the lock.
+func (c *indexCache) touch(segmentNum int) {
+	for n, used := range c.used {
+		if used == segmentNum {
+			c.used = append(append(c.used[:n:n], c.used[n+1:]...), segmentNum)
+			return
+		}
+	}
+}
@@ -1,24 +1,243 @@
 package log
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"seng468/auditserver/commands"
+	"sync"
+	"time"
 )
 
-// Log contains a list of user commands
+// FsyncPolicy controls how often appended entries are flushed to stable storage
+type FsyncPolicy int
+
+const (
+	// FsyncAlways syncs the active segment after every insert
+	FsyncAlways FsyncPolicy = iota
+	// FsyncInterval syncs the active segment every Options.FsyncInterval
+	FsyncInterval
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever
+)
+
+// ParseFsyncPolicy reads a policy from "always", "interval" or "never"
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch s {
+	case "always":
+		return FsyncAlways, nil
+	case "interval", "":
+		return FsyncInterval, nil
+	case "never":
+		return FsyncNever, nil
+	}
+	return FsyncInterval, fmt.Errorf("unknown fsync policy %q", s)
+}
+
+// Options tune how the log is stored on disk
+type Options struct {
+	MaxSegmentBytes int64
+	Fsync           FsyncPolicy
+	FsyncInterval   time.Duration
+	IndexCache      int // indexes of sealed segments kept in memory
+}
+
+// DefaultOptions rotates segments at 64MB, syncs once a second and keeps
+// the indexes of 8 sealed segments in memory
+var DefaultOptions = Options{
+	MaxSegmentBytes: 64 << 20,
+	Fsync:           FsyncInterval,
+	FsyncInterval:   time.Second,
+	IndexCache:      8,
+}
+
+// Log is an append only list of user commands, stored as a directory of
+// segment files. Each segment holds one XML encoded command per line, and
+// each segment but the active one has an index file beside it.
 type Log struct {
-	XMLName xml.Name `xml:"log"`
-	Entries []commands.Command
+	dir      string
+	opts     Options
+	lock     sync.Mutex
+	segments []segment
+	active   *os.File
+	index    index // of the active segment
+	indexes  *indexCache
+	count    int
+	dirty    bool
+	done     chan struct{}
 }
 
-// Write takes in a writer object and writes the log to a file
-func (l *Log) Write(w io.Writer) {
-	enc := xml.NewEncoder(w)
-	enc.Indent("  ", "    ")
-	if err := enc.Encode(l.Entries); err != nil {
-		fmt.Printf("error: %v\n", err)
+// Open loads the log stored in dir, creating it if needed.
+// A partially written entry at the end of the log is discarded.
+func Open(dir string, opts Options) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:     dir,
+		opts:    opts,
+		index:   newIndex(),
+		indexes: newIndexCache(opts.IndexCache),
+		done:    make(chan struct{}),
+	}
+	for i := range segments {
+		segmentNum := i
+		if i < len(segments)-1 {
+			err = segments[i].open(segmentNum)
+		} else {
+			err = segments[i].recover(true, func(h commands.Header, offset int64, length int32) {
+				l.index.add(h, position{segmentNum, offset, length})
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.count += segments[i].count
+	}
+	if len(segments) == 0 {
+		segments = append(segments, newSegment(dir, 1))
+	}
+	l.segments = segments
+
+	last := &l.segments[len(l.segments)-1]
+	l.active, err = os.OpenFile(last.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Fsync == FsyncInterval {
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+// Insert takes a command object and appends it to the log
+func (l *Log) Insert(c commands.Command) error {
+	data, err := xml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.active == nil {
+		return errors.New("log is closed")
+	}
+	last := &l.segments[len(l.segments)-1]
+	if last.size > 0 && last.size+int64(len(data)) > l.opts.MaxSegmentBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+		last = &l.segments[len(l.segments)-1]
+	}
+
+	offset := last.size
+	n, err := l.active.Write(data)
+	last.size += int64(n)
+	if err != nil {
+		return err
+	}
+	h := commands.Describe(c)
+	last.note(h)
+	l.index.add(h, position{len(l.segments) - 1, offset, int32(n)})
+	l.count++
+
+	if l.opts.Fsync == FsyncAlways {
+		return l.active.Sync()
+	}
+	l.dirty = true
+	return nil
+}
+
+// rotate closes the active segment, writes out its index and starts a new
+// segment. The caller must hold the lock.
+func (l *Log) rotate() error {
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	// Without its index file the segment is indexed again when next queried
+	sealed := l.segments[len(l.segments)-1]
+	if err := writeIndex(sealed, l.index); err != nil {
+		fmt.Printf("error: writing index of %s: %v\n", sealed.path, err)
+	}
+	l.index = newIndex()
+
+	next := newSegment(l.dir, l.segments[len(l.segments)-1].id+1)
+	active, err := os.OpenFile(next.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.active = active
+	l.dirty = false
+	l.segments = append(l.segments, next)
+	return nil
+}
+
+func (l *Log) syncLoop() {
+	ticker := time.NewTicker(l.opts.FsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.lock.Lock()
+			if l.dirty && l.active != nil {
+				if err := l.active.Sync(); err != nil {
+					fmt.Printf("error: %v\n", err)
+				}
+				l.dirty = false
+			}
+			l.lock.Unlock()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Len returns the number of entries in the log
+func (l *Log) Len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.count
+}
+
+// Write takes in a writer object and writes the entries of the log
+// matching f to it as XML
+func (l *Log) Write(w io.Writer, f Filter) error {
+	return l.Snapshot(f).Write(w)
+}
+
+// Snapshot captures the entries of the log matching f as they are right now.
+// The lock is only held while taking the snapshot, so inserts carry on
+// while the snapshot is written out.
+func (l *Log) Snapshot(f Filter) *Snapshot {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Segments and index lists are only ever appended to, so copies of
+	// their current length stay valid after the lock is released. Sealed
+	// segments don't change, so their indexes are read as the snapshot is.
+	segments := make([]segment, len(l.segments))
+	copy(segments, l.segments)
+	active, indexed := l.index.candidates(f)
+	return &Snapshot{
+		filter:   f,
+		segments: segments,
+		indexes:  l.indexes,
+		active:   active,
+		indexed:  indexed,
 	}
 }
 
@@ -29,15 +248,26 @@ func (l *Log) String() string {
 
 // Byte returns an XML representation of the log
 func (l *Log) Byte() []byte {
-	output, err := xml.MarshalIndent(l, "  ", "    ")
-	if err != nil {
+	var buf bytes.Buffer
+	if err := l.Write(&buf, Filter{}); err != nil {
 		fmt.Printf("error: %v\n", err)
 	}
-
-	return output
+	return buf.Bytes()
 }
 
-// Insert takes a command object and inserts it into the log
-func (l *Log) Insert(c commands.Command) {
-	l.Entries = append(l.Entries, c)
+// Close syncs and closes the active segment
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.active == nil {
+		return nil
+	}
+	close(l.done)
+	err := l.active.Sync()
+	if closeErr := l.active.Close(); err == nil {
+		err = closeErr
+	}
+	l.active = nil
+	return err
 }
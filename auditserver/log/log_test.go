@@ -0,0 +1,248 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"seng468/auditserver/commands"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLogSurvivesReopen(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions
+	opts.MaxSegmentBytes = 512
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		err := l.Insert(&commands.UserCommand{
+			Timestamp:      1521131234567,
+			Server:         "webserver",
+			TransactionNum: "1",
+			Command:        "ADD",
+			Username:       "user1",
+			Funds:          "10.00",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	if segments, _ := listSegments(dir); len(segments) < 2 {
+		t.Error("Expected log to rotate into multiple segments, got", len(segments))
+	}
+
+	l, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Len() != 20 {
+		t.Error("Expected 20 entries after reopening, got", l.Len())
+	}
+
+	var buf bytes.Buffer
+	if err := l.Write(&buf, Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "<userCommand>"); n != 20 {
+		t.Error("Expected 20 entries in dump, got", n)
+	}
+}
+
+func TestLogTruncatesTornWrite(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Insert(&commands.SystemEvent{Timestamp: 1521131234567, Server: "transactionserve", TransactionNum: "2", Command: "COMMIT_BUY"})
+	l.active.WriteString("<systemEvent><timestamp>15211")
+	l.Close()
+
+	l, err = Open(dir, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Len() != 1 {
+		t.Error("Expected torn entry to be dropped, got", l.Len())
+	}
+	l.Insert(&commands.SystemEvent{Timestamp: 1521131234568, Server: "transactionserve", TransactionNum: "3", Command: "COMMIT_SELL"})
+	if err := l.Write(ioutil.Discard, Filter{}); err != nil {
+		t.Error("Log should be readable after recovery:", err)
+	}
+}
+
+func TestLogFilteredWrite(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Insert(&commands.UserCommand{Timestamp: 1000, Server: "web", TransactionNum: "1", Command: "ADD", Username: "alice", Funds: "10.00"})
+	l.Insert(&commands.UserCommand{Timestamp: 2000, Server: "web", TransactionNum: "2", Command: "BUY", Username: "bob", StockSymbol: "ABC", Funds: "5.00"})
+	l.Insert(&commands.AccountTransaction{Timestamp: 2500, Server: "trans", TransactionNum: "1", Action: "add", Username: "alice", Funds: "10.00"})
+	l.Insert(&commands.UserCommand{Timestamp: 3000, Server: "web", TransactionNum: "3", Command: "BUY", Username: "alice", StockSymbol: "ABC", Funds: "5.00"})
+	l.Close()
+
+	// Reopen so the index is rebuilt from disk
+	l, err = Open(dir, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cases := []struct {
+		filter   Filter
+		expected int
+	}{
+		{Filter{}, 4},
+		{Filter{Username: "alice"}, 3},
+		{Filter{Username: "carol"}, 0},
+		{Filter{TransactionNum: "1"}, 2},
+		{Filter{Username: "alice", Types: []string{"userCommand"}}, 2},
+		{Filter{Username: "alice", From: 2000, To: 2600}, 1},
+		{Filter{Commands: []string{"BUY"}}, 2},
+		{Filter{Username: "bob", TransactionNum: "1"}, 0},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := l.Write(&buf, c.filter); err != nil {
+			t.Fatal(err)
+		}
+		n := strings.Count(buf.String(), "<timestamp>")
+		if n != c.expected {
+			t.Errorf("%+v: expected %d entries, got %d", c.filter, c.expected, n)
+		}
+	}
+}
+
+func TestSnapshotIgnoresLaterInserts(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	event := &commands.SystemEvent{Timestamp: 1000, Server: "trans", TransactionNum: "1", Command: "ADD", Username: "alice"}
+	for i := 0; i < 100; i++ {
+		l.Insert(event)
+	}
+	all := l.Snapshot(Filter{})
+	alice := l.Snapshot(Filter{Username: "alice"})
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			l.Insert(event)
+		}
+		done <- true
+	}()
+
+	for _, snap := range []*Snapshot{all, alice} {
+		var buf bytes.Buffer
+		if err := snap.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(buf.String(), "<systemEvent>"); n != 100 {
+			t.Error("Snapshot should hold 100 entries, got", n)
+		}
+	}
+	<-done
+}
+
+func TestSealedSegmentIndexes(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions
+	opts.MaxSegmentBytes = 512
+	opts.IndexCache = 2
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 40; i++ {
+		user := "alice"
+		if i%2 == 1 {
+			user = "bob"
+		}
+		l.Insert(&commands.UserCommand{Timestamp: int64(1000 + i), Server: "web", TransactionNum: strconv.Itoa(i),
+			Command: "ADD", Username: user, Funds: "10.00"})
+	}
+	l.Close()
+
+	segments, _ := listSegments(dir)
+	if len(segments) < 4 {
+		t.Fatal("Expected log to rotate into several segments, got", len(segments))
+	}
+	for _, seg := range segments[:len(segments)-1] {
+		if _, err := os.Stat(indexPath(seg)); err != nil {
+			t.Error("Sealed segment has no index:", err)
+		}
+	}
+	// An index lost in a crash is rebuilt when the log is opened, and one
+	// lost while it is open when a query needs it
+	os.Remove(indexPath(segments[1]))
+
+	l, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Len() != 40 {
+		t.Error("Expected 40 entries after reopening, got", l.Len())
+	}
+	if _, err := os.Stat(indexPath(segments[1])); err != nil {
+		t.Error("Missing index wasn't rebuilt:", err)
+	}
+	if len(l.indexes.loaded) != 0 {
+		t.Error("Opening the log loaded indexes:", len(l.indexes.loaded))
+	}
+
+	os.Remove(indexPath(segments[2]))
+	cases := []struct {
+		filter   Filter
+		expected int
+	}{
+		{Filter{Username: "alice"}, 20},
+		{Filter{TransactionNum: "7"}, 1},
+		{Filter{Username: "bob", From: 1010, To: 1019}, 5},
+		{Filter{From: 1030}, 10},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := l.Write(&buf, c.filter); err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(buf.String(), "<userCommand>"); n != c.expected {
+			t.Errorf("%+v: expected %d entries, got %d", c.filter, c.expected, n)
+		}
+		if len(l.indexes.loaded) > opts.IndexCache {
+			t.Errorf("%d indexes in memory, want at most %d", len(l.indexes.loaded), opts.IndexCache)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"seng468/auditserver/commands"
+	"sort"
+)
+
+// maxEntryBytes bounds the length of a single encoded command
+const maxEntryBytes = 1 << 20
+
+// segment is one file of the on disk log
+type segment struct {
+	id      int
+	path    string
+	size    int64
+	count   int
+	minTime int64 // earliest timestamp in the segment, in ms
+	maxTime int64 // latest timestamp in the segment, in ms
+}
+
+func newSegment(dir string, id int) segment {
+	return segment{
+		id:   id,
+		path: filepath.Join(dir, fmt.Sprintf("segment-%06d.log", id)),
+	}
+}
+
+// listSegments finds the segments stored in dir, oldest first
+func listSegments(dir string) ([]segment, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	for _, path := range paths {
+		var id int
+		if _, err := fmt.Sscanf(filepath.Base(path), "segment-%06d.log", &id); err != nil {
+			continue
+		}
+		segments = append(segments, newSegment(dir, id))
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].id < segments[j].id })
+	return segments, nil
+}
+
+// note counts the entry described by h as part of the segment
+func (s *segment) note(h commands.Header) {
+	if s.count == 0 || h.Timestamp < s.minTime {
+		s.minTime = h.Timestamp
+	}
+	if s.count == 0 || h.Timestamp > s.maxTime {
+		s.maxTime = h.Timestamp
+	}
+	s.count++
+}
+
+// recover sets the size, count and time range of the segment and calls fn
+// with each entry in it.
+// If last is set, a torn write at the end of the segment is truncated away.
+func (s *segment) recover(last bool, fn func(h commands.Header, offset int64, length int32)) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s.count = 0
+	var valid int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		c, err := commands.Decode(bytes.TrimSpace(line))
+		if err != nil {
+			fmt.Printf("Skipping unreadable entry at %s:%d: %v\n", s.path, valid, err)
+		} else {
+			h := commands.Describe(c)
+			s.note(h)
+			fn(h, valid, int32(len(line)))
+		}
+		valid += int64(len(line))
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	s.size = info.Size()
+	if valid != s.size && last {
+		fmt.Printf("Truncating %d bytes of partial entry from %s\n", s.size-valid, s.path)
+		if err := os.Truncate(s.path, valid); err != nil {
+			return err
+		}
+		s.size = valid
+	}
+	return nil
+}
+
+// open sets the size, count and time range of a sealed segment from the
+// header of its index, reading the segment only if the index is missing
+func (s *segment) open(segmentNum int) error {
+	if err := readIndexHeader(s); err != nil {
+		fmt.Printf("Rebuilding index of %s: %v\n", s.path, err)
+		_, err := rebuildIndex(s, segmentNum)
+		return err
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.size = info.Size()
+	return nil
+}
+
+// each decodes every entry in the segment in order, calling fn on each
+func (s segment) each(fn func(commands.Command) error) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(f, s.size))
+	scanner.Buffer(make([]byte, 64*1024), maxEntryBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		c, err := commands.Decode(line)
+		if err != nil {
+			continue
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// eachAt decodes the entries of the segment at positions in order, calling
+// fn on each
+func (s segment) eachAt(positions []position, fn func(commands.Command) error) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, p := range positions {
+		buf := make([]byte, p.length)
+		if _, err := f.ReadAt(buf, p.offset); err != nil {
+			return err
+		}
+		c, err := commands.Decode(bytes.TrimSpace(buf))
+		if err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package log
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"seng468/auditserver/commands"
+)
+
+// Stop can be returned from the function passed to Snapshot.Each to end it
+var Stop = errors.New("stop")
+
+// Snapshot is a consistent view of the entries in the log matching a filter
+type Snapshot struct {
+	filter   Filter
+	segments []segment
+	indexes  *indexCache
+	active   []position // candidates in the active segment
+	indexed  bool
+}
+
+// Write streams the snapshot to w as XML, one entry at a time
+func (s *Snapshot) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, "<log>\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("  ", "    ")
+	if err := s.Each(func(c commands.Command) error { return enc.Encode(c) }); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n</log>\n")
+	return err
+}
+
+// Each calls fn on every entry of the snapshot in order.
+// Returning Stop from fn ends the iteration early without an error.
+func (s *Snapshot) Each(fn func(commands.Command) error) error {
+	matching := func(c commands.Command) error {
+		if !s.filter.Matches(commands.Describe(c)) {
+			return nil
+		}
+		return fn(c)
+	}
+
+	var err error
+	if s.indexed {
+		err = s.eachIndexed(matching)
+	} else {
+		for _, seg := range s.segments {
+			if !s.filter.overlaps(seg) {
+				continue
+			}
+			if err = seg.each(matching); err != nil {
+				break
+			}
+		}
+	}
+	if err == Stop {
+		return nil
+	}
+	return err
+}
+
+// eachIndexed calls fn on the snapshot's entries in the segments in its
+// time range, reading only those their indexes list
+func (s *Snapshot) eachIndexed(fn func(commands.Command) error) error {
+	last := len(s.segments) - 1
+	for segmentNum, seg := range s.segments {
+		if !s.filter.overlaps(seg) {
+			continue
+		}
+		positions := s.active
+		if segmentNum < last {
+			i, err := s.indexes.get(seg, segmentNum)
+			if err != nil {
+				return err
+			}
+			positions, _ = i.candidates(s.filter)
+		}
+		if err := seg.eachAt(positions, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
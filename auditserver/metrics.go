@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"seng468/auditserver/metrics"
+	"sync/atomic"
+)
+
+var (
+	requestCount = metrics.NewCounter("audit_requests_total",
+		"Requests handled, by endpoint and status code.", "endpoint", "code")
+	requestLatency = metrics.NewHistogram("audit_request_duration_seconds",
+		"Time taken to handle each request.", metrics.DefaultBuckets, "endpoint")
+)
+
+func init() {
+	metrics.NewGaugeFunc("audit_events_queued",
+		"Events received but not yet written to the log.", func() float64 { return float64(len(logChannel)) })
+	metrics.NewGaugeFunc("audit_events_invalid",
+		"Events rejected by validation.", func() float64 { return float64(atomic.LoadUint64(&invalidEvents)) })
+	metrics.NewGaugeFunc("audit_events_stored",
+		"Events in the log.", func() float64 { return float64(eventlog.Len()) })
+	metrics.NewGaugeFunc("audit_spans_stored",
+		"Spans in the span log.", func() float64 { return float64(spanlog.Len()) })
+}
+
+// handle serves fn at endpoint, counting and timing its requests
+func handle(endpoint string, fn func(w http.ResponseWriter, r *http.Request)) {
+	http.HandleFunc(endpoint, metrics.Instrument(endpoint, requestCount, requestLatency, fn))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"seng468/auditserver/commands"
+	"seng468/auditserver/log"
+	"strconv"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// queryPage is one page of events returned by /query
+type queryPage struct {
+	Events []map[string]string `json:"events"`
+	Offset int                 `json:"offset"`
+	Limit  int                 `json:"limit"`
+	// Next is the offset of the following page, if there is one
+	Next *int `json:"next,omitempty"`
+}
+
+// queryHandler returns the events matching the DUMPLOG filters as a page of JSON,
+// starting offset events in and holding at most limit events. With
+// order=newest the offset counts back from the most recent event.
+// Each event is an object of its fields, the same as a /batch JSON line.
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := parseFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, limit, err := parsePage(query.Get("offset"), query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := queryPage{
+		Events: []map[string]string{},
+		Offset: offset,
+		Limit:  limit,
+	}
+	if query.Get("order") == "newest" {
+		err = newestPage(&page, filter)
+	} else {
+		err = oldestPage(&page, filter)
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
+
+// oldestPage fills page with matches counting forward from the oldest
+func oldestPage(page *queryPage, filter log.Filter) error {
+	seen := 0
+	return eventlog.Snapshot(filter).Each(func(c commands.Command) error {
+		seen++
+		if seen <= page.Offset {
+			return nil
+		}
+		if len(page.Events) == page.Limit {
+			// One more match means there is another page
+			next := page.Offset + page.Limit
+			page.Next = &next
+			return log.Stop
+		}
+		page.Events = append(page.Events, commands.Fields(c))
+		return nil
+	})
+}
+
+// newestPage fills page with matches counting back from the most recent.
+// The log is read oldest first, so only the last offset+limit matches are
+// kept as it goes, along with one more to tell if there is another page.
+func newestPage(page *queryPage, filter log.Filter) error {
+	keep := page.Offset + page.Limit + 1
+	var recent []commands.Command
+	total := 0
+	err := eventlog.Snapshot(filter).Each(func(c commands.Command) error {
+		if len(recent) < keep {
+			recent = append(recent, c)
+		} else {
+			recent[total%keep] = c
+		}
+		total++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := page.Offset; i < page.Offset+page.Limit && i < total; i++ {
+		page.Events = append(page.Events, commands.Fields(recent[(total-1-i)%keep]))
+	}
+	if total > page.Offset+page.Limit {
+		next := page.Offset + page.Limit
+		page.Next = &next
+	}
+	return nil
+}
+
+func parsePage(offsetParam string, limitParam string) (offset int, limit int, err error) {
+	limit = defaultQueryLimit
+	if offsetParam != "" {
+		if offset, err = strconv.Atoi(offsetParam); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("bad offset %q", offsetParam)
+		}
+	}
+	if limitParam != "" {
+		if limit, err = strconv.Atoi(limitParam); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("bad limit %q", limitParam)
+		}
+		if limit > maxQueryLimit {
+			limit = maxQueryLimit
+		}
+	}
+	return offset, limit, nil
+}
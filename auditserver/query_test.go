@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"seng468/auditserver/commands"
+	"seng468/auditserver/log"
+	"strconv"
+	"testing"
+)
+
+func TestQueryHandler(t *testing.T) {
+	var err error
+	eventlog, err = log.Open(t.TempDir(), log.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventlog.Close()
+	for i := 1; i <= 5; i++ {
+		eventlog.Insert(&commands.UserCommand{Timestamp: int64(i), Server: "webserver", TransactionNum: strconv.Itoa(i), Command: "ADD", Username: "bob"})
+		eventlog.Insert(&commands.AccountTransaction{Timestamp: int64(i), Server: "transactionserve", TransactionNum: strconv.Itoa(i), Action: "add", Username: "bob"})
+	}
+
+	get := func(url string) queryPage {
+		rec := httptest.NewRecorder()
+		queryHandler(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != 200 {
+			t.Fatalf("%s: status %d", url, rec.Code)
+		}
+		var page queryPage
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+
+	page := get("/query?username=bob&server=webserver&limit=2")
+	if len(page.Events) != 2 || page.Next == nil || *page.Next != 2 {
+		t.Fatalf("first page = %+v", page)
+	}
+	if e := page.Events[0]; e["type"] != "userCommand" || e["transactionNum"] != "1" {
+		t.Errorf("first event = %v", e)
+	}
+
+	page = get("/query?username=bob&server=webserver&limit=2&offset=4")
+	if len(page.Events) != 1 || page.Next != nil {
+		t.Errorf("last page = %+v", page)
+	}
+
+	page = get("/query?username=bob&server=webserver&limit=2&order=newest")
+	if len(page.Events) != 2 || page.Next == nil || *page.Next != 2 {
+		t.Fatalf("newest page = %+v", page)
+	}
+	if page.Events[0]["transactionNum"] != "5" || page.Events[1]["transactionNum"] != "4" {
+		t.Errorf("newest events = %v", page.Events)
+	}
+	page = get("/query?username=bob&server=webserver&limit=2&offset=4&order=newest")
+	if len(page.Events) != 1 || page.Events[0]["transactionNum"] != "1" || page.Next != nil {
+		t.Errorf("oldest page counting back = %+v", page)
+	}
+
+	page = get("/query?transactionNum=3&type=accountTransaction")
+	if len(page.Events) != 1 || page.Events[0]["action"] != "add" {
+		t.Errorf("transaction 3 = %+v", page)
+	}
+
+	rec := httptest.NewRecorder()
+	queryHandler(rec, httptest.NewRequest("GET", "/query?limit=none", nil))
+	if rec.Code != 400 {
+		t.Errorf("bad limit gave status %d", rec.Code)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"seng468/auditserver/commands"
+	"seng468/auditserver/log"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// storeSpan validates s and appends it to the span log
+func storeSpan(s *commands.Span) error {
+	if err := commands.ValidateSpan(s); err != nil {
+		n := atomic.AddUint64(&invalidEvents, 1)
+		fmt.Printf("Rejected invalid event (%d so far): %v\n", n, err)
+		return err
+	}
+	return spanlog.Insert(s)
+}
+
+func spanHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+	duration, _ := strconv.ParseInt(query.Get("duration"), 10, 64)
+	s := &commands.Span{
+		Server:         query.Get("server"),
+		TransactionNum: query.Get("transactionNum"),
+		Name:           query.Get("name"),
+		Start:          start,
+		Duration:       duration,
+	}
+	if err := storeSpan(s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// timelineSpan is a span placed on the timeline of its transaction
+type timelineSpan struct {
+	Server   string `json:"server"`
+	Name     string `json:"name"`
+	Start    int64  `json:"start"`    // µs since the epoch
+	Offset   int64  `json:"offset"`   // µs since the first span started
+	Duration int64  `json:"duration"` // µs
+}
+
+// timeline is the full history of one transaction
+type timeline struct {
+	TransactionNum string              `json:"transactionNum"`
+	Duration       int64               `json:"duration"` // µs from the first span starting to the last ending
+	Spans          []timelineSpan      `json:"spans"`
+	Events         []map[string]string `json:"events"`
+}
+
+// traceHandler rebuilds the timeline of the transaction given by the
+// transactionNum param from its spans, in order of when they started,
+// along with the audit events logged for it.
+// Spans are timed by each server's own clock, so skew between hosts shows.
+func traceHandler(w http.ResponseWriter, r *http.Request) {
+	transNum := r.URL.Query().Get("transactionNum")
+	if transNum == "" {
+		http.Error(w, "missing transactionNum", http.StatusBadRequest)
+		return
+	}
+	t, err := buildTimeline(transNum)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(t.Spans) == 0 && len(t.Events) == 0 {
+		http.Error(w, "no such transaction", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
+
+func buildTimeline(transNum string) (timeline, error) {
+	t := timeline{
+		TransactionNum: transNum,
+		Spans:          []timelineSpan{},
+		Events:         []map[string]string{},
+	}
+	filter := log.Filter{TransactionNum: transNum}
+
+	err := spanlog.Snapshot(filter).Each(func(c commands.Command) error {
+		if s, ok := c.(*commands.Span); ok {
+			t.Spans = append(t.Spans, timelineSpan{
+				Server:   s.Server,
+				Name:     s.Name,
+				Start:    s.Start,
+				Duration: s.Duration,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return t, err
+	}
+	err = eventlog.Snapshot(filter).Each(func(c commands.Command) error {
+		t.Events = append(t.Events, commands.Fields(c))
+		return nil
+	})
+	if err != nil {
+		return t, err
+	}
+
+	sort.SliceStable(t.Spans, func(i, j int) bool { return t.Spans[i].Start < t.Spans[j].Start })
+	if len(t.Spans) > 0 {
+		first := t.Spans[0].Start
+		var last int64
+		for i := range t.Spans {
+			t.Spans[i].Offset = t.Spans[i].Start - first
+			if end := t.Spans[i].Offset + t.Spans[i].Duration; end > last {
+				last = end
+			}
+		}
+		t.Duration = last
+	}
+	return t, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"seng468/auditserver/commands"
+	"seng468/auditserver/log"
+	"testing"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	var err error
+	if eventlog, err = log.Open(t.TempDir(), log.DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	defer eventlog.Close()
+	if spanlog, err = log.Open(t.TempDir(), log.DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	defer spanlog.Close()
+
+	spans := []*commands.Span{
+		{Server: "transactionserve", TransactionNum: "7", Name: "handle QUOTE", Start: 1000200, Duration: 500},
+		{Server: "webserver", TransactionNum: "7", Name: "handle QUOTE", Start: 1000000, Duration: 900},
+		{Server: "webserver", TransactionNum: "8", Name: "handle ADD", Start: 1000100, Duration: 10},
+		{Server: "quoteserver", TransactionNum: "7", Name: "call legacy quote server", Start: 1000300, Duration: 300},
+	}
+	for _, s := range spans {
+		if err := storeSpan(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storeSpan(&commands.Span{Server: "webserver", TransactionNum: "0", Name: "x", Start: 1}); err == nil {
+		t.Error("stored a span without a transaction")
+	}
+	eventlog.Insert(&commands.UserCommand{Timestamp: 1000, Server: "webserver", TransactionNum: "7", Command: "QUOTE", Username: "bob"})
+
+	tl, err := buildTimeline("7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tl.Spans) != 3 || len(tl.Events) != 1 {
+		t.Fatalf("got %d spans and %d events, want 3 and 1", len(tl.Spans), len(tl.Events))
+	}
+	wantOffsets := []int64{0, 200, 300}
+	for i, s := range tl.Spans {
+		if s.Offset != wantOffsets[i] {
+			t.Errorf("span %d (%s) offset = %d, want %d", i, s.Name, s.Offset, wantOffsets[i])
+		}
+	}
+	if tl.Duration != 900 {
+		t.Errorf("duration = %d, want 900", tl.Duration)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"seng468/auditserver/commands"
+	"strings"
+)
+
+// validateDump checks the dumped log at path against the schema, printing
+// each invalid event, and returns the exit code for the -validate mode
+func validateDump(path string, anytime bool) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 2
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return 2
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	v := commands.SchemaValidator
+	if anytime {
+		v = commands.Validator{}
+	}
+	count, invalid, err := v.ValidateDump(r)
+	for _, e := range invalid {
+		fmt.Println(e.Error())
+	}
+	if err != nil {
+		fmt.Printf("error: %s is not a valid log: %v\n", path, err)
+		return 2
+	}
+	fmt.Printf("%d of %d events in %s are valid\n", count-len(invalid), count, path)
+	if len(invalid) > 0 {
+		return 1
+	}
+	return 0
+}
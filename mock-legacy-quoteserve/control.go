@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// controlHandler serves the HTTP API tests use to steer the mock mid-run:
+//	POST /price?stock=ABC&price=12.34   pin ABC at 12.34
+//	DELETE /price?stock=ABC             return ABC to its scripted/random price
+//	POST /mode?latency=50ms&errorrate=0.1&malformedrate=0
+//	POST /reset                         restart the scenario clock and seed
+//	GET /state                          current pinned prices and modes
+func controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/price", priceHandler)
+	mux.HandleFunc("/mode", modeHandler)
+	mux.HandleFunc("/reset", resetHandler)
+	mux.HandleFunc("/state", stateHandler)
+	return mux
+}
+
+func priceHandler(w http.ResponseWriter, r *http.Request) {
+	stock := r.FormValue("stock")
+	if stock == "" {
+		http.Error(w, "stock is required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		price, err := decimal.NewFromString(r.FormValue("price"))
+		if err != nil {
+			http.Error(w, "bad price: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		quotes.Pin(stock, price)
+	case http.MethodDelete:
+		quotes.Unpin(stock)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func modeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	modes.lock.Lock()
+	defer modes.lock.Unlock()
+
+	// Parse everything before applying anything, so a bad request changes nothing
+	latency, errorRate, malformedRate := modes.latency, modes.errorRate, modes.malformedRate
+	var err error
+	if v := r.FormValue("latency"); v != "" {
+		if latency, err = time.ParseDuration(v); err != nil {
+			http.Error(w, "bad latency: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("errorrate"); v != "" {
+		if errorRate, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, "bad errorrate: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("malformedrate"); v != "" {
+		if malformedRate, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, "bad malformedrate: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	modes.latency, modes.errorRate, modes.malformedRate = latency, errorRate, malformedRate
+}
+
+func resetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	quotes.Reset()
+	modes.lock.Lock()
+	modes.rand = rand.New(rand.NewSource(quotes.seed))
+	modes.lock.Unlock()
+}
+
+func stateHandler(w http.ResponseWriter, r *http.Request) {
+	quotes.lock.Lock()
+	pinned := make(map[string]string)
+	for stock, price := range quotes.pinned {
+		pinned[stock] = price.StringFixed(2)
+	}
+	elapsed := time.Since(quotes.started)
+	quotes.lock.Unlock()
+
+	modes.lock.Lock()
+	state := map[string]interface{}{
+		"seed":          quotes.seed,
+		"elapsed":       elapsed.String(),
+		"pinned":        pinned,
+		"latency":       modes.latency.String(),
+		"errorrate":     modes.errorRate,
+		"malformedrate": modes.malformedRate,
+	}
+	modes.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -1,30 +1,109 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	connHost = "172.19.0.1" // Run on the local machine
-	connPort = "4444"       // Same port as on the regular system
-	connType = "tcp"        // NOTE: not HTPP
+	defaultHost = "172.19.0.1" // Run on the local machine
+	defaultPort = "4444"       // Same port as on the regular system
+	connType    = "tcp"        // NOTE: not HTPP
 )
 
+// faults are the injected failure modes, changeable at runtime via /mode
+type faults struct {
+	lock          sync.Mutex
+	rand          *rand.Rand
+	latency       time.Duration // each reply is delayed by up to latency
+	errorRate     float64       // chance of closing the connection without replying
+	malformedRate float64       // chance of replying with garbage
+}
+
+// next decides the delay and failure mode for one request
+func (f *faults) next() (delay time.Duration, fail bool, malformed bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.latency > 0 {
+		delay = time.Duration(f.rand.Int63n(int64(f.latency)))
+	}
+	fail = f.rand.Float64() < f.errorRate
+	malformed = !fail && f.rand.Float64() < f.malformedRate
+	return delay, fail, malformed
+}
+
+var quotes *prices
+var modes *faults
+
 func main() {
+	addr := flag.String("addr", envOr("mockquoteaddr", defaultHost+":"+defaultPort), "address to serve legacy quotes on")
+	controlAddr := flag.String("control", envOr("mockcontroladdr", ":4445"), "address to serve the HTTP control API on, empty to disable")
+	scenarioFile := flag.String("scenario", "", "scenario file of scripted price paths")
+	seed := flag.Int64("seed", 0, "random seed, defaults to the scenario's seed or the current time")
+	latency := flag.Duration("latency", time.Millisecond*30, "maximum random delay before each reply")
+	errorRate := flag.Float64("errorrate", 0, "fraction of requests closed without a reply")
+	malformedRate := flag.Float64("malformedrate", 0, "fraction of requests answered with a malformed reply")
+	flag.Parse()
+
+	var scenario *Scenario
+	if *scenarioFile != "" {
+		var err error
+		scenario, err = LoadScenario(*scenarioFile)
+		if err != nil {
+			fmt.Println("Error loading scenario:", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	seedSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seedSet = true
+		}
+	})
+	if !seedSet {
+		if scenario != nil && scenario.Seed != nil {
+			*seed = *scenario.Seed
+		} else {
+			*seed = time.Now().UnixNano()
+		}
+	}
+	fmt.Println("Using seed", *seed)
+
+	quotes = newPrices(*seed, scenario)
+	modes = &faults{
+		rand:          rand.New(rand.NewSource(*seed)),
+		latency:       *latency,
+		errorRate:     *errorRate,
+		malformedRate: *malformedRate,
+	}
+
+	if *controlAddr != "" {
+		go func() {
+			fmt.Println("Control API listening on " + *controlAddr)
+			if err := http.ListenAndServe(*controlAddr, controlHandler()); err != nil {
+				fmt.Println("Error serving control API:", err.Error())
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Listen for incoming connections.
-	l, err := net.Listen(connType, connHost+":"+connPort)
+	l, err := net.Listen(connType, *addr)
 	if err != nil {
 		fmt.Println("Error listening:", err.Error())
 		os.Exit(1)
 	}
 	// Close the listener when the application closes.
 	defer l.Close()
-	fmt.Println("Listening on " + connHost + ":" + connPort)
+	fmt.Println("Listening on " + *addr)
 	for {
 		// Listen for an incoming connection.
 		conn, err := l.Accept()
@@ -37,44 +116,69 @@ func main() {
 	}
 }
 
+func envOr(key string, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
+}
+
 // Handles incoming requests.
 func handleRequest(conn net.Conn) {
+	// Close the connection when you're done with it.
+	defer conn.Close()
+
 	// Make a buffer to hold incoming data.
 	buf := make([]byte, 1024)
 	// Read the incoming connection into the buffer.
 	recv, err := conn.Read(buf)
 	if err != nil {
 		fmt.Println("Error reading:", err.Error())
+		return
 	}
 	split := strings.Split(string(buf[:recv]), ",")
-	time.Sleep(time.Duration(rand.Intn(30)) * time.Millisecond)
+	if len(split) < 2 {
+		fmt.Println("Bad request:", string(buf[:recv]))
+		return
+	}
+
+	delay, fail, malformed := modes.next()
+	time.Sleep(delay)
+	if fail {
+		fmt.Println("Injected error, closing without reply")
+		return
+	}
+
+	stock := strings.TrimSpace(split[0])
+	username := strings.TrimSpace(split[1])
+	response, err := makeResponse(stock, username, malformed)
+	if err != nil {
+		fmt.Println("No quote for", stock, err.Error())
+		return
+	}
 	// Send a response back to person contacting us.
-	conn.Write([]byte(makeResponse(strings.TrimSpace(split[0]), strings.TrimSpace(split[1]))))
-	// Close the connection when you're done with it.
-	conn.Close()
+	conn.Write([]byte(response))
 }
 
-func makeResponse(stock string, username string) string {
+func makeResponse(stock string, username string, malformed bool) (string, error) {
+	price, crypto, err := quotes.Quote(stock)
+	if err != nil {
+		return "", err
+	}
+	if malformed {
+		output := fmt.Sprintf("%s,%s\n", price.StringFixed(2), crypto)
+		fmt.Print("Injected malformed reply: ", output)
+		return output, nil
+	}
+
 	now := time.Now().UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
-	amount := fmt.Sprintf("%d.%d", rand.Intn(500)+1, rand.Intn(100))
-	crypto := randSeq(25)
 	// (?P<quote>.+),(?P<stock>.+),(?P<user>.+),(?P<time>.+),(?P<key>.+)
 	output := fmt.Sprintf("%s,%s,%s,%d,%s\n",
-		amount,
+		price.StringFixed(2),
 		stock,
 		username,
 		now,
 		crypto)
-	fmt.Println(output)
-	return output
-}
-
-var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-
-func randSeq(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
+	fmt.Print(output)
+	return output, nil
 }
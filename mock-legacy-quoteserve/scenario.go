@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// point is one step in a stock's scripted price path.
+// A gap point makes the stock unquotable until the next point.
+// A ramp point is approached linearly from the point before it.
+type point struct {
+	At    duration `json:"at"`
+	Price string   `json:"price"`
+	Ramp  bool     `json:"ramp"`
+	Gap   bool     `json:"gap"`
+	price decimal.Decimal
+}
+
+// Scenario is the file format passed with -scenario, e.g.
+//	{"seed": 42, "stocks": {"ABC": [
+//		{"at": "0s", "price": "10.00"},
+//		{"at": "30s", "price": "20.00", "ramp": true},
+//		{"at": "40s", "price": "95.00"},
+//		{"at": "41s", "price": "20.00"},
+//		{"at": "60s", "gap": true},
+//		{"at": "70s", "price": "18.00"}]}}
+type Scenario struct {
+	Seed   *int64             `json:"seed"`
+	Stocks map[string][]point `json:"stocks"`
+}
+
+// duration lets scenario files use "30s" style times
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+var errGap = errors.New("stock is in a scripted gap")
+
+// LoadScenario reads and validates a scenario file
+func LoadScenario(filename string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	scenario := new(Scenario)
+	if err := json.Unmarshal(data, scenario); err != nil {
+		return nil, err
+	}
+
+	for stock, path := range scenario.Stocks {
+		sort.SliceStable(path, func(i, j int) bool { return path[i].At.Duration < path[j].At.Duration })
+		for i := range path {
+			if path[i].Gap {
+				continue
+			}
+			path[i].price, err = decimal.NewFromString(path[i].Price)
+			if err != nil {
+				return nil, fmt.Errorf("bad price %q for %s: %s", path[i].Price, stock, err.Error())
+			}
+		}
+	}
+	return scenario, nil
+}
+
+// PriceAt returns the scripted price of stock at elapsed time into the scenario.
+// found is false if the stock isn't scripted, or elapsed is before its first point.
+func (s *Scenario) PriceAt(stock string, elapsed time.Duration) (price decimal.Decimal, found bool, err error) {
+	if s == nil {
+		return price, false, nil
+	}
+	path := s.Stocks[stock]
+
+	current := -1
+	for i := range path {
+		if path[i].At.Duration <= elapsed {
+			current = i
+		}
+	}
+	if current == -1 {
+		return price, false, nil
+	}
+	if path[current].Gap {
+		return price, true, errGap
+	}
+
+	next := current + 1
+	if next < len(path) && path[next].Ramp && !path[next].Gap {
+		from, to := path[current], path[next]
+		progress := decimal.New(int64(elapsed-from.At.Duration), 0).
+			Div(decimal.New(int64(to.At.Duration-from.At.Duration), 0))
+		return from.price.Add(to.price.Sub(from.price).Mul(progress)).Round(2), true, nil
+	}
+	return path[current].price, true, nil
+}
+
+// prices hands out the mock's quotes: pinned prices from the control API
+// first, then the scenario, then seeded random prices like the original mock.
+type prices struct {
+	lock     sync.Mutex
+	seed     int64
+	scenario *Scenario
+	started  time.Time
+	pinned   map[string]decimal.Decimal
+	sources  map[string]*rand.Rand
+}
+
+func newPrices(seed int64, scenario *Scenario) *prices {
+	return &prices{
+		seed:     seed,
+		scenario: scenario,
+		started:  time.Now(),
+		pinned:   make(map[string]decimal.Decimal),
+		sources:  make(map[string]*rand.Rand),
+	}
+}
+
+// source returns the random source for stock, so each stock's sequence of
+// random prices only depends on the seed and how often that stock was quoted.
+// The caller must hold the lock.
+func (p *prices) source(stock string) *rand.Rand {
+	r, ok := p.sources[stock]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(stock))
+		r = rand.New(rand.NewSource(p.seed ^ int64(h.Sum64())))
+		p.sources[stock] = r
+	}
+	return r
+}
+
+// Quote returns the current price of stock and a cryptokey to go with it
+func (p *prices) Quote(stock string) (decimal.Decimal, string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	r := p.source(stock)
+	key := randSeq(r, 25)
+
+	if price, ok := p.pinned[stock]; ok {
+		return price, key, nil
+	}
+	price, found, err := p.scenario.PriceAt(stock, time.Since(p.started))
+	if found {
+		return price, key, err
+	}
+	return decimal.New(int64(r.Intn(50000)+100), -2), key, nil
+}
+
+// Pin fixes the price of stock until it is unpinned
+func (p *prices) Pin(stock string, price decimal.Decimal) {
+	p.lock.Lock()
+	p.pinned[stock] = price
+	p.lock.Unlock()
+}
+
+// Unpin returns stock to its scripted or random price
+func (p *prices) Unpin(stock string) {
+	p.lock.Lock()
+	delete(p.pinned, stock)
+	p.lock.Unlock()
+}
+
+// Reset restarts the scenario clock and random sequences, and clears pinned prices
+func (p *prices) Reset() {
+	p.lock.Lock()
+	p.started = time.Now()
+	p.pinned = make(map[string]decimal.Decimal)
+	p.sources = make(map[string]*rand.Rand)
+	p.lock.Unlock()
+}
+
+var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+func randSeq(r *rand.Rand, n int) string {
+	b := make([]rune, n)
+	for i := range b {
+		b[i] = letters[r.Intn(len(letters))]
+	}
+	return string(b)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const testScenario = `{"seed": 7, "stocks": {"ABC": [
+	{"at": "10s", "price": "10.00"},
+	{"at": "20s", "price": "20.00", "ramp": true},
+	{"at": "30s", "price": "95.00"},
+	{"at": "31s", "gap": true},
+	{"at": "40s", "price": "18.00"}]}}`
+
+func loadTestScenario(t *testing.T) *Scenario {
+	f, err := ioutil.TempFile("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testScenario)
+	f.Close()
+
+	scenario, err := LoadScenario(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scenario
+}
+
+func TestScenarioPriceAt(t *testing.T) {
+	scenario := loadTestScenario(t)
+	if *scenario.Seed != 7 {
+		t.Error("Seed not loaded, got", *scenario.Seed)
+	}
+
+	cases := []struct {
+		at    time.Duration
+		price string
+		found bool
+		gap   bool
+	}{
+		{time.Second * 5, "", false, false},
+		{time.Second * 10, "10.00", true, false},
+		{time.Second * 15, "15.00", true, false},
+		{time.Second * 20, "20.00", true, false},
+		{time.Second * 30, "95.00", true, false},
+		{time.Second * 35, "", true, true},
+		{time.Second * 45, "18.00", true, false},
+	}
+	for _, c := range cases {
+		price, found, err := scenario.PriceAt("ABC", c.at)
+		if found != c.found || (err == errGap) != c.gap {
+			t.Errorf("At %v: found=%v err=%v", c.at, found, err)
+			continue
+		}
+		if c.price != "" && price.StringFixed(2) != c.price {
+			t.Errorf("At %v: expected %s, got %s", c.at, c.price, price.StringFixed(2))
+		}
+	}
+
+	if _, found, _ := scenario.PriceAt("XYZ", time.Second*15); found {
+		t.Error("Unscripted stock should not be found")
+	}
+}
+
+func TestPricesAreSeeded(t *testing.T) {
+	a := newPrices(42, nil)
+	b := newPrices(42, nil)
+	for i := 0; i < 5; i++ {
+		priceA, keyA, _ := a.Quote("ABC")
+		priceB, keyB, _ := b.Quote("ABC")
+		if !priceA.Equal(priceB) || keyA != keyB {
+			t.Error("Same seed produced different quotes")
+		}
+	}
+
+	a.Pin("ABC", priceOf("12.34"))
+	if price, _, _ := a.Quote("ABC"); price.StringFixed(2) != "12.34" {
+		t.Error("Pinned price not used, got", price)
+	}
+}
+
+func priceOf(s string) decimal.Decimal {
+	d, _ := decimal.NewFromString(s)
+	return d
+}
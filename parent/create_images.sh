@@ -14,10 +14,15 @@ docker image build \
 --build-arg dbport=${dbport} \
 --build-arg auditaddr=${auditaddr} \
 --build-arg auditport=${auditport} \
+--build-arg auditbatchsize=${auditbatchsize} \
+--build-arg auditbatchinterval=${auditbatchinterval} \
+--build-arg auditqueuesize=${auditqueuesize} \
+--build-arg auditqueuepolicy=${auditqueuepolicy} \
 --build-arg quoteaddr=${quoteaddr} \
 --build-arg quoteport=${quoteport} \
 --build-arg triggeraddr=${triggeraddr} \
 --build-arg triggerport=${triggerport} \
+--build-arg metricsport=${metricsport} \
 -t teamrandint/transactionserver .
 
 cd ../WebServer
@@ -26,8 +31,15 @@ docker image build \
 --build-arg webport=${webport} \
 --build-arg auditaddr=${auditaddr} \
 --build-arg auditport=${auditport} \
+--build-arg auditbatchsize=${auditbatchsize} \
+--build-arg auditbatchinterval=${auditbatchinterval} \
+--build-arg auditqueuesize=${auditqueuesize} \
+--build-arg auditqueuepolicy=${auditqueuepolicy} \
 --build-arg transaddr=${transaddr} \
 --build-arg transport=${transport} \
+--build-arg transnumaddr=${transnumaddr} \
+--build-arg transnumport=${transnumport} \
+--build-arg transnumblock=${transnumblock} \
 -t teamrandint/webserver . 
 
 cd ../database
@@ -42,6 +54,13 @@ docker image build \
 --build-arg quoteport=${quoteport} \
 --build-arg auditaddr=${auditaddr} \
 --build-arg auditport=${auditport} \
+--build-arg auditbatchsize=${auditbatchsize} \
+--build-arg auditbatchinterval=${auditbatchinterval} \
+--build-arg auditqueuesize=${auditqueuesize} \
+--build-arg auditqueuepolicy=${auditqueuepolicy} \
+--build-arg quotecacheaddr=${quotecacheaddr} \
+--build-arg quotecacheport=${quotecacheport} \
+--build-arg quotecachettl=${quotecachettl} \
 --build-arg legacyquoteaddr=${legacyquoteaddr} \
 --build-arg legacyquoteport=${legacyquoteport} \
 -t teamrandint/quoteserver .
@@ -56,6 +75,8 @@ docker image build \
 --build-arg auditport=${auditport} \
 --build-arg transaddr=${transaddr} \
 --build-arg transport=${transport} \
+--build-arg quotecacheaddr=${quotecacheaddr} \
+--build-arg quotecacheport=${quotecacheport} \
 -t teamrandint/triggerserver .
 
 docker pull dockercloud/haproxy
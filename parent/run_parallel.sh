@@ -26,7 +26,8 @@ docker network create -d overlay --attachable --subnet=172.20.0.0/16 --gateway=1
 if [ $MOCK = true ] ;
     then
         go build -o ../mock-legacy-quoteserve/mockQuoteServe
-        ../mock-legacy-quoteserve/mockQuoteServe &
+        source ./.env
+        ../mock-legacy-quoteserve/mockQuoteServe -addr ${legacyquoteaddr}:${legacyquoteport} &
 fi
 
 docker service  rm stack_trigger stack_quote stack_transaction stack_database stack_audit stack_proxy_web
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"seng468/quoteserver/logger"
+	"time"
+)
+
+// Config holds the settings of the quote server
+type Config struct {
+	QuoteAddr       string        `key:"quoteaddr" help:"host this server is reached at"`
+	QuotePort       string        `key:"quoteport" required:"true" help:"port to serve on"`
+	DrainTimeout    time.Duration `key:"shutdowntimeout" default:"20" unit:"s" help:"time to let requests finish at shutdown"`
+	LegacyAddr      string        `key:"legacyquoteaddr" required:"true" help:"legacy quote server host"`
+	LegacyPort      string        `key:"legacyquoteport" required:"true" help:"legacy quote server port"`
+	LegacyTimeout   time.Duration `key:"legacyquotetimeout" default:"1" unit:"s" help:"time to wait connecting to the legacy quote server"`
+	LocalCacheTTL   time.Duration `key:"localcachettl" default:"60" unit:"s" help:"how long quotes are cached when there is no shared cache"`
+	SharedCacheAddr string        `key:"quotecacheaddr" help:"redis host of the quote cache shared between replicas, if any"`
+	SharedCachePort string        `key:"quotecacheport" help:"redis port of the shared quote cache"`
+	SharedCacheTTL  time.Duration `key:"quotecachettl" default:"60" unit:"s" help:"how long quotes are kept in the shared cache"`
+	RedisPassword   string        `key:"redispassword" secret:"true" help:"redis password, if one is set"`
+	AuditAddr       string        `key:"auditaddr" required:"true"`
+	AuditPort       string        `key:"auditport" required:"true"`
+	AuditBatchSize  int           `key:"auditbatchsize" default:"100" help:"audit events sent at once, 1 sends them one by one"`
+	AuditInterval   time.Duration `key:"auditbatchinterval" default:"250" unit:"ms" help:"longest wait to fill a batch of audit events"`
+	AuditQueueSize  int           `key:"auditqueuesize" default:"10000" help:"audit events kept in memory before auditqueuepolicy applies"`
+	AuditPolicy     string        `key:"auditqueuepolicy" default:"spill" help:"spill or block once the audit queue is full"`
+	AuditSpillFile  string        `key:"auditspillfile" default:"audit-spill.jsonl" help:"where undeliverable audit events are kept"`
+}
+
+// Validate checks the settings that can't be wrong on their own
+func (c *Config) Validate() error {
+	if c.SharedCacheAddr != "" && c.SharedCachePort == "" {
+		return errors.New("quotecacheport is required with quotecacheaddr")
+	}
+	if c.AuditBatchSize < 1 || c.AuditQueueSize < 1 {
+		return errors.New("batch and queue sizes must be at least 1")
+	}
+	if c.LegacyTimeout <= 0 || c.LocalCacheTTL <= 0 || c.SharedCacheTTL <= 0 ||
+		c.AuditInterval <= 0 || c.DrainTimeout <= 0 {
+		return errors.New("durations must be positive")
+	}
+	_, err := logger.ParseFullPolicy(c.AuditPolicy)
+	return err
+}
+
+// LegacyAddress is the host:port of the legacy quote server
+func (c *Config) LegacyAddress() string {
+	return c.LegacyAddr + ":" + c.LegacyPort
+}
+
+// AuditURL is the base URL of the audit server
+func (c *Config) AuditURL() string {
+	return "http://" + c.AuditAddr + ":" + c.AuditPort
+}
+
+// QueueOptions tunes the audit event queue
+func (c *Config) QueueOptions() logger.QueueOptions {
+	policy, _ := logger.ParseFullPolicy(c.AuditPolicy)
+	return logger.QueueOptions{
+		BatchSize: c.AuditBatchSize,
+		Interval:  c.AuditInterval,
+		MaxQueue:  c.AuditQueueSize,
+		Policy:    policy,
+		SpillPath: c.AuditSpillFile,
+	}
+}
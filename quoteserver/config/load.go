@@ -0,0 +1,247 @@
+// Package config loads the settings of the service into a typed struct.
+//
+// Each setting is a struct field tagged with the key it is read under:
+//
+//	AuditPort     string        `key:"auditport" required:"true"`
+//	BatchInterval time.Duration `key:"auditbatchinterval" default:"250" unit:"ms"`
+//	Password      string        `key:"redispassword" secret:"true"`
+//
+// Settings start at their default and are then overridden, in order, by a
+// config file of key=value lines (the same format as parent/.env) named by
+// the -config flag or configfile env var, by env vars and finally by flags
+// named after the key, e.g. -auditport=44455. Empty values are ignored.
+// Durations take a unit like "250ms", or are read in the tag's unit when
+// given as a plain number.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validator is implemented by configs with rules beyond required fields
+type Validator interface {
+	Validate() error
+}
+
+// Load fills cfg, a pointer to a struct of tagged fields, registering a flag
+// for each field on fs and parsing args with it. Every bad value is reported
+// in the returned error rather than only the first.
+func Load(cfg interface{}, fs *flag.FlagSet, args []string) error {
+	fields, err := settings(cfg)
+	if err != nil {
+		return err
+	}
+
+	configFile := fs.String("config", "", "read settings from this file of key=value lines")
+	flags := make(map[string]*string)
+	for _, f := range fields {
+		flags[f.key] = fs.String(f.key, "", f.usage())
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("configfile")
+	}
+	var file map[string]string
+	if path != "" {
+		file, err = readFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var errs []string
+	for _, f := range fields {
+		value := f.def
+		if v := file[f.key]; v != "" {
+			value = v
+		}
+		if v := os.Getenv(f.key); v != "" {
+			value = v
+		}
+		if v := *flags[f.key]; v != "" {
+			value = v
+		}
+		if value == "" && f.required {
+			errs = append(errs, fmt.Sprintf("%s is required", f.key))
+			continue
+		}
+		if err := f.set(value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", f.key, err))
+		}
+	}
+	if len(errs) == 0 {
+		if v, ok := cfg.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("bad config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Print writes every setting of cfg as key=value lines, with secrets hidden
+func Print(w io.Writer, cfg interface{}) {
+	fields, err := settings(cfg)
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		return
+	}
+	for _, f := range fields {
+		value := f.String()
+		if f.secret && value != "" {
+			value = "********"
+		}
+		fmt.Fprintf(w, "%s=%s\n", f.key, value)
+	}
+}
+
+// setting is one tagged field of a config struct
+type setting struct {
+	key      string
+	def      string
+	help     string
+	unit     time.Duration
+	required bool
+	secret   bool
+	value    reflect.Value
+}
+
+var units = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+}
+
+func settings(cfg interface{}) ([]setting, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a struct, not %s", v.Kind())
+	}
+
+	var fields []setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("key")
+		if key == "" {
+			continue
+		}
+		s := setting{
+			key:      key,
+			def:      field.Tag.Get("default"),
+			help:     field.Tag.Get("help"),
+			unit:     time.Second,
+			required: field.Tag.Get("required") == "true",
+			secret:   field.Tag.Get("secret") == "true",
+			value:    v.Field(i),
+		}
+		if unit := field.Tag.Get("unit"); unit != "" {
+			if s.unit = units[unit]; s.unit == 0 {
+				return nil, fmt.Errorf("%s: unknown unit %q", key, unit)
+			}
+		}
+		fields = append(fields, s)
+	}
+	return fields, nil
+}
+
+func (s setting) usage() string {
+	usage := s.help
+	if s.def != "" {
+		usage += fmt.Sprintf(" (default %s)", s.def)
+	}
+	return strings.TrimSpace(usage)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses value into the field, leaving it untouched if value is empty
+func (s setting) set(value string) error {
+	if value == "" {
+		return nil
+	}
+	if !s.value.CanSet() {
+		return errors.New("field can't be set")
+	}
+	switch {
+	case s.value.Type() == durationType:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			s.value.SetInt(n * int64(s.unit))
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(value)
+	case s.value.Kind() == reflect.Int, s.value.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		s.value.SetInt(n)
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		s.value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", s.value.Type())
+	}
+	return nil
+}
+
+func (s setting) String() string {
+	if s.value.Type() == durationType {
+		return time.Duration(s.value.Int()).String()
+	}
+	return fmt.Sprint(s.value.Interface())
+}
+
+// readFile reads key=value lines, skipping blank lines and # comments
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key=value", path, line)
+		}
+		values[strings.TrimSpace(text[:i])] = strings.TrimSpace(text[i+1:])
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,79 @@
+package feed
+
+import (
+	"sync"
+)
+
+// Update is a single fresh price fetched from the legacy quote server
+type Update struct {
+	Stock string `json:"stock"`
+	Price string `json:"price"`
+	Time  uint64 `json:"time"`
+}
+
+// Feed fans price updates out to every subscriber
+type Feed struct {
+	lock        sync.Mutex
+	subscribers map[*Subscription]bool
+}
+
+// Subscription receives updates for a set of stocks on C.
+// An empty set of stocks receives every update.
+type Subscription struct {
+	C      chan Update
+	stocks map[string]bool
+	feed   *Feed
+}
+
+// NewFeed returns a feed with no subscribers
+func NewFeed() *Feed {
+	return &Feed{
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Subscribe registers for updates to the given stocks, or all stocks if none are given.
+// Callers must Close the subscription once they are done with it.
+func (f *Feed) Subscribe(stocks ...string) *Subscription {
+	sub := &Subscription{
+		C:      make(chan Update, 64),
+		stocks: make(map[string]bool),
+		feed:   f,
+	}
+	for _, stock := range stocks {
+		sub.stocks[stock] = true
+	}
+
+	f.lock.Lock()
+	f.subscribers[sub] = true
+	f.lock.Unlock()
+	return sub
+}
+
+// Publish sends u to every interested subscriber.
+// Subscribers that aren't keeping up miss the update rather than block the quote server.
+func (f *Feed) Publish(u Update) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for sub := range f.subscribers {
+		if len(sub.stocks) > 0 && !sub.stocks[u.Stock] {
+			continue
+		}
+		select {
+		case sub.C <- u:
+		default:
+		}
+	}
+}
+
+// Close stops the subscription and closes C
+func (s *Subscription) Close() {
+	s.feed.lock.Lock()
+	defer s.feed.lock.Unlock()
+
+	if s.feed.subscribers[s] {
+		delete(s.feed.subscribers, s)
+		close(s.C)
+	}
+}
@@ -0,0 +1,41 @@
+package feed
+
+import (
+	"testing"
+)
+
+func TestFeedFiltersByStock(t *testing.T) {
+	f := NewFeed()
+	all := f.Subscribe()
+	abc := f.Subscribe("ABC")
+	defer all.Close()
+	defer abc.Close()
+
+	f.Publish(Update{Stock: "XYZ", Price: "10.00"})
+	f.Publish(Update{Stock: "ABC", Price: "20.00"})
+
+	if u := <-all.C; u.Stock != "XYZ" {
+		t.Error("Expected XYZ update first, got", u.Stock)
+	}
+	if u := <-all.C; u.Stock != "ABC" {
+		t.Error("Expected ABC update second, got", u.Stock)
+	}
+	if u := <-abc.C; u.Stock != "ABC" || u.Price != "20.00" {
+		t.Error("Filtered subscription got wrong update", u)
+	}
+	if len(abc.C) != 0 {
+		t.Error("Filtered subscription received other stocks")
+	}
+}
+
+func TestFeedClose(t *testing.T) {
+	f := NewFeed()
+	sub := f.Subscribe()
+	sub.Close()
+	sub.Close()
+
+	f.Publish(Update{Stock: "ABC", Price: "1.00"})
+	if _, ok := <-sub.C; ok {
+		t.Error("Closed subscription should not receive updates")
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"seng468/quoteserver/health"
+)
+
+// readinessChecks checks the legacy quote server, the audit server and
+// the shared cache when one is used
+func readinessChecks() *health.Checker {
+	checks := new(health.Checker)
+	checks.Add("legacy quote server", health.Dial(cfg.LegacyAddress()))
+	checks.Add("audit server", health.Get(cfg.AuditURL()+"/healthz"))
+	if sharedCache != nil {
+		checks.Add("shared cache", sharedCache.Ping)
+	}
+	return checks
+}
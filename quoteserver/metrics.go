@@ -0,0 +1,41 @@
+package main
+
+import (
+	"seng468/quoteserver/metrics"
+	"sync/atomic"
+)
+
+var (
+	requestCount = metrics.NewCounter("quote_requests_total",
+		"Requests handled, by endpoint and status code.", "endpoint", "code")
+	requestLatency = metrics.NewHistogram("quote_request_duration_seconds",
+		"Time taken to handle each request.", metrics.DefaultBuckets, "endpoint")
+	cacheLookups = metrics.NewCounter("quote_cache_lookups_total",
+		"Quote cache lookups, by cache (local or shared) and result (hit or miss).", "cache", "result")
+	legacyLatency = metrics.NewHistogram("quote_legacy_duration_seconds",
+		"Time taken by the legacy quote server to reply.", metrics.DefaultBuckets)
+	legacyErrors = metrics.NewCounter("quote_legacy_errors_total",
+		"Failed legacy quote server requests, by reason.", "reason")
+)
+
+func init() {
+	metrics.NewGaugeFunc("quote_rejected_replies",
+		"Legacy replies that failed to parse.", func() float64 { return float64(atomic.LoadUint64(&rejectedReplies)) })
+}
+
+// registerAuditGauges reports on the audit queue once it is started
+func registerAuditGauges() {
+	metrics.NewGaugeFunc("quote_audit_events_queued",
+		"Audit events waiting to be sent.", func() float64 { return float64(auditServer.Queue.Len()) })
+	metrics.NewGaugeFunc("quote_audit_events_lost",
+		"Audit events rejected or that could not be spilled.", func() float64 { return float64(auditServer.Queue.Lost()) })
+}
+
+// cacheResult records a quote cache lookup
+func cacheResult(cache string, found bool) {
+	if found {
+		cacheLookups.Inc(cache, "hit")
+	} else {
+		cacheLookups.Inc(cache, "miss")
+	}
+}
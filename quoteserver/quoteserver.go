@@ -2,12 +2,17 @@ package main
 
 import (
 	"bufio"
-	"errors"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"seng468/quoteserver/config"
+	"seng468/quoteserver/feed"
 	"seng468/quoteserver/logger"
+	"seng468/quoteserver/metrics"
+	"seng468/quoteserver/sharedcache"
 	"strconv"
 	"strings"
 	"time"
@@ -17,83 +22,111 @@ import (
 	// _ "net/http/pprof"
 )
 
-type QuoteReply struct {
-	quote decimal.Decimal
-	stock string
-	user  string
-	time  uint64
-	key   string
-}
-
-func getReply(msg string) *QuoteReply {
-	params := strings.Split(msg, ",")
-	if len(params) > 5 {
-		return nil
-	}
-
-	quote, err := decimal.NewFromString(params[0])
-	if err != nil {
-		return nil
-	}
-	timestamp, err := strconv.ParseUint(params[3], 10, 64)
-	if err != nil {
-		return nil
-	}
-	return &QuoteReply{
-		quote: quote,
-		stock: params[1],
-		user:  params[2],
-		time:  timestamp,
-		key:   params[4],
-	}
-}
-
 func quote(user string, stock string, transNum int) (decimal.Decimal, error) {
-	quote, found := quoteCache.Get(stock)
-	if found {
-		d, _ := decimal.NewFromString(quote.(string))
-		return d, nil
+	if sharedCache != nil {
+		price, found, release, err := sharedQuote(stock)
+		if err != nil {
+			// Skip the lock rather than wait out its TTL on every quote
+			fmt.Println("Error reading shared quote cache, going to the legacy server", err)
+		}
+		if found {
+			d, _ := decimal.NewFromString(price)
+			return d, nil
+		}
+		if release != nil {
+			defer release()
+		}
+	} else {
+		quote, found := quoteCache.Get(stock)
+		cacheResult("local", found)
+		if found {
+			d, _ := decimal.NewFromString(quote.(string))
+			return d, nil
+		}
 	}
 
+	legacy := auditServer.StartSpan("quoteserver", transNum, "call legacy quote server")
+	start := time.Now()
 	var conn net.Conn
 	var err error
 	for {
-		conn, err = net.DialTimeout("tcp",
-			os.Getenv("legacyquoteaddr")+":"+os.Getenv("legacyquoteport"),
-			time.Second*1,
-		)
+		conn, err = net.DialTimeout("tcp", cfg.LegacyAddress(), cfg.LegacyTimeout)
 		if err != nil { // trans server down? retry
 			fmt.Println(err.Error())
 		} else {
 			break
 		}
 	}
+	defer conn.Close()
 
 	request := fmt.Sprintf("%s,%s\n", stock, user)
 
 	conn.Write([]byte(request))
 	message, err := bufio.NewReader(conn).ReadString('\n')
+	legacyLatency.Since(start)
+	legacy.End()
 	if err != nil {
+		legacyErrors.Inc("read")
 		fmt.Println(err)
 		return decimal.Decimal{}, err
 	}
-	defer conn.Close()
-	reply := getReply(message)
-	fmt.Println(reply)
-	if reply == nil {
-		return decimal.Decimal{}, errors.New("reply from quoteserve doesn't match regex")
+	reply, err := parseReply(message, stock, user)
+	if err != nil {
+		rejectReply(message, stock, user, transNum, err)
+		return decimal.Decimal{}, err
 	}
+	fmt.Println(reply)
 	auditServer.QuoteServer("quoteserver", transNum, reply.quote.String(), reply.stock,
 		reply.user, reply.time, reply.key)
-	quoteCache.Set(reply.stock, reply.quote.String(), cache.DefaultExpiration)
+	if sharedCache != nil {
+		err = sharedCache.Set(reply.stock, reply.quote.String())
+		if err != nil {
+			fmt.Println("Error setting shared quote cache", err)
+		}
+		// Every replica, including this one, republishes to its own subscribers
+		err = sharedCache.Publish(reply.stock, reply.quote.String(), reply.time)
+		if err != nil {
+			fmt.Println("Error publishing quote update", err)
+		}
+	} else {
+		quoteCache.Set(reply.stock, reply.quote.String(), cache.DefaultExpiration)
+		priceFeed.Publish(feed.Update{Stock: reply.stock, Price: reply.quote.String(), Time: reply.time})
+	}
 	return reply.quote, nil
 }
 
+// sharedQuote looks stock up in the shared cache, waiting for the replica
+// already fetching it if there is one. When the price isn't found, release
+// is set if this replica took the fetch lock and must release it once the
+// price is stored.
+func sharedQuote(stock string) (price string, found bool, release func(), err error) {
+	price, found, err = sharedCache.Get(stock)
+	if err != nil {
+		return "", false, nil, err
+	}
+	cacheResult("shared", found)
+	if found {
+		return price, true, nil, nil
+	}
+
+	token, locked, err := sharedCache.Lock(stock)
+	if err != nil {
+		return "", false, nil, err
+	}
+	if locked {
+		return "", false, func() { sharedCache.Unlock(stock, token) }, nil
+	}
+	// Another replica is already fetching this stock, wait for its result
+	price, found, err = sharedCache.Wait(stock)
+	return price, found, nil, err
+}
+
 func quoteHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	user := strings.TrimSpace(query.Get("user"))
 	stock := strings.TrimSpace(query.Get("stock"))
 	transNum, _ := strconv.Atoi(query.Get("transNum"))
+	defer auditServer.StartSpan("quoteserver", transNum, "handle QUOTE").End()
 	reply, err := quote(user, stock, transNum)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -103,15 +136,84 @@ func quoteHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, reply.StringFixed(2))
 }
 
-var quoteCache = cache.New(time.Minute, time.Minute)
-var auditServer = logger.AuditLogger{Addr: "http://" + os.Getenv("auditaddr") + ":" + os.Getenv("auditport")}
+// streamHandler sends every fresh quote to the client as Server-Sent Events.
+// Pass one or more stock params to only receive updates for those stocks.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := priceFeed.Subscribe(r.URL.Query()["stock"]...)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	for {
+		select {
+		case update := <-sub.C:
+			data, err := json.Marshal(update)
+			if err != nil {
+				fmt.Println("Error encoding quote update", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: quote\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-stopStreams:
+			return
+		}
+	}
+}
+
+var priceFeed = feed.NewFeed()
+
+// stopStreams is closed at shutdown to end every /stream, which would
+// otherwise hold the server open until the drain deadline
+var stopStreams = make(chan struct{})
+var quoteCache *cache.Cache
+
+// sharedCache is used in place of quoteCache when quotecacheaddr is set
+var sharedCache *sharedcache.RedisCache
+var auditServer logger.AuditLogger
+var cfg config.Config
 
 func main() {
-	http.HandleFunc("/quote", quoteHandler)
-	addr := os.Getenv("quoteaddr")
-	port := os.Getenv("quoteport")
-	fmt.Printf("Quote server listening on %s:%s\n", addr, port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if err := config.Load(&cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	config.Print(os.Stdout, &cfg)
+
+	auditServer = logger.AuditLogger{
+		Addr:  cfg.AuditURL(),
+		Queue: logger.NewQueue(cfg.AuditURL(), cfg.QueueOptions()),
+	}
+	registerAuditGauges()
+	quoteCache = cache.New(cfg.LocalCacheTTL, cfg.LocalCacheTTL)
+	if cfg.SharedCacheAddr != "" {
+		sharedCache = sharedcache.NewRedisCache(cfg.SharedCacheAddr, cfg.SharedCachePort, cfg.RedisPassword, cfg.SharedCacheTTL)
+		fmt.Printf("Using shared quote cache at %s:%s\n", cfg.SharedCacheAddr, cfg.SharedCachePort)
+		go sharedCache.Subscribe(func(stock string, price string, qsTime uint64) {
+			priceFeed.Publish(feed.Update{Stock: stock, Price: price, Time: qsTime})
+		})
+	}
+
+	http.HandleFunc("/quote", metrics.Instrument("quote", requestCount, requestLatency, quoteHandler))
+	http.HandleFunc("/stream", streamHandler)
+	http.Handle("/metrics", metrics.Handler())
+	readinessChecks().Register(http.DefaultServeMux)
+	fmt.Printf("Quote server listening on %s:%s\n", cfg.QuoteAddr, cfg.QuotePort)
+	server := &http.Server{Addr: ":" + cfg.QuotePort}
+	server.RegisterOnShutdown(func() { close(stopStreams) })
+	if err := serveUntilSignal(server, cfg.DrainTimeout); err != nil {
 		panic(err)
 	}
+	auditServer.Queue.Flush()
+	fmt.Println("Quote server stopped")
 }
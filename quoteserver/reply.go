@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/shopspring/decimal"
+)
+
+type QuoteReply struct {
+	quote decimal.Decimal
+	stock string
+	user  string
+	time  uint64
+	key   string
+}
+
+// rejectedReplies counts every legacy reply that failed parseReply
+var rejectedReplies uint64
+
+var (
+	errFieldCount    = errors.New("reply does not have 5 fields")
+	errBadPrice      = errors.New("reply price is not a positive decimal")
+	errBadTimestamp  = errors.New("reply timestamp is not an integer")
+	errEmptyKey      = errors.New("reply is missing its cryptokey")
+	errStockMismatch = errors.New("reply stock does not match request")
+	errUserMismatch  = errors.New("reply user does not match request")
+)
+
+// parseReply strictly parses a legacy quote server reply of the format
+//		"quote,stock,user,time,key\n"
+// and checks that it answers the request for stock made by user.
+func parseReply(msg string, stock string, user string) (*QuoteReply, error) {
+	params := strings.Split(strings.TrimRight(msg, "\r\n"), ",")
+	if len(params) != 5 {
+		return nil, errFieldCount
+	}
+	for i := range params {
+		params[i] = strings.TrimSpace(params[i])
+	}
+
+	quote, err := decimal.NewFromString(params[0])
+	if err != nil || !quote.GreaterThan(decimal.Zero) {
+		return nil, errBadPrice
+	}
+	if params[1] != stock {
+		return nil, errStockMismatch
+	}
+	if params[2] != user {
+		return nil, errUserMismatch
+	}
+	timestamp, err := strconv.ParseUint(params[3], 10, 64)
+	if err != nil {
+		return nil, errBadTimestamp
+	}
+	if params[4] == "" {
+		return nil, errEmptyKey
+	}
+
+	return &QuoteReply{
+		quote: quote,
+		stock: params[1],
+		user:  params[2],
+		time:  timestamp,
+		key:   params[4],
+	}, nil
+}
+
+// rejectReply records a malformed legacy reply with the audit server
+func rejectReply(msg string, stock string, user string, transNum int, err error) {
+	atomic.AddUint64(&rejectedReplies, 1)
+	legacyErrors.Inc(err.Error())
+	errorMsg := fmt.Sprintf("Rejected legacy quote reply %q: %s", strings.TrimSpace(msg), err.Error())
+	fmt.Println(errorMsg)
+	auditServer.SystemError("quoteserver", transNum, "QUOTE", user, stock, nil, nil, errorMsg)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReply(t *testing.T) {
+	cases := []struct {
+		msg string
+		err error
+	}{
+		{"12.34,ABC,user1,1521131234567,abcDEFghi\n", nil},
+		{"12.34,ABC,user1,1521131234567,abcDEFghi", nil},
+		{"12.34,ABC,user1,1521131234567\n", errFieldCount},
+		{"12.34\n", errFieldCount},
+		{"\n", errFieldCount},
+		{"12.34,ABC,user1,1521131234567,abc,extra\n", errFieldCount},
+		{"twelve,ABC,user1,1521131234567,abc\n", errBadPrice},
+		{"-1.00,ABC,user1,1521131234567,abc\n", errBadPrice},
+		{"12.34,XYZ,user1,1521131234567,abc\n", errStockMismatch},
+		{"12.34,ABC,user2,1521131234567,abc\n", errUserMismatch},
+		{"12.34,ABC,user1,now,abc\n", errBadTimestamp},
+		{"12.34,ABC,user1,1521131234567,\n", errEmptyKey},
+	}
+
+	for _, c := range cases {
+		reply, err := parseReply(c.msg, "ABC", "user1")
+		if err != c.err {
+			t.Errorf("%q: expected error %v, got %v", c.msg, c.err, err)
+			continue
+		}
+		if err == nil && (reply.quote.StringFixed(2) != "12.34" || reply.time != 1521131234567 || reply.key != "abcDEFghi") {
+			t.Errorf("%q: parsed wrong values %+v", c.msg, reply)
+		}
+	}
+}
+
+func FuzzParseReply(f *testing.F) {
+	f.Add("12.34,ABC,user1,1521131234567,abcDEFghi\n", "ABC", "user1")
+	f.Add("12.34,ABC,user1\n", "ABC", "user1")
+	f.Add(",,,,\n", "", "")
+	f.Add("1e9,ABC,user1,18446744073709551616,k\n", "ABC", "user1")
+
+	f.Fuzz(func(t *testing.T, msg string, stock string, user string) {
+		reply, err := parseReply(msg, stock, user)
+		if err != nil {
+			if reply != nil {
+				t.Errorf("%q: returned a reply along with error %v", msg, err)
+			}
+			return
+		}
+		if reply.stock != stock || reply.user != user {
+			t.Errorf("%q: accepted reply for %s/%s", msg, reply.stock, reply.user)
+		}
+		if reply.key == "" || strings.ContainsAny(reply.key, ",\n") {
+			t.Errorf("%q: accepted bad key %q", msg, reply.key)
+		}
+		if !reply.quote.IsPositive() {
+			t.Errorf("%q: accepted non-positive price %s", msg, reply.quote)
+		}
+	})
+}
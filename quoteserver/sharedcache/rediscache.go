@@ -0,0 +1,185 @@
+package sharedcache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisCache is a quote cache shared between every quote server replica
+// and the trigger server. Prices are stored under "quote:<stock>" with a TTL,
+// and a short lived "quote:<stock>:lock" key makes sure only one replica
+// goes to the legacy quote server when a price expires.
+type RedisCache struct {
+	TTL     time.Duration
+	LockTTL time.Duration
+	Pool    *redis.Pool
+}
+
+// NewRedisCache returns a cache backed by the redis instance at addr:port,
+// logging in with password if it isn't empty
+func NewRedisCache(addr string, port string, password string, ttl time.Duration) *RedisCache {
+	return &RedisCache{
+		TTL:     ttl,
+		LockTTL: time.Second * 5,
+		Pool: &redis.Pool{
+			MaxIdle:     100,
+			MaxActive:   0,
+			IdleTimeout: time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr+":"+port, redis.DialPassword(password))
+			},
+		},
+	}
+}
+
+// Ping checks that the cache's redis can be reached
+func (c *RedisCache) Ping() error {
+	conn := c.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
+func quoteKey(stock string) string {
+	return "quote:" + stock
+}
+
+func lockKey(stock string) string {
+	return "quote:" + stock + ":lock"
+}
+
+// Get returns the cached price of stock, if there is one. An error means
+// the cache couldn't be read, not that the price is missing.
+func (c *RedisCache) Get(stock string) (string, bool, error) {
+	conn := c.Pool.Get()
+	defer conn.Close()
+
+	price, err := redis.String(conn.Do("GET", quoteKey(stock)))
+	if err == redis.ErrNil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return price, true, nil
+}
+
+// Set stores the price of stock for the cache's TTL
+func (c *RedisCache) Set(stock string, price string) error {
+	conn := c.Pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", quoteKey(stock), price, "PX", int64(c.TTL/time.Millisecond))
+	return err
+}
+
+// Lock attempts to become the single fetcher for stock.
+// Returns true if the caller holds the lock and should hit the legacy server,
+// along with the token to release it by, or false if another replica holds
+// it. An error means redis couldn't be asked, so nobody may hold it.
+func (c *RedisCache) Lock(stock string) (string, bool, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(b)
+
+	conn := c.Pool.Get()
+	defer conn.Close()
+
+	_, err := redis.String(conn.Do("SET", lockKey(stock), token, "PX", int64(c.LockTTL/time.Millisecond), "NX"))
+	if err == redis.ErrNil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// unlockScript deletes a lock only if it still holds the caller's token, so a
+// fetch that outlived the lock's TTL doesn't release another replica's lock
+var unlockScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Unlock releases the fetch lock on stock taken with token
+func (c *RedisCache) Unlock(stock string, token string) {
+	conn := c.Pool.Get()
+	defer conn.Close()
+
+	unlockScript.Do(conn, lockKey(stock), token)
+}
+
+// Wait polls the cache until another replica has filled in the price for
+// stock, or the lock it holds expires. It gives up as soon as the cache
+// can't be read.
+func (c *RedisCache) Wait(stock string) (string, bool, error) {
+	deadline := time.Now().Add(c.LockTTL)
+	for time.Now().Before(deadline) {
+		price, found, err := c.Get(stock)
+		if err != nil || found {
+			return price, found, err
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return "", false, nil
+}
+
+// updatesChannel carries fresh prices between quote server replicas
+const updatesChannel = "quote:updates"
+
+// Publish announces a freshly fetched price to every replica.
+// Messages follow the format "stock,price,quoteServerTime"
+func (c *RedisCache) Publish(stock string, price string, qsTime uint64) error {
+	conn := c.Pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PUBLISH", updatesChannel, stock+","+price+","+strconv.FormatUint(qsTime, 10))
+	return err
+}
+
+// Subscribe calls fn with each price published by any replica.
+// Blocks forever, reconnecting if the connection to redis is lost.
+func (c *RedisCache) Subscribe(fn func(stock string, price string, qsTime uint64)) {
+	for {
+		psc := redis.PubSubConn{Conn: c.Pool.Get()}
+		err := psc.Subscribe(updatesChannel)
+		for err == nil {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				if stock, price, qsTime, ok := parseUpdate(string(v.Data)); ok {
+					fn(stock, price, qsTime)
+				}
+			case error:
+				err = v
+			}
+		}
+		fmt.Println("Lost quote update subscription, resubscribing:", err)
+		psc.Close()
+		time.Sleep(time.Second)
+	}
+}
+
+// parseUpdate splits a published message into its stock, price and quote
+// server time, reporting false for one that isn't in the format Publish sends
+func parseUpdate(message string) (string, string, uint64, bool) {
+	params := strings.Split(message, ",")
+	if len(params) != 3 {
+		return "", "", 0, false
+	}
+	qsTime, err := strconv.ParseUint(params[2], 10, 64)
+	if err != nil {
+		return "", "", 0, false
+	}
+	return params[0], params[1], qsTime, true
+}
@@ -0,0 +1,361 @@
+package sharedcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fakeRedis answers the commands the cache sends over the redis protocol,
+// with EVAL doing what unlockScript does, so the cache can be tested without
+// a redis server
+type fakeRedis struct {
+	listener    net.Listener
+	lock        sync.Mutex
+	values      map[string]string
+	expires     map[string]time.Time
+	subscribers map[string][]*bufio.Writer
+}
+
+func startFakeRedis(t testing.TB) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		listener:    l,
+		values:      make(map[string]string),
+		expires:     make(map[string]time.Time),
+		subscribers: make(map[string][]*bufio.Writer),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) Close() {
+	f.listener.Close()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		// Published messages are written to w as well, so every write to a
+		// connection happens under the lock
+		f.lock.Lock()
+		w.WriteString(f.reply(w, args))
+		err = w.Flush()
+		f.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readLength(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		length, err := readLength(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:length])
+	}
+	return args, nil
+}
+
+// readLength reads a line like "*3\r\n" that starts with prefix
+func readLength(r *bufio.Reader, prefix byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimSpace(line)
+	if len(line) < 2 || line[0] != prefix {
+		return 0, fmt.Errorf("expected %c, got %q", prefix, line)
+	}
+	return strconv.Atoi(line[1:])
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// get returns the value of key unless it has expired
+func (f *fakeRedis) get(key string) (string, bool) {
+	if expires, ok := f.expires[key]; ok && time.Now().After(expires) {
+		delete(f.values, key)
+		delete(f.expires, key)
+	}
+	v, ok := f.values[key]
+	return v, ok
+}
+
+func (f *fakeRedis) reply(w *bufio.Writer, args []string) string {
+	switch {
+	case args[0] == "PING":
+		return "+PONG\r\n"
+	case args[0] == "GET" && len(args) == 2:
+		v, ok := f.get(args[1])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(v)
+	case args[0] == "SET" && len(args) >= 3:
+		key := args[1]
+		var ttl time.Duration
+		for i := 3; i < len(args); i++ {
+			switch args[i] {
+			case "NX":
+				if _, ok := f.get(key); ok {
+					return "$-1\r\n"
+				}
+			case "PX":
+				i++
+				ms, _ := strconv.Atoi(args[i])
+				ttl = time.Duration(ms) * time.Millisecond
+			}
+		}
+		f.values[key] = args[2]
+		delete(f.expires, key)
+		if ttl > 0 {
+			f.expires[key] = time.Now().Add(ttl)
+		}
+		return "+OK\r\n"
+	case args[0] == "EVALSHA":
+		// Makes redigo send the script with EVAL
+		return "-NOSCRIPT No matching script\r\n"
+	case args[0] == "EVAL" && len(args) == 5:
+		if v, ok := f.get(args[3]); ok && v == args[4] {
+			delete(f.values, args[3])
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case args[0] == "SUBSCRIBE" && len(args) == 2:
+		f.subscribers[args[1]] = append(f.subscribers[args[1]], w)
+		return "*3\r\n" + bulk("subscribe") + bulk(args[1]) + ":1\r\n"
+	case args[0] == "PUBLISH" && len(args) == 3:
+		subscribers := f.subscribers[args[1]]
+		for _, sub := range subscribers {
+			sub.WriteString("*3\r\n" + bulk("message") + bulk(args[1]) + bulk(args[2]))
+			sub.Flush()
+		}
+		return fmt.Sprintf(":%d\r\n", len(subscribers))
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func newCache(addr string, ttl time.Duration, lockTTL time.Duration) *RedisCache {
+	return &RedisCache{
+		TTL:     ttl,
+		LockTTL: lockTTL,
+		Pool: &redis.Pool{
+			MaxIdle: 10,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr)
+			},
+		},
+	}
+}
+
+func TestGetAndSet(t *testing.T) {
+	f := startFakeRedis(t)
+	defer f.Close()
+	c := newCache(f.listener.Addr().String(), 50*time.Millisecond, time.Second)
+
+	if price, found, err := c.Get("ABC"); found || err != nil {
+		t.Errorf("Get before Set = %q, %v, %v, want not found", price, found, err)
+	}
+	if err := c.Set("ABC", "12.34"); err != nil {
+		t.Fatal(err)
+	}
+	if price, found, err := c.Get("ABC"); price != "12.34" || !found || err != nil {
+		t.Errorf("Get = %q, %v, %v, want 12.34", price, found, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if price, found, err := c.Get("ABC"); found || err != nil {
+		t.Errorf("Get after the TTL = %q, %v, %v, want not found", price, found, err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	f := startFakeRedis(t)
+	defer f.Close()
+	addr := f.listener.Addr().String()
+	// Two replicas sharing the cache
+	a := newCache(addr, time.Minute, 50*time.Millisecond)
+	b := newCache(addr, time.Minute, 50*time.Millisecond)
+
+	token, locked, err := a.Lock("ABC")
+	if !locked || err != nil {
+		t.Fatalf("Lock = %v, %v, want the lock", locked, err)
+	}
+	if _, locked, err := b.Lock("ABC"); locked || err != nil {
+		t.Errorf("second Lock = %v, %v, want it held by the first", locked, err)
+	}
+	if _, locked, err := b.Lock("XYZ"); !locked || err != nil {
+		t.Errorf("Lock of another stock = %v, %v, want the lock", locked, err)
+	}
+
+	// Releasing with another replica's token leaves the lock in place
+	b.Unlock("ABC", "not-the-token")
+	if _, locked, _ := b.Lock("ABC"); locked {
+		t.Error("lock released with the wrong token")
+	}
+	a.Unlock("ABC", token)
+	bToken, locked, err := b.Lock("ABC")
+	if !locked || err != nil {
+		t.Fatalf("Lock after Unlock = %v, %v, want the lock", locked, err)
+	}
+
+	// A lock outlived by its fetch expires, and the late Unlock of the first
+	// holder doesn't release the one taken after it
+	time.Sleep(60 * time.Millisecond)
+	aToken, locked, err := a.Lock("ABC")
+	if !locked || err != nil {
+		t.Fatalf("Lock after the TTL = %v, %v, want the lock", locked, err)
+	}
+	b.Unlock("ABC", bToken)
+	if _, locked, _ := b.Lock("ABC"); locked {
+		t.Error("an expired lock's token released the lock taken after it")
+	}
+	a.Unlock("ABC", aToken)
+}
+
+func TestWait(t *testing.T) {
+	f := startFakeRedis(t)
+	defer f.Close()
+	addr := f.listener.Addr().String()
+	fetcher := newCache(addr, time.Minute, time.Second)
+	waiter := newCache(addr, time.Minute, time.Second)
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		fetcher.Set("ABC", "12.34")
+	}()
+	if price, found, err := waiter.Wait("ABC"); price != "12.34" || !found || err != nil {
+		t.Errorf("Wait = %q, %v, %v, want the price the other replica set", price, found, err)
+	}
+
+	short := newCache(addr, time.Minute, 30*time.Millisecond)
+	if price, found, err := short.Wait("XYZ"); found || err != nil {
+		t.Errorf("Wait for a price nobody sets = %q, %v, %v, want not found", price, found, err)
+	}
+}
+
+func TestRedisDown(t *testing.T) {
+	f := startFakeRedis(t)
+	addr := f.listener.Addr().String()
+	f.Close()
+	c := newCache(addr, time.Minute, 5*time.Second)
+
+	if _, _, err := c.Get("ABC"); err == nil {
+		t.Error("Get didn't fail with redis down")
+	}
+	if _, locked, err := c.Lock("ABC"); locked || err == nil {
+		t.Errorf("Lock = %v, %v with redis down, want an error", locked, err)
+	}
+	// Fails straight away instead of polling for the lock's TTL
+	start := time.Now()
+	if _, _, err := c.Wait("ABC"); err == nil {
+		t.Error("Wait didn't fail with redis down")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Wait took %v with redis down", waited)
+	}
+}
+
+func TestPublishAndSubscribe(t *testing.T) {
+	f := startFakeRedis(t)
+	defer f.Close()
+	c := newCache(f.listener.Addr().String(), time.Minute, time.Second)
+
+	type update struct {
+		stock, price string
+		qsTime       uint64
+	}
+	updates := make(chan update, 10)
+	go c.Subscribe(func(stock string, price string, qsTime uint64) {
+		updates <- update{stock, price, qsTime}
+	})
+
+	// Publish until the subscription is in place
+	conn := c.Pool.Get()
+	defer conn.Close()
+	for start := time.Now(); ; {
+		n, err := redis.Int(conn.Do("PUBLISH", updatesChannel, "bad message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > 0 {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("Subscribe never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.Publish("ABC", "12.34", 1500000000000); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case u := <-updates:
+		if u != (update{"ABC", "12.34", 1500000000000}) {
+			t.Errorf("got %+v, want ABC at 12.34", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("published price never arrived")
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	tests := []struct {
+		message string
+		ok      bool
+	}{
+		{"ABC,12.34,1500000000000", true},
+		{"ABC,12.34", false},
+		{"ABC,12.34,1500000000000,extra", false},
+		{"ABC,12.34,soon", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		stock, price, qsTime, ok := parseUpdate(test.message)
+		if ok != test.ok {
+			t.Errorf("parseUpdate(%q) ok = %v, want %v", test.message, ok, test.ok)
+		}
+		if ok && (stock != "ABC" || price != "12.34" || qsTime != 1500000000000) {
+			t.Errorf("parseUpdate(%q) = %q, %q, %d", test.message, stock, price, qsTime)
+		}
+	}
+}
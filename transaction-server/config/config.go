@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"seng468/transaction-server/database/batch"
+	"seng468/transaction-server/logger"
+	"time"
+)
+
+// Config holds the settings of the transaction server
+type Config struct {
+	TransPort      string        `key:"transport" required:"true" help:"port to serve commands on"`
+	MetricsPort    string        `key:"metricsport" help:"port to serve /metrics, /healthz, /readyz and /ledgercheck on, if set"`
+	DrainTimeout   time.Duration `key:"shutdowntimeout" default:"20" unit:"s" help:"time to let requests finish at shutdown"`
+	DbAddr         string        `key:"dbaddr" required:"true" help:"redis host"`
+	DbPort         string        `key:"dbport" required:"true" help:"redis port"`
+	RedisPassword  string        `key:"redispassword" secret:"true" help:"redis password, if one is set"`
+	DbBatchSize    int           `key:"dbbatchsize" default:"100" help:"most queries sent to redis at once, 1 sends them one by one"`
+	DbPollRate     time.Duration `key:"dbpollrate" default:"20" unit:"ms" help:"longest wait to fill a batch of queries, 0 never waits"`
+	DbQueueSize    int           `key:"dbqueuesize" default:"1000" help:"queries waiting for a batch before callers block"`
+	LedgerCheck    time.Duration `key:"ledgercheckinterval" default:"0" unit:"m" help:"how often to check every account against its ledger, 0 never does"`
+	BasisMethod    string        `key:"costbasismethod" default:"average" help:"average or fifo, how the cost of sold shares is taken from what was paid"`
+	QuoteAddr      string        `key:"quoteaddr" required:"true"`
+	QuotePort      string        `key:"quoteport" required:"true"`
+	TriggerAddr    string        `key:"triggeraddr" required:"true"`
+	TriggerPort    string        `key:"triggerport" required:"true"`
+	AuditAddr      string        `key:"auditaddr" required:"true"`
+	AuditPort      string        `key:"auditport" required:"true"`
+	AuditBatchSize int           `key:"auditbatchsize" default:"100" help:"audit events sent at once, 1 sends them one by one"`
+	AuditInterval  time.Duration `key:"auditbatchinterval" default:"250" unit:"ms" help:"longest wait to fill a batch of audit events"`
+	AuditQueueSize int           `key:"auditqueuesize" default:"10000" help:"audit events kept in memory before auditqueuepolicy applies"`
+	AuditPolicy    string        `key:"auditqueuepolicy" default:"spill" help:"spill or block once the audit queue is full"`
+	AuditSpillFile string        `key:"auditspillfile" default:"audit-spill.jsonl" help:"where undeliverable audit events are kept"`
+}
+
+// Validate checks the settings that can't be wrong on their own
+func (c *Config) Validate() error {
+	if c.DbBatchSize < 1 || c.DbQueueSize < 1 || c.AuditBatchSize < 1 || c.AuditQueueSize < 1 {
+		return errors.New("batch and queue sizes must be at least 1")
+	}
+	if c.DbPollRate < 0 || c.LedgerCheck < 0 || c.AuditInterval <= 0 || c.DrainTimeout <= 0 {
+		return errors.New("durations must be positive")
+	}
+	if c.BasisMethod != "average" && c.BasisMethod != "fifo" {
+		return errors.New("costbasismethod must be average or fifo")
+	}
+	_, err := logger.ParseFullPolicy(c.AuditPolicy)
+	return err
+}
+
+// AuditURL is the base URL of the audit server
+func (c *Config) AuditURL() string {
+	return "http://" + c.AuditAddr + ":" + c.AuditPort
+}
+
+// QuoteURL is the base URL of the quote server
+func (c *Config) QuoteURL() string {
+	return "http://" + c.QuoteAddr + ":" + c.QuotePort
+}
+
+// TriggerURL is the base URL of the trigger server
+func (c *Config) TriggerURL() string {
+	return "http://" + c.TriggerAddr + ":" + c.TriggerPort
+}
+
+// QueueOptions tunes the audit event queue
+func (c *Config) QueueOptions() logger.QueueOptions {
+	policy, _ := logger.ParseFullPolicy(c.AuditPolicy)
+	return logger.QueueOptions{
+		BatchSize: c.AuditBatchSize,
+		Interval:  c.AuditInterval,
+		MaxQueue:  c.AuditQueueSize,
+		Policy:    policy,
+		SpillPath: c.AuditSpillFile,
+	}
+}
+
+// BatchOptions bounds the batches of queries sent to redis
+func (c *Config) BatchOptions() batch.Options {
+	return batch.Options{
+		MaxBatch:  c.DbBatchSize,
+		MaxWait:   c.DbPollRate,
+		QueueSize: c.DbQueueSize,
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Addr     string        `key:"testaddr" required:"true"`
+	Port     string        `key:"testport" default:"80"`
+	Size     int           `key:"testsize" default:"100"`
+	Interval time.Duration `key:"testinterval" default:"250" unit:"ms"`
+	Enabled  bool          `key:"testenabled"`
+	Password string        `key:"testpassword" secret:"true"`
+}
+
+func load(t *testing.T, cfg interface{}, args ...string) error {
+	return Load(cfg, flag.NewFlagSet("test", flag.ContinueOnError), args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test.env")
+	ioutil.WriteFile(file, []byte("# comment\ntestaddr=fromfile\ntestport=81\n\ntestsize=5\n"), 0644)
+
+	os.Setenv("testport", "82")
+	os.Setenv("testsize", "")
+	defer os.Unsetenv("testport")
+	defer os.Unsetenv("testsize")
+
+	var cfg testConfig
+	if err := load(t, &cfg, "-config", file, "-testinterval=2s", "-testenabled=true"); err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{Addr: "fromfile", Port: "82", Size: 5, Interval: 2 * time.Second, Enabled: true}
+	if cfg != want {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadDefaultsAndUnits(t *testing.T) {
+	var cfg testConfig
+	if err := load(t, &cfg, "-testaddr=host"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "80" || cfg.Size != 100 || cfg.Interval != 250*time.Millisecond {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	var cfg testConfig
+	err := load(t, &cfg, "-testsize=lots", "-testinterval=soon")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"testaddr is required", "testsize", "testinterval"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %s", err, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{}
+	err := load(t, &cfg, "-transport=1", "-dbaddr=db", "-dbport=2", "-quoteaddr=q", "-quoteport=3",
+		"-triggeraddr=t", "-triggerport=4", "-auditaddr=a", "-auditport=5", "-auditqueuepolicy=drop")
+	if err == nil || !strings.Contains(err.Error(), "drop") {
+		t.Errorf("bad policy got %v", err)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := testConfig{Addr: "host", Interval: time.Second, Password: "hunter2"}
+	var buf bytes.Buffer
+	Print(&buf, &cfg)
+	out := buf.String()
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, "testpassword=********") {
+		t.Errorf("secret not hidden:\n%s", out)
+	}
+	if !strings.Contains(out, "testaddr=host\n") || !strings.Contains(out, "testinterval=1s\n") {
+		t.Errorf("settings missing:\n%s", out)
+	}
+}
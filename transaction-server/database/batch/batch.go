@@ -0,0 +1,271 @@
+// Package batch pipelines redis commands from many goroutines over one
+// connection, so that concurrent callers share a round trip to redis.
+package batch
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Options bound how large batches get and how long a command waits for others
+// to join it. The batcher adapts within these bounds to the load it sees.
+type Options struct {
+	MaxBatch  int           // most commands sent in one pipeline
+	MaxWait   time.Duration // longest a command waits for a batch to fill
+	QueueSize int           // commands waiting for a batch before callers block
+}
+
+// Query is one redis command, answered on its own reply channel so that
+// results can't be handed to the wrong caller
+type Query struct {
+	Command string
+	Args    []interface{}
+	reply   chan Response
+}
+
+// Response is the reply to a query
+type Response struct {
+	Reply interface{}
+	Err   error
+}
+
+// Batcher sends queries to redis in pipelined batches
+type Batcher struct {
+	pool    *redis.Pool
+	opts    Options
+	queries chan *Query
+	flushes chan chan struct{}
+	size    int64 // current batch limit, for metrics
+	wait    int64 // current wait in ns, for metrics
+}
+
+// New starts a batcher sending queries through pool
+func New(pool *redis.Pool, opts Options) *Batcher {
+	b := newBatcher(pool, opts)
+	go b.run()
+	return b
+}
+
+func newBatcher(pool *redis.Pool, opts Options) *Batcher {
+	if opts.MaxBatch < 1 {
+		opts.MaxBatch = 1
+	}
+	if opts.MaxWait < 0 {
+		opts.MaxWait = 0
+	}
+	if opts.QueueSize < opts.MaxBatch {
+		opts.QueueSize = opts.MaxBatch
+	}
+	b := &Batcher{
+		pool:    pool,
+		opts:    opts,
+		queries: make(chan *Query, opts.QueueSize),
+		flushes: make(chan chan struct{}),
+		size:    int64(opts.MaxBatch),
+	}
+	return b
+}
+
+// Do queues the command and blocks until its own reply comes back
+func (b *Batcher) Do(command string, args ...interface{}) (interface{}, error) {
+	q := newQuery(command, args...)
+	b.queries <- q
+	resp := <-q.reply
+	return resp.Reply, resp.Err
+}
+
+func newQuery(command string, args ...interface{}) *Query {
+	return &Query{Command: command, Args: args, reply: make(chan Response, 1)}
+}
+
+// Flush waits until every query queued so far has been answered
+func (b *Batcher) Flush() {
+	done := make(chan struct{})
+	b.flushes <- done
+	<-done
+}
+
+// Len returns the number of queries waiting for a batch
+func (b *Batcher) Len() int {
+	return len(b.queries)
+}
+
+// BatchSize returns the current limit on the size of a batch
+func (b *Batcher) BatchSize() int {
+	return int(atomic.LoadInt64(&b.size))
+}
+
+// Wait returns how long a query currently waits for its batch to fill
+func (b *Batcher) Wait() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.wait))
+}
+
+func (b *Batcher) run() {
+	size, wait := b.opts.MaxBatch, time.Duration(0)
+	// Waiting longer than a round trip to redis costs a query more than
+	// sending without it and letting it go in the next batch, so the wait
+	// is held under the average time taken to send a batch
+	var roundTrip time.Duration
+	// Callers block until they are answered, so the last batch is about
+	// as many as are sending now and waiting for more than that is
+	// usually in vain
+	expect := 0
+	for {
+		var f filled
+		select {
+		case q := <-b.queries:
+			limit := wait
+			if limit > roundTrip {
+				limit = roundTrip
+			}
+			f = b.fill([]*Query{q}, size, expect, limit)
+		case f.flushed = <-b.flushes:
+			f.batch = b.drain(nil)
+		}
+		start := time.Now()
+		b.send(f.batch)
+		roundTrip += (time.Since(start) - roundTrip) / 8
+		if f.flushed != nil {
+			// A flushed batch says nothing about the load, so it is
+			// left out of adapting
+			close(f.flushed)
+			continue
+		}
+		expect = len(f.batch)
+		size, wait = adapt(f, size, wait, b.opts)
+		atomic.StoreInt64(&b.size, int64(size))
+		atomic.StoreInt64(&b.wait, int64(wait))
+	}
+}
+
+// filled describes how a batch was gathered
+type filled struct {
+	batch    []*Query
+	gained   int           // queries that arrived while waiting
+	timedOut bool          // the wait ran out before the batch was as expected
+	flushed  chan struct{} // a flush to answer once the batch is sent
+}
+
+// fill adds queued queries to batch until it holds size of them. Once the
+// queue runs dry it waits at most wait for more, but only while the batch is
+// smaller than expect. A flush cuts the wait short.
+func (b *Batcher) fill(batch []*Query, size, expect int, wait time.Duration) filled {
+	f := filled{batch: batch}
+	var timeout <-chan time.Time
+	for len(f.batch) < size {
+		select {
+		case q := <-b.queries:
+			f.batch = append(f.batch, q)
+			continue
+		default:
+		}
+		if wait <= 0 || len(f.batch) >= expect {
+			return f
+		}
+		if timeout == nil {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case q := <-b.queries:
+			f.batch = append(f.batch, q)
+			f.gained++
+		case f.flushed = <-b.flushes:
+			f.batch = b.drain(f.batch)
+			return f
+		case <-timeout:
+			f.timedOut = true
+			return f
+		}
+	}
+	return f
+}
+
+// drain adds every query already queued to batch, ignoring the size limit
+func (b *Batcher) drain(batch []*Query) []*Query {
+	for {
+		select {
+		case q := <-b.queries:
+			batch = append(batch, q)
+		default:
+			return batch
+		}
+	}
+}
+
+// minWait is the shortest wait worth setting a timer for
+func minWait(opts Options) time.Duration {
+	return opts.MaxWait / 32
+}
+
+// adapt tunes the size limit and wait of the next batch to how the last
+// one was gathered.
+// A full batch means queries arrive faster than they are sent, so the limit
+// doubles. A batch under a quarter full halves it, so a burst doesn't hold
+// the limit high after it has passed.
+// Queries are only held back while it pays: the wait doubles when queries
+// joined the batch during it, and halves until it stops altogether when the
+// timer ran out with nothing gained. With no wait, a full batch is taken as
+// load worth trying to gather again.
+func adapt(f filled, size int, wait time.Duration, opts Options) (int, time.Duration) {
+	n := len(f.batch)
+	full := n >= size
+	switch {
+	case full:
+		size *= 2
+	case n < size/4:
+		size /= 2
+	}
+	if size > opts.MaxBatch {
+		size = opts.MaxBatch
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	switch {
+	case f.timedOut && f.gained == 0:
+		wait /= 2
+		if wait < minWait(opts) {
+			wait = 0
+		}
+	case f.timedOut:
+		wait *= 2
+	case full && wait == 0:
+		wait = minWait(opts)
+	}
+	if wait > opts.MaxWait {
+		wait = opts.MaxWait
+	}
+	return size, wait
+}
+
+// send runs batch as one pipeline and hands each query its own reply
+func (b *Batcher) send(batch []*Query) {
+	if len(batch) == 0 {
+		return
+	}
+	conn := b.pool.Get()
+	defer conn.Close()
+	// A failed write breaks the connection, so its error is also
+	// returned by every Receive after it
+	for _, q := range batch {
+		conn.Send(q.Command, q.Args...)
+	}
+	conn.Flush()
+	for _, q := range batch {
+		r, err := conn.Receive()
+		q.reply <- Response{r, err}
+	}
+}
+
+// Direct runs the command on a connection of its own, for callers that
+// need no batching or to compare against it
+func Direct(pool *redis.Pool, command string, args ...interface{}) (interface{}, error) {
+	conn := pool.Get()
+	defer conn.Close()
+	return conn.Do(command, args...)
+}
@@ -0,0 +1,294 @@
+package batch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fakeRedis answers PING, GET and INCRBY over the redis protocol, enough to
+// check that replies reach the right callers without a redis server
+type fakeRedis struct {
+	listener net.Listener
+	lock     sync.Mutex
+	values   map[string]int64
+}
+
+func startFakeRedis(t testing.TB) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{listener: l, values: make(map[string]int64)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) Close() {
+	f.listener.Close()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		w.WriteString(f.reply(args))
+		// Like redis, answer a pipeline in as few writes as possible
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readLength(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		length, err := readLength(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:length])
+	}
+	return args, nil
+}
+
+// readLength reads a line like "*3\r\n" that starts with prefix
+func readLength(r *bufio.Reader, prefix byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimSpace(line)
+	if len(line) < 2 || line[0] != prefix {
+		return 0, fmt.Errorf("expected %c, got %q", prefix, line)
+	}
+	return strconv.Atoi(line[1:])
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch {
+	case args[0] == "PING":
+		return "+PONG\r\n"
+	case args[0] == "GET" && len(args) == 2:
+		v, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		s := strconv.FormatInt(v, 10)
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+	case args[0] == "INCRBY" && len(args) == 3:
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer\r\n"
+		}
+		f.values[args[1]] += n
+		return fmt.Sprintf(":%d\r\n", f.values[args[1]])
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func newPool(addr string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle: 100,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	}
+}
+
+func TestRepliesReachTheirCallers(t *testing.T) {
+	f := startFakeRedis(t)
+	defer f.Close()
+	b := New(newPool(f.listener.Addr().String()), Options{MaxBatch: 8, MaxWait: time.Millisecond})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for want := int64(1); want <= 50; want++ {
+				got, err := redis.Int64(b.Do("INCRBY", key, 1))
+				if err != nil || got != want {
+					t.Errorf("%s: got %d, %v, want %d", key, got, err, want)
+					return
+				}
+			}
+		}(fmt.Sprintf("user%d:Balance", i))
+	}
+	wg.Wait()
+
+	if _, err := b.Do("NOPE"); err == nil {
+		t.Error("expected the error reply of an unknown command")
+	}
+	if _, err := redis.Int64(b.Do("GET", "missing")); err != redis.ErrNil {
+		t.Errorf("got %v for a missing key, want ErrNil", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	f := startFakeRedis(t)
+	defer f.Close()
+	b := newBatcher(newPool(f.listener.Addr().String()), Options{MaxBatch: 2, MaxWait: time.Minute, QueueSize: 10})
+
+	// Queue more than a batch before the worker starts
+	var queued []*Query
+	for i := 0; i < 5; i++ {
+		q := newQuery("INCRBY", "key", 1)
+		b.queries <- q
+		queued = append(queued, q)
+	}
+	go b.run()
+	b.Flush()
+
+	for i, q := range queued {
+		select {
+		case resp := <-q.reply:
+			if resp.Err != nil {
+				t.Errorf("query %d: %v", i, resp.Err)
+			}
+		default:
+			t.Errorf("query %d wasn't answered by the flush", i)
+		}
+	}
+}
+
+func TestConnectionError(t *testing.T) {
+	f := startFakeRedis(t)
+	addr := f.listener.Addr().String()
+	f.Close()
+	b := New(newPool(addr), Options{MaxBatch: 4})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.Do("PING"); err == nil {
+				t.Error("expected an error with redis down")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAdapt(t *testing.T) {
+	opts := Options{MaxBatch: 64, MaxWait: 32 * time.Millisecond}
+	ms := time.Millisecond
+	cases := []struct {
+		name      string
+		n, gained int
+		timedOut  bool
+		size      int
+		wait      time.Duration
+		wantSize  int
+		wantWait  time.Duration
+	}{
+		{"full batch grows", 8, 0, false, 8, 0, 16, ms},
+		{"growth is capped", 64, 10, false, 64, 4 * ms, 64, 4 * ms},
+		{"sparse batch shrinks", 3, 0, false, 16, 0, 8, 0},
+		{"wasted wait halves", 4, 0, true, 8, 4 * ms, 8, 2 * ms},
+		{"short wasted wait stops", 1, 0, true, 4, ms, 4, 0},
+		{"useful wait doubles", 5, 3, true, 8, 4 * ms, 8, 8 * ms},
+		{"wait is capped", 5, 3, true, 8, 32 * ms, 8, 32 * ms},
+		{"limit never reaches zero", 0, 0, false, 1, 0, 1, 0},
+	}
+	for _, c := range cases {
+		f := filled{batch: make([]*Query, c.n), gained: c.gained, timedOut: c.timedOut}
+		size, wait := adapt(f, c.size, c.wait, opts)
+		if size != c.wantSize || wait != c.wantWait {
+			t.Errorf("%s: got %d, %v, want %d, %v", c.name, size, wait, c.wantSize, c.wantWait)
+		}
+	}
+}
+
+// benchPool connects to the redis named by the benchredis env var, e.g.
+// benchredis=localhost:6379, or else to a fake one in this process
+func benchPool(b *testing.B) *redis.Pool {
+	if addr := os.Getenv("benchredis"); addr != "" {
+		return newPool(addr)
+	}
+	f := startFakeRedis(b)
+	b.Cleanup(f.Close)
+	return newPool(f.listener.Addr().String())
+}
+
+func BenchmarkBatched(b *testing.B) {
+	batcher := New(benchPool(b), Options{MaxBatch: 100, MaxWait: 20 * time.Millisecond, QueueSize: 1000})
+	b.SetParallelism(32)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := batcher.Do("INCRBY", "bench:Balance", 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDirect(b *testing.B) {
+	pool := benchPool(b)
+	b.SetParallelism(32)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := Direct(pool, "INCRBY", "bench:Balance", 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// The lone caller benchmarks show the cost of batching without concurrency
+func BenchmarkBatchedOneCaller(b *testing.B) {
+	batcher := New(benchPool(b), Options{MaxBatch: 100, MaxWait: 20 * time.Millisecond, QueueSize: 1000})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := batcher.Do("INCRBY", "bench:Balance", 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDirectOneCaller(b *testing.B) {
+	pool := benchPool(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Direct(pool, "INCRBY", "bench:Balance", 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
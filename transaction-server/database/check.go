@@ -0,0 +1,195 @@
+package database
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/shopspring/decimal"
+)
+
+// CashAccount names the cash of a user in a Discrepancy, as opposed to a stock
+const CashAccount = "cash"
+
+// Discrepancy is an account of a user that doesn't add up to their ledger.
+// For cash, Actual is funds plus reserved funds plus the cost of pending buy
+// orders, and Expected the sum of the ledger's cash deltas: deposits plus
+// trades. For a stock, Actual is shares held plus reserved plus pending sell
+// orders, and Expected the shares the ledger shows being bought less sold.
+type Discrepancy struct {
+	User     string          `json:"user"`
+	Account  string          `json:"account"`
+	Expected decimal.Decimal `json:"expected"`
+	Actual   decimal.Decimal `json:"actual"`
+}
+
+// CheckReport is the result of checking every user's accounts
+type CheckReport struct {
+	Checked       time.Time     `json:"checked"`
+	Users         int           `json:"users"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// CheckAccount replays entries and compares the result to info, returning
+// the accounts that don't match ordered by name
+func CheckAccount(info UserInfo, entries []LedgerEntry) []Discrepancy {
+	cash := decimal.Zero
+	shares := make(map[string]int64)
+	for _, e := range entries {
+		cash = cash.Add(e.CashDelta)
+		if e.Stock != "" {
+			shares[e.Stock] += e.Shares
+		}
+	}
+
+	held := info.Funds.Add(info.ReservedFunds)
+	for _, o := range info.BuyOrders {
+		held = held.Add(o.Cost)
+	}
+	var found []Discrepancy
+	if !held.Equal(cash) {
+		found = append(found, Discrepancy{info.User, CashAccount, cash, held})
+	}
+
+	owned := make(map[string]int64)
+	for stock, n := range info.Stocks {
+		owned[stock] += n
+	}
+	for stock, n := range info.ReservedStocks {
+		owned[stock] += n
+	}
+	for _, o := range info.SellOrders {
+		owned[o.Stock] += o.Shares
+	}
+	var stocks []string
+	for stock := range owned {
+		stocks = append(stocks, stock)
+	}
+	for stock := range shares {
+		if _, ok := owned[stock]; !ok {
+			stocks = append(stocks, stock)
+		}
+	}
+	sort.Strings(stocks)
+	for _, stock := range stocks {
+		if owned[stock] != shares[stock] {
+			found = append(found, Discrepancy{info.User, stock,
+				decimal.New(shares[stock], 0), decimal.New(owned[stock], 0)})
+		}
+	}
+	return found
+}
+
+// CheckUser compares user's accounts to their ledger, reading both at once
+func (u RedisDatabase) CheckUser(user string) ([]Discrepancy, error) {
+	c := u.DbPool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	sendUserInfo(c, user)
+	c.Send("LRANGE", user+":Ledger", 0, -1)
+	r, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	info, err := GetUserInfoFromReply(user, r[:len(r)-1], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := redis.Strings(r[len(r)-1], nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LedgerEntry, 0, len(encoded))
+	for _, data := range encoded {
+		var e LedgerEntry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return CheckAccount(info, entries), nil
+}
+
+// Users lists everyone with a balance or a ledger
+func (u RedisDatabase) Users() ([]string, error) {
+	c := u.DbPool.Get()
+	defer c.Close()
+	users := make(map[string]bool)
+	for _, suffix := range []string{":Balance", ":Ledger"} {
+		cursor := 0
+		for {
+			r, err := redis.Values(c.Do("SCAN", cursor, "MATCH", "*"+suffix, "COUNT", 1000))
+			if err != nil {
+				return nil, err
+			}
+			var keys []string
+			if _, err := redis.Scan(r, &cursor, &keys); err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				users[strings.TrimSuffix(key, suffix)] = true
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	list := make([]string, 0, len(users))
+	for user := range users {
+		list = append(list, user)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// CheckAll checks every user's accounts against their ledger. Accounts are
+// changed by several queries per command, so a user that doesn't add up is
+// checked again after settle and only reported if the same accounts are off.
+func (u RedisDatabase) CheckAll(settle time.Duration) (CheckReport, error) {
+	report := CheckReport{Checked: time.Now(), Discrepancies: []Discrepancy{}}
+	users, err := u.Users()
+	if err != nil {
+		return report, err
+	}
+	report.Users = len(users)
+
+	suspect := make(map[string][]Discrepancy)
+	for _, user := range users {
+		found, err := u.CheckUser(user)
+		if err != nil {
+			return report, err
+		}
+		if len(found) > 0 {
+			suspect[user] = found
+		}
+	}
+	if len(suspect) > 0 && settle > 0 {
+		time.Sleep(settle)
+	}
+	for _, user := range users {
+		if suspect[user] == nil {
+			continue
+		}
+		found, err := u.CheckUser(user)
+		if err != nil {
+			return report, err
+		}
+		for _, d := range found {
+			if hasAccount(suspect[user], d.Account) {
+				report.Discrepancies = append(report.Discrepancies, d)
+			}
+		}
+	}
+	return report, nil
+}
+
+func hasAccount(found []Discrepancy, account string) bool {
+	for _, d := range found {
+		if d.Account == account {
+			return true
+		}
+	}
+	return false
+}
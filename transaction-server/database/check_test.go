@@ -0,0 +1,68 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestCheckAccount(t *testing.T) {
+	ledger := []LedgerEntry{
+		{Type: LedgerAdd, CashDelta: dollars("100")},
+		buy("ABC", 10, "50"),
+		sell("ABC", 4, "24"),
+		buy("XYZ", 2, "10"),
+	}
+	// What the ledger leaves: 64 in cash, 6 ABC and 2 XYZ
+	balanced := func() UserInfo {
+		return UserInfo{
+			User:           "bob",
+			Funds:          dollars("40"),
+			ReservedFunds:  dollars("4"),
+			Stocks:         map[string]int64{"ABC": 3},
+			ReservedStocks: map[string]int64{"ABC": 1, "XYZ": 2},
+			BuyOrders:      []Order{{Stock: "DEF", Cost: dollars("20"), Shares: 2}},
+			SellOrders:     []Order{{Stock: "ABC", Cost: dollars("12"), Shares: 2}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(info *UserInfo)
+		entries []LedgerEntry
+		want    []Discrepancy
+	}{
+		{"balanced", func(info *UserInfo) {}, ledger, nil},
+		{"empty", func(info *UserInfo) { *info = UserInfo{User: "bob"} }, nil, nil},
+		{"cash off", func(info *UserInfo) { info.ReservedFunds = dollars("0") }, ledger, []Discrepancy{
+			{"bob", CashAccount, dollars("64"), dollars("60")},
+		}},
+		{"pending sell not counted", func(info *UserInfo) { info.SellOrders = nil }, ledger, []Discrepancy{
+			{"bob", "ABC", dollars("6"), dollars("4")},
+		}},
+		{"shares the ledger doesn't show", func(info *UserInfo) { info.Stocks["QQQ"] = 5 }, ledger, []Discrepancy{
+			{"bob", "QQQ", dollars("0"), dollars("5")},
+		}},
+		{"shares missing, ordered by stock", func(info *UserInfo) {
+			info.Stocks = nil
+			info.ReservedStocks = nil
+			info.SellOrders = nil
+		}, ledger, []Discrepancy{
+			{"bob", "ABC", dollars("6"), dollars("0")},
+			{"bob", "XYZ", dollars("2"), dollars("0")},
+		}},
+	}
+	for _, test := range tests {
+		info := balanced()
+		test.change(&info)
+		got := CheckAccount(info, test.entries)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: CheckAccount() = %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i].User != test.want[i].User || got[i].Account != test.want[i].Account ||
+				!got[i].Expected.Equal(test.want[i].Expected) || !got[i].Actual.Equal(test.want[i].Actual) {
+				t.Errorf("%s: discrepancy %d = %v, want %v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/shopspring/decimal"
+)
+
+// CostBasis is what a user paid for the shares of a stock they hold
+type CostBasis struct {
+	Shares      int64           `json:"shares"`
+	Cost        decimal.Decimal `json:"cost"`
+	AverageCost decimal.Decimal `json:"averageCost"`
+	// Lots are the purchases still held, oldest first, kept when the
+	// database uses FIFO lots
+	Lots []Lot `json:"lots,omitempty"`
+}
+
+// Lot is the part of one purchase still held
+type Lot struct {
+	Shares int64           `json:"shares"`
+	Cost   decimal.Decimal `json:"cost"`
+	Placed int64           `json:"placed"` // unix ms
+}
+
+// Costs are kept in cents, in hashes of shares and cost per stock, and lots
+// in a list per stock encoded as "shares:cents:placed"
+func basisKeys(user string, stock string) []interface{} {
+	return []interface{}{user + ":BasisShares", user + ":BasisCost", user + ":Lots:" + stock}
+}
+
+// AddCostBasis adds shares of stock bought for cost to the user's basis
+func (u RedisDatabase) AddCostBasis(user string, stock string, shares int64, cost decimal.Decimal, placed time.Time) error {
+	keys := basisKeys(user, stock)
+	c := u.DbPool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("HINCRBY", keys[0], stock, shares)
+	c.Send("HINCRBY", keys[1], stock, u.dollarToCents(cost))
+	if u.FIFOLots {
+		c.Send("RPUSH", keys[2], encodeLot(Lot{shares, cost, placed.UnixNano() / int64(time.Millisecond)}))
+	}
+	_, err := c.Do("EXEC")
+	return err
+}
+
+// removeBasisScript takes sold shares of a stock out of a user's basis and
+// returns their cost in cents: the oldest lots when ARGV[3] is "1", or an
+// average share of the cost otherwise. Selling every share removes all of
+// the cost, and shares beyond those with a basis cost nothing.
+var removeBasisScript = redis.NewScript(3, `
+local held = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+local cost = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or '0')
+local sold = tonumber(ARGV[2])
+if held <= 0 then
+	return 0
+end
+if sold > held then
+	sold = held
+end
+local basis = 0
+if ARGV[3] == '1' then
+	local left = sold
+	while left > 0 do
+		local lot = redis.call('LINDEX', KEYS[3], 0)
+		if not lot then
+			break
+		end
+		local shares, lotCost, placed = string.match(lot, '^(%d+):(%d+):(%d+)$')
+		shares = tonumber(shares)
+		lotCost = tonumber(lotCost)
+		if shares <= left then
+			basis = basis + lotCost
+			left = left - shares
+			redis.call('LPOP', KEYS[3])
+		else
+			local part = math.floor(lotCost * left / shares)
+			basis = basis + part
+			redis.call('LSET', KEYS[3], 0, (shares - left) .. ':' .. (lotCost - part) .. ':' .. placed)
+			left = 0
+		end
+	end
+	if sold == held then
+		basis = cost
+		redis.call('DEL', KEYS[3])
+	end
+else
+	basis = math.floor(cost * sold / held)
+end
+redis.call('HINCRBY', KEYS[1], ARGV[1], -sold)
+redis.call('HINCRBY', KEYS[2], ARGV[1], -basis)
+return basis
+`)
+
+// RemoveCostBasis takes shares of stock sold out of the user's basis,
+// returning what they cost
+func (u RedisDatabase) RemoveCostBasis(user string, stock string, shares int64) (decimal.Decimal, error) {
+	fifo := "0"
+	if u.FIFOLots {
+		fifo = "1"
+	}
+	keys := basisKeys(user, stock)
+	c := u.DbPool.Get()
+	defer c.Close()
+	cents, err := redis.Int64(removeBasisScript.Do(c, keys[0], keys[1], keys[2], stock, shares, fifo))
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return u.centsToDollar(cents), nil
+}
+
+// GetCostBasis returns the basis of every stock the user holds shares of
+func (u RedisDatabase) GetCostBasis(user string) (map[string]CostBasis, error) {
+	c := u.DbPool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("HGETALL", user+":BasisShares")
+	c.Send("HGETALL", user+":BasisCost")
+	r, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	shares, err := redis.Int64Map(r[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	costs, err := redis.Int64Map(r[1], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	basis := make(map[string]CostBasis)
+	for stock, n := range shares {
+		if n <= 0 {
+			continue
+		}
+		cost := u.centsToDollar(costs[stock])
+		basis[stock] = CostBasis{
+			Shares:      n,
+			Cost:        cost,
+			AverageCost: cost.Div(decimal.New(n, 0)).Round(2),
+		}
+	}
+	if !u.FIFOLots || len(basis) == 0 {
+		return basis, nil
+	}
+
+	var stocks []string
+	for stock := range basis {
+		stocks = append(stocks, stock)
+		c.Send("LRANGE", user+":Lots:"+stock, 0, -1)
+	}
+	c.Flush()
+	for _, stock := range stocks {
+		encoded, err := redis.Strings(c.Receive())
+		if err != nil {
+			return nil, err
+		}
+		b := basis[stock]
+		for _, lot := range encoded {
+			b.Lots = append(b.Lots, decodeLot(lot))
+		}
+		basis[stock] = b
+	}
+	return basis, nil
+}
+
+func encodeLot(l Lot) string {
+	return strconv.FormatInt(l.Shares, 10) + ":" + strconv.FormatInt(l.Cost.Shift(2).IntPart(), 10) + ":" +
+		strconv.FormatInt(l.Placed, 10)
+}
+
+func decodeLot(lot string) Lot {
+	split := strings.Split(lot, ":")
+	if len(split) != 3 {
+		return Lot{Cost: decimal.Zero}
+	}
+	var l Lot
+	var cents int64
+	l.Shares, _ = strconv.ParseInt(split[0], 10, 64)
+	cents, _ = strconv.ParseInt(split[1], 10, 64)
+	l.Cost = decimal.New(cents, -2)
+	l.Placed, _ = strconv.ParseInt(split[2], 10, 64)
+	return l
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// The fake redis of the batch tests can't run scripts, so removeBasisScript
+// is tested against a real redis like the rest of this package
+func TestRemoveCostBasis(t *testing.T) {
+	type purchase struct {
+		shares int64
+		cost   string
+	}
+	tests := []struct {
+		name  string
+		fifo  bool
+		buys  []purchase
+		sells []int64
+		basis []string // of each sale
+		// What is left of the basis, and of its lots with fifo
+		shares int64
+		cost   string
+		lots   []Lot
+	}{
+		{"average", false, []purchase{{10, "100"}, {10, "200"}}, []int64{5, 5},
+			[]string{"75", "75"}, 10, "150", nil},
+		{"average rounds down to the cent", false, []purchase{{3, "10"}}, []int64{1, 1},
+			[]string{"3.33", "3.33"}, 1, "3.34", nil},
+		{"average selling every share", false, []purchase{{3, "10"}}, []int64{1, 2},
+			[]string{"3.33", "6.67"}, 0, "0", nil},
+		{"more sold than held", false, []purchase{{2, "20"}}, []int64{3},
+			[]string{"20"}, 0, "0", nil},
+		{"nothing held", false, nil, []int64{1},
+			[]string{"0"}, 0, "0", nil},
+		{"fifo oldest lot first", true, []purchase{{10, "100"}, {10, "200"}}, []int64{5},
+			[]string{"50"}, 15, "250", []Lot{{Shares: 5, Cost: dollars("50")}, {Shares: 10, Cost: dollars("200")}}},
+		{"fifo across lots", true, []purchase{{10, "100"}, {10, "200"}}, []int64{5, 10},
+			[]string{"50", "150"}, 5, "100", []Lot{{Shares: 5, Cost: dollars("100")}}},
+		{"fifo selling every share", true, []purchase{{3, "10"}, {3, "20"}}, []int64{4, 2},
+			[]string{"16.66", "13.34"}, 0, "0", nil},
+		{"fifo more sold than held", true, []purchase{{2, "20"}}, []int64{3},
+			[]string{"20"}, 0, "0", nil},
+	}
+
+	const user = "basis-test"
+	for _, test := range tests {
+		db := testDatabase(t)
+		db.FIFOLots = test.fifo
+		clear := func() {
+			db.DeleteKey(user + ":BasisShares")
+			db.DeleteKey(user + ":BasisCost")
+			db.DeleteKey(user + ":Lots:ABC")
+		}
+		clear()
+
+		for _, b := range test.buys {
+			if err := db.AddCostBasis(user, "ABC", b.shares, dollars(b.cost), time.Unix(0, 0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, sold := range test.sells {
+			basis, err := db.RemoveCostBasis(user, "ABC", sold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !basis.Equal(dollars(test.basis[i])) {
+				t.Errorf("%s: sale %d cost %s, want %s", test.name, i, basis, test.basis[i])
+			}
+		}
+
+		all, err := db.GetCostBasis(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		left, held := all["ABC"]
+		if test.shares == 0 {
+			if held {
+				t.Errorf("%s: basis left over: %+v", test.name, left)
+			}
+		} else if left.Shares != test.shares || !left.Cost.Equal(dollars(test.cost)) {
+			t.Errorf("%s: basis left = %d shares for %s, want %d for %s",
+				test.name, left.Shares, left.Cost, test.shares, test.cost)
+		}
+		if len(left.Lots) != len(test.lots) {
+			t.Errorf("%s: lots left = %+v, want %+v", test.name, left.Lots, test.lots)
+		} else {
+			for i, lot := range left.Lots {
+				if lot.Shares != test.lots[i].Shares || !lot.Cost.Equal(test.lots[i].Cost) {
+					t.Errorf("%s: lot %d = %+v, want %+v", test.name, i, lot, test.lots[i])
+				}
+			}
+		}
+		clear()
+	}
+}
+
+func TestLotEncoding(t *testing.T) {
+	tests := []struct {
+		lot     Lot
+		encoded string
+	}{
+		{Lot{Shares: 10, Cost: dollars("123.45"), Placed: 1520000000000}, "10:12345:1520000000000"},
+		{Lot{Shares: 1, Cost: dollars("0.01"), Placed: 0}, "1:1:0"},
+	}
+	for _, test := range tests {
+		if got := encodeLot(test.lot); got != test.encoded {
+			t.Errorf("encodeLot(%+v) = %q, want %q", test.lot, got, test.encoded)
+		}
+		got := decodeLot(test.encoded)
+		if got.Shares != test.lot.Shares || !got.Cost.Equal(test.lot.Cost) || got.Placed != test.lot.Placed {
+			t.Errorf("decodeLot(%q) = %+v, want %+v", test.encoded, got, test.lot)
+		}
+	}
+	if got := decodeLot("garbage"); got.Shares != 0 || !got.Cost.IsZero() {
+		t.Errorf("decodeLot(garbage) = %+v, want an empty lot", got)
+	}
+}
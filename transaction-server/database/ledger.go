@@ -0,0 +1,175 @@
+package database
+
+import (
+	"encoding/json"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/shopspring/decimal"
+)
+
+// Types of ledger entry
+const (
+	LedgerAdd         = "ADD"
+	LedgerBuy         = "BUY"
+	LedgerSell        = "SELL"
+	LedgerTriggerBuy  = "TRIGGER_BUY"
+	LedgerTriggerSell = "TRIGGER_SELL"
+	LedgerLimitBuy    = "LIMIT_BUY"
+	LedgerLimitSell   = "LIMIT_SELL"
+	LedgerMatchBuy    = "MATCH_BUY"
+	LedgerMatchSell   = "MATCH_SELL"
+)
+
+// LedgerEntry is one completed change to a user's account.
+// Entries are only ever appended to a user's ledger, never changed.
+type LedgerEntry struct {
+	Type      string          `json:"type"`
+	Stock     string          `json:"stock,omitempty"`
+	Shares    int64           `json:"shares"`    // shares gained, negative when sold
+	Price     decimal.Decimal `json:"price"`     // per share, 0 for ADD
+	CashDelta decimal.Decimal `json:"cashDelta"` // change to the user's cash, reserves included
+	// Basis is the cost of the shares sold, by the user's cost basis at the
+	// time. Entries recorded before cost basis was kept don't have it.
+	Basis *decimal.Decimal `json:"basis,omitempty"`
+	// Counterparty is the other user of a trade made by the matching engine
+	Counterparty string `json:"counterparty,omitempty"`
+	TransNum     int    `json:"transNum"`
+	Timestamp    int64  `json:"timestamp"` // unix ms
+}
+
+// LedgerPage is part of a user's ledger
+type LedgerPage struct {
+	Entries []LedgerEntry `json:"entries"`
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+	Total   int           `json:"total"`
+	// Next is the offset of the following page, if there is one
+	Next *int `json:"next,omitempty"`
+}
+
+// Stored is amount as the database keeps it, without fractions of a cent.
+// Ledger entries record cash this way so they add up to the accounts.
+func Stored(amount decimal.Decimal) decimal.Decimal {
+	return decimal.New(amount.Shift(2).IntPart(), -2)
+}
+
+// AppendLedger records e at the end of user's ledger
+func (u RedisDatabase) AppendLedger(user string, e LedgerEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = u.do("RPUSH", user+":Ledger", data)
+	return err
+}
+
+// GetLedger returns at most limit entries of user's ledger, skipping the
+// first offset. Entries are counted forward from the oldest, or back from
+// the most recent when newest is set.
+func (u RedisDatabase) GetLedger(user string, offset int, limit int, newest bool) (LedgerPage, error) {
+	page := LedgerPage{Entries: []LedgerEntry{}, Offset: offset, Limit: limit}
+	start, stop := offset, offset+limit-1
+	if newest {
+		start, stop = -(offset + limit), -(offset + 1)
+	}
+
+	c := u.DbPool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("LLEN", user+":Ledger")
+	c.Send("LRANGE", user+":Ledger", start, stop)
+	r, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		return page, err
+	}
+	var total int
+	var encoded []string
+	if _, err := redis.Scan(r, &total, &encoded); err != nil {
+		return page, err
+	}
+
+	page.Total = total
+	if newest {
+		for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+			encoded[i], encoded[j] = encoded[j], encoded[i]
+		}
+	}
+	for _, data := range encoded {
+		var e LedgerEntry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return page, err
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if offset+limit < total {
+		next := offset + limit
+		page.Next = &next
+	}
+	return page, nil
+}
+
+// GetAllLedger returns every entry of user's ledger, oldest first
+func (u RedisDatabase) GetAllLedger(user string) ([]LedgerEntry, error) {
+	encoded, err := redis.Strings(u.do("LRANGE", user+":Ledger", 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LedgerEntry, 0, len(encoded))
+	for _, data := range encoded {
+		var e LedgerEntry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// PnL is the profit or loss realized by the sales in a ledger
+type PnL struct {
+	Total   decimal.Decimal            `json:"total"`
+	ByStock map[string]decimal.Decimal `json:"byStock"`
+}
+
+// RealizedPnL works out the profit or loss of each sale in entries against
+// the cost basis recorded with it. Sales without one are taken against the
+// average cost of the shares the ledger shows held at the time, and shares
+// sold beyond those bought are taken to have cost nothing.
+func RealizedPnL(entries []LedgerEntry) PnL {
+	pnl := PnL{Total: decimal.Zero, ByStock: make(map[string]decimal.Decimal)}
+	held := make(map[string]int64)
+	cost := make(map[string]decimal.Decimal)
+	for _, e := range entries {
+		switch {
+		case e.Stock == "" || e.Shares == 0:
+			continue
+		case e.Shares > 0:
+			held[e.Stock] += e.Shares
+			cost[e.Stock] = cost[e.Stock].Sub(e.CashDelta)
+		default:
+			sold := -e.Shares
+			basis := decimal.Zero
+			if held[e.Stock] > 0 {
+				covered := sold
+				if covered > held[e.Stock] {
+					covered = held[e.Stock]
+				}
+				// Multiplying first keeps selling every share exact
+				basis = cost[e.Stock].Mul(decimal.New(covered, 0)).Div(decimal.New(held[e.Stock], 0))
+				held[e.Stock] -= covered
+				cost[e.Stock] = cost[e.Stock].Sub(basis)
+			}
+			if e.Basis != nil {
+				basis = *e.Basis
+			}
+			gain := e.CashDelta.Sub(basis)
+			pnl.ByStock[e.Stock] = pnl.ByStock[e.Stock].Add(gain)
+			pnl.Total = pnl.Total.Add(gain)
+		}
+	}
+	pnl.Total = pnl.Total.Round(2)
+	for stock, gain := range pnl.ByStock {
+		pnl.ByStock[stock] = gain.Round(2)
+	}
+	return pnl
+}
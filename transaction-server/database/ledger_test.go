@@ -0,0 +1,86 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func dollars(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func buy(stock string, shares int64, cost string) LedgerEntry {
+	return LedgerEntry{Type: LedgerBuy, Stock: stock, Shares: shares, CashDelta: dollars(cost).Neg()}
+}
+
+func sell(stock string, shares int64, proceeds string) LedgerEntry {
+	return LedgerEntry{Type: LedgerSell, Stock: stock, Shares: -shares, CashDelta: dollars(proceeds)}
+}
+
+func withBasis(e LedgerEntry, basis string) LedgerEntry {
+	b := dollars(basis)
+	e.Basis = &b
+	return e
+}
+
+func TestRealizedPnL(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []LedgerEntry
+		total   string
+		byStock map[string]string
+	}{
+		{"no sales", []LedgerEntry{
+			{Type: LedgerAdd, CashDelta: dollars("100")},
+			buy("ABC", 10, "50"),
+		}, "0", map[string]string{}},
+		{"average cost of shares held", []LedgerEntry{
+			buy("ABC", 10, "100"),
+			buy("ABC", 10, "200"),
+			sell("ABC", 5, "100"),
+		}, "25", map[string]string{"ABC": "25"}},
+		{"recorded basis", []LedgerEntry{
+			buy("ABC", 10, "100"),
+			buy("ABC", 10, "200"),
+			withBasis(sell("ABC", 5, "100"), "50"),
+		}, "50", map[string]string{"ABC": "50"}},
+		{"loss", []LedgerEntry{
+			buy("ABC", 4, "40"),
+			sell("ABC", 4, "30"),
+		}, "-10", map[string]string{"ABC": "-10"}},
+		{"more sold than bought", []LedgerEntry{
+			buy("ABC", 2, "20"),
+			sell("ABC", 3, "45"),
+		}, "25", map[string]string{"ABC": "25"}},
+		{"sold before any buy", []LedgerEntry{
+			sell("ABC", 1, "10"),
+		}, "10", map[string]string{"ABC": "10"}},
+		{"thirds add up when every share is sold", []LedgerEntry{
+			buy("ABC", 3, "10"),
+			sell("ABC", 1, "5"),
+			sell("ABC", 2, "10"),
+		}, "5", map[string]string{"ABC": "5"}},
+		{"by stock", []LedgerEntry{
+			buy("ABC", 1, "10"),
+			buy("XYZ", 1, "10"),
+			sell("ABC", 1, "12.50"),
+			sell("XYZ", 1, "7.25"),
+		}, "-0.25", map[string]string{"ABC": "2.5", "XYZ": "-2.75"}},
+	}
+	for _, test := range tests {
+		pnl := RealizedPnL(test.entries)
+		if !pnl.Total.Equal(dollars(test.total)) {
+			t.Errorf("%s: total = %s, want %s", test.name, pnl.Total, test.total)
+		}
+		if len(pnl.ByStock) != len(test.byStock) {
+			t.Errorf("%s: byStock = %v, want %v", test.name, pnl.ByStock, test.byStock)
+			continue
+		}
+		for stock, want := range test.byStock {
+			if got := pnl.ByStock[stock]; !got.Equal(dollars(want)) {
+				t.Errorf("%s: %s = %s, want %s", test.name, stock, got, want)
+			}
+		}
+	}
+}
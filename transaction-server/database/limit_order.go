@@ -0,0 +1,291 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/shopspring/decimal"
+)
+
+// LimitOrder is a LIMIT_BUY or LIMIT_SELL kept in redis from when it is
+// placed until it is filled or cancelled. The trigger server's order book
+// rests it, and every fill or cancel is checked against it here.
+type LimitOrder struct {
+	ID       int64           `json:"id"`
+	User     string          `json:"user"`
+	TransNum int             `json:"transNum"`
+	Stock    string          `json:"stock"`
+	Action   string          `json:"action"`
+	Shares   int64           `json:"shares"`
+	Filled   int64           `json:"filled"`
+	Price    decimal.Decimal `json:"price"`
+	Placed   int64           `json:"placed"` // unix ms
+	Status   string          `json:"status"` // open, or partial once part is filled
+}
+
+// StaleOrderError is returned when a fill doesn't fit what is left of an
+// order, because it has been cancelled, filled, or filled further than the
+// order book knew
+type StaleOrderError struct {
+	ID     int64
+	Filled int64 // shares filled so far, or -1 once the order is closed
+}
+
+func (e *StaleOrderError) Error() string {
+	if e.Filled < 0 {
+		return fmt.Sprintf("limit order %d is not open", e.ID)
+	}
+	return fmt.Sprintf("limit order %d has %d shares filled", e.ID, e.Filled)
+}
+
+// Orders are hashes under "LimitOrder:<id>", with prices in cents. Their ids
+// are kept in a set per user and in a set of every open order.
+const (
+	limitOrderIDKey = "LimitOrderID"
+	openOrdersKey   = "LimitOrders"
+)
+
+func limitOrderKey(id int64) string {
+	return "LimitOrder:" + strconv.FormatInt(id, 10)
+}
+
+// limitKeys are the keys of an order and of every account of its user that
+// a script might move
+func limitKeys(id int64, user string) []interface{} {
+	return []interface{}{limitOrderKey(id), user + ":LimitOrders", openOrdersKey,
+		user + ":Balance", user + ":BalanceReserve", user + ":Stocks", user + ":StocksReserve"}
+}
+
+// placeLimitScript moves the funds or shares an order needs into reserve and
+// stores it, unless the user doesn't have them
+var placeLimitScript = redis.NewScript(7, `
+local amount = tonumber(ARGV[9])
+if ARGV[4] == 'BUY' then
+	if tonumber(redis.call('GET', KEYS[4]) or '0') < amount then
+		return redis.error_reply('SHORT not enough funds')
+	end
+	redis.call('DECRBY', KEYS[4], amount)
+	redis.call('INCRBY', KEYS[5], amount)
+else
+	if tonumber(redis.call('HGET', KEYS[6], ARGV[3]) or '0') < amount then
+		return redis.error_reply('SHORT not enough shares')
+	end
+	redis.call('HINCRBY', KEYS[6], ARGV[3], -amount)
+	redis.call('HINCRBY', KEYS[7], ARGV[3], amount)
+end
+redis.call('HMSET', KEYS[1], 'user', ARGV[2], 'stock', ARGV[3], 'action', ARGV[4], 'shares', ARGV[5],
+	'filled', 0, 'price', ARGV[6], 'placed', ARGV[7], 'transnum', ARGV[8])
+redis.call('SADD', KEYS[2], ARGV[1])
+redis.call('SADD', KEYS[3], ARGV[1])
+return 1
+`)
+
+// PlaceLimitOrder reserves what o needs from its user's accounts and stores
+// it, returning it with its id
+func (u RedisDatabase) PlaceLimitOrder(o LimitOrder) (LimitOrder, error) {
+	c := u.DbPool.Get()
+	defer c.Close()
+	id, err := redis.Int64(c.Do("INCR", limitOrderIDKey))
+	if err != nil {
+		return LimitOrder{}, err
+	}
+	price := u.dollarToCents(o.Price)
+	amount := o.Shares
+	if o.Action == "BUY" {
+		amount = price * o.Shares
+	}
+	keys := limitKeys(id, o.User)
+	args := append(keys, id, o.User, o.Stock, o.Action, o.Shares, price, o.Placed, o.TransNum, amount)
+	if _, err := placeLimitScript.Do(c, args...); err != nil {
+		return LimitOrder{}, scriptError(err)
+	}
+	o.ID, o.Filled, o.Status = id, 0, "open"
+	return o, nil
+}
+
+// checkOrderLua defines check, which reads an order unless it is closed,
+// isn't the user's, or has fewer than shares left, and fill, which records
+// shares of it filled
+const checkOrderLua = `
+local function check(key, id, user, stock, action, shares)
+	local f = redis.call('HMGET', key, 'user', 'stock', 'action', 'shares', 'filled', 'price')
+	if not f[1] or f[1] ~= user or f[2] ~= stock or f[3] ~= action then
+		return nil, 'STALE ' .. id .. ' -1'
+	end
+	local o = {shares = tonumber(f[4]), filled = tonumber(f[5]), price = tonumber(f[6])}
+	if shares < 1 or o.filled + shares > o.shares then
+		return nil, 'STALE ' .. id .. ' ' .. o.filled
+	end
+	return o
+end
+
+-- fill deletes an order once every share of it is filled
+local function fill(key, id, userOrders, o, shares)
+	if o.filled + shares == o.shares then
+		redis.call('DEL', key)
+		redis.call('SREM', userOrders, id)
+		redis.call('SREM', KEYS[3], id)
+	else
+		redis.call('HINCRBY', key, 'filled', shares)
+	end
+end
+`
+
+// fillLimitScript settles shares of an order filled at a price against the
+// quote, after checking the order and what it has reserved
+var fillLimitScript = redis.NewScript(7, checkOrderLua+`
+local shares = tonumber(ARGV[5])
+local price = tonumber(ARGV[6])
+local o, stale = check(KEYS[1], ARGV[1], ARGV[2], ARGV[3], ARGV[4], shares)
+if not o then
+	return redis.error_reply(stale)
+end
+if ARGV[4] == 'BUY' then
+	if price > o.price then
+		return redis.error_reply('LIMIT price is above the limit')
+	end
+	local reserved = o.price * shares
+	if tonumber(redis.call('GET', KEYS[5]) or '0') < reserved then
+		return redis.error_reply('SHORT not enough reserved funds')
+	end
+	redis.call('DECRBY', KEYS[5], reserved)
+	redis.call('INCRBY', KEYS[4], reserved - price * shares)
+	redis.call('HINCRBY', KEYS[6], ARGV[3], shares)
+else
+	if price < o.price then
+		return redis.error_reply('LIMIT price is below the limit')
+	end
+	if tonumber(redis.call('HGET', KEYS[7], ARGV[3]) or '0') < shares then
+		return redis.error_reply('SHORT not enough reserved shares')
+	end
+	redis.call('HINCRBY', KEYS[7], ARGV[3], -shares)
+	redis.call('INCRBY', KEYS[4], price * shares)
+end
+fill(KEYS[1], ARGV[1], KEYS[2], o, shares)
+return 1
+`)
+
+// FillLimitOrder settles shares of the user's order id filled at price,
+// returning a *StaleOrderError if the order doesn't have that many left
+func (u RedisDatabase) FillLimitOrder(user string, id int64, stock string, action string, shares int64,
+	price decimal.Decimal) error {
+	c := u.DbPool.Get()
+	defer c.Close()
+	args := append(limitKeys(id, user), id, user, stock, action, shares, u.dollarToCents(price))
+	_, err := fillLimitScript.Do(c, args...)
+	return scriptError(err)
+}
+
+// cancelLimitScript gives back what an order still has reserved and deletes
+// it, returning its fields
+var cancelLimitScript = redis.NewScript(7, `
+local f = redis.call('HMGET', KEYS[1], 'user', 'stock', 'action', 'shares', 'filled', 'price', 'placed', 'transnum')
+if not f[1] or f[1] ~= ARGV[2] then
+	return redis.error_reply('STALE ' .. ARGV[1] .. ' -1')
+end
+local left = tonumber(f[4]) - tonumber(f[5])
+if f[3] == 'BUY' then
+	local reserved = tonumber(f[6]) * left
+	redis.call('DECRBY', KEYS[5], reserved)
+	redis.call('INCRBY', KEYS[4], reserved)
+else
+	redis.call('HINCRBY', KEYS[7], f[2], -left)
+	redis.call('HINCRBY', KEYS[6], f[2], left)
+end
+redis.call('DEL', KEYS[1])
+redis.call('SREM', KEYS[2], ARGV[1])
+redis.call('SREM', KEYS[3], ARGV[1])
+return f
+`)
+
+// CancelLimitOrder deletes the user's order id, giving back what it has
+// reserved for the shares not yet filled
+func (u RedisDatabase) CancelLimitOrder(user string, id int64) (LimitOrder, error) {
+	c := u.DbPool.Get()
+	defer c.Close()
+	args := append(limitKeys(id, user), id, user)
+	fields, err := redis.Strings(cancelLimitScript.Do(c, args...))
+	if err != nil {
+		return LimitOrder{}, scriptError(err)
+	}
+	names := []string{"user", "stock", "action", "shares", "filled", "price", "placed", "transnum"}
+	hash := make(map[string]string)
+	for i, name := range names {
+		hash[name] = fields[i]
+	}
+	return u.decodeLimitOrder(id, hash), nil
+}
+
+// GetLimitOrders returns the open orders of the user, oldest first
+func (u RedisDatabase) GetLimitOrders(user string) ([]LimitOrder, error) {
+	return u.limitOrders(user + ":LimitOrders")
+}
+
+// OpenLimitOrders returns every open order, oldest first
+func (u RedisDatabase) OpenLimitOrders() ([]LimitOrder, error) {
+	return u.limitOrders(openOrdersKey)
+}
+
+func (u RedisDatabase) limitOrders(set string) ([]LimitOrder, error) {
+	c := u.DbPool.Get()
+	defer c.Close()
+	ids, err := redis.Int64s(c.Do("SORT", set))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		c.Send("HGETALL", limitOrderKey(id))
+	}
+	c.Flush()
+	orders := []LimitOrder{}
+	for _, id := range ids {
+		hash, err := redis.StringMap(c.Receive())
+		if err != nil {
+			return nil, err
+		}
+		// Filled or cancelled since the ids were read
+		if len(hash) == 0 {
+			continue
+		}
+		orders = append(orders, u.decodeLimitOrder(id, hash))
+	}
+	return orders, nil
+}
+
+func (u RedisDatabase) decodeLimitOrder(id int64, hash map[string]string) LimitOrder {
+	o := LimitOrder{ID: id, User: hash["user"], Stock: hash["stock"], Action: hash["action"], Status: "open"}
+	o.Shares, _ = strconv.ParseInt(hash["shares"], 10, 64)
+	o.Filled, _ = strconv.ParseInt(hash["filled"], 10, 64)
+	o.Placed, _ = strconv.ParseInt(hash["placed"], 10, 64)
+	o.TransNum, _ = strconv.Atoi(hash["transnum"])
+	cents, _ := strconv.ParseInt(hash["price"], 10, 64)
+	o.Price = u.centsToDollar(cents)
+	if o.Filled > 0 {
+		o.Status = "partial"
+	}
+	return o
+}
+
+// scriptError turns the STALE errors of the limit order scripts into a
+// *StaleOrderError, and strips the code from the others
+func scriptError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, "STALE ") {
+		var stale StaleOrderError
+		if _, scanErr := fmt.Sscanf(msg, "STALE %d %d", &stale.ID, &stale.Filled); scanErr == nil {
+			return &stale
+		}
+	}
+	for _, code := range []string{"SHORT ", "LIMIT ", "SELF "} {
+		if strings.HasPrefix(msg, code) {
+			return errors.New(strings.TrimPrefix(msg, code))
+		}
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+// The limit order scripts can't run on the fake redis of the batch tests
+// either, so this needs a real redis
+func TestLimitOrderLifecycle(t *testing.T) {
+	db := testDatabase(t)
+	const user = "limit-test"
+	clear := func() {
+		for _, key := range []string{"Balance", "BalanceReserve", "Stocks", "StocksReserve", "LimitOrders"} {
+			db.DeleteKey(user + ":" + key)
+		}
+	}
+	clear()
+	defer clear()
+	db.AddFunds(user, dollars("100"))
+
+	if _, err := db.PlaceLimitOrder(LimitOrder{User: user, Stock: "ABC", Action: "BUY", Shares: 11,
+		Price: dollars("10")}); err == nil {
+		t.Error("placed a buy costing more than the funds")
+	}
+	buy, err := db.PlaceLimitOrder(LimitOrder{User: user, Stock: "ABC", Action: "BUY", Shares: 10,
+		Price: dollars("10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.CancelLimitOrder(user, buy.ID)
+	checkAccounts(t, db, user, "0", "100", 0, 0)
+
+	if err := db.FillLimitOrder(user, buy.ID, "ABC", "BUY", 4, dollars("10.01")); err == nil {
+		t.Error("filled a buy above its limit")
+	}
+	if err := db.FillLimitOrder("someone", buy.ID, "ABC", "BUY", 4, dollars("9")); !isStale(err, buy.ID, -1) {
+		t.Errorf("filled another user's order: %v", err)
+	}
+	if err := db.FillLimitOrder(user, buy.ID, "ABC", "BUY", 4, dollars("9")); err != nil {
+		t.Fatal(err)
+	}
+	// 4 bought at 9 out of 40 reserved at 10
+	checkAccounts(t, db, user, "4", "60", 4, 0)
+	if err := db.FillLimitOrder(user, buy.ID, "ABC", "BUY", 7, dollars("9")); !isStale(err, buy.ID, 4) {
+		t.Errorf("filled more shares than the order has left: %v", err)
+	}
+
+	orders, err := db.GetLimitOrders(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].ID != buy.ID || orders[0].Filled != 4 || orders[0].Status != "partial" {
+		t.Errorf("orders = %+v, want order %d with 4 filled", orders, buy.ID)
+	}
+
+	cancelled, err := db.CancelLimitOrder(user, buy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Shares != 10 || cancelled.Filled != 4 || !cancelled.Price.Equal(dollars("10")) {
+		t.Errorf("cancelled %+v", cancelled)
+	}
+	checkAccounts(t, db, user, "64", "0", 4, 0)
+	if err := db.FillLimitOrder(user, buy.ID, "ABC", "BUY", 1, dollars("9")); !isStale(err, buy.ID, -1) {
+		t.Errorf("filled a cancelled order: %v", err)
+	}
+
+	sell, err := db.PlaceLimitOrder(LimitOrder{User: user, Stock: "ABC", Action: "SELL", Shares: 4,
+		Price: dollars("12")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAccounts(t, db, user, "64", "0", 0, 4)
+	if err := db.FillLimitOrder(user, sell.ID, "ABC", "SELL", 4, dollars("12.50")); err != nil {
+		t.Fatal(err)
+	}
+	checkAccounts(t, db, user, "114", "0", 0, 0)
+	if orders, _ := db.GetLimitOrders(user); len(orders) != 0 {
+		t.Errorf("filled order still open: %+v", orders)
+	}
+}
+
+func checkAccounts(t *testing.T, db RedisDatabase, user string, funds string, reserved string,
+	shares int64, reservedShares int64) {
+	t.Helper()
+	info, err := db.GetUserInfo(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Funds.Equal(dollars(funds)) || !info.ReservedFunds.Equal(dollars(reserved)) ||
+		info.Stocks["ABC"] != shares || info.ReservedStocks["ABC"] != reservedShares {
+		t.Errorf("accounts = %s, %s reserved, %d ABC, %d reserved, want %s, %s, %d, %d",
+			info.Funds, info.ReservedFunds, info.Stocks["ABC"], info.ReservedStocks["ABC"],
+			funds, reserved, shares, reservedShares)
+	}
+}
+
+func isStale(err error, id int64, filled int64) bool {
+	stale, ok := err.(*StaleOrderError)
+	return ok && stale.ID == id && stale.Filled == filled
+}
+
+func TestScriptError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("STALE 12 -1"), "limit order 12 is not open"},
+		{errors.New("STALE 12 4"), "limit order 12 has 4 shares filled"},
+		{errors.New("SHORT not enough funds"), "not enough funds"},
+		{errors.New("LIMIT price is above the limit"), "price is above the limit"},
+		{errors.New("SELF a user can not trade with themselves"), "a user can not trade with themselves"},
+		{errors.New("ERR unknown command"), "ERR unknown command"},
+	}
+	for _, test := range tests {
+		err := scriptError(test.err)
+		if (err == nil) != (test.want == "") || (err != nil && err.Error() != test.want) {
+			t.Errorf("scriptError(%v) = %v, want %q", test.err, err, test.want)
+		}
+	}
+	if !isStale(scriptError(errors.New("STALE 12 4")), 12, 4) {
+		t.Error("STALE isn't a *StaleOrderError")
+	}
+}
+
+func TestMatchLimitOrders(t *testing.T) {
+	db := testDatabase(t)
+	const buyer, seller = "match-test-buyer", "match-test-seller"
+	clear := func() {
+		for _, user := range []string{buyer, seller} {
+			for _, key := range []string{"Balance", "BalanceReserve", "Stocks", "StocksReserve", "LimitOrders"} {
+				db.DeleteKey(user + ":" + key)
+			}
+		}
+	}
+	clear()
+	defer clear()
+	db.AddFunds(buyer, dollars("100"))
+	db.AddFunds(seller, dollars("10"))
+	db.AddStock(seller, "ABC", 5)
+	db.AddStock(buyer, "ABC", 5)
+
+	buy, err := db.PlaceLimitOrder(LimitOrder{User: buyer, Stock: "ABC", Action: "BUY", Shares: 10,
+		Price: dollars("10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.CancelLimitOrder(buyer, buy.ID)
+	sell, err := db.PlaceLimitOrder(LimitOrder{User: seller, Stock: "ABC", Action: "SELL", Shares: 5,
+		Price: dollars("9")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.CancelLimitOrder(seller, sell.ID)
+	own, err := db.PlaceLimitOrder(LimitOrder{User: buyer, Stock: "ABC", Action: "SELL", Shares: 5,
+		Price: dollars("9")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.CancelLimitOrder(buyer, own.ID)
+
+	rejected := []struct {
+		name   string
+		seller string
+		sellID int64
+		shares int64
+		price  string
+	}{
+		{"with themselves", buyer, own.ID, 1, "9.50"},
+		{"above the buy limit", seller, sell.ID, 1, "10.01"},
+		{"below the sell limit", seller, sell.ID, 1, "8.99"},
+		{"more than the sell has left", seller, sell.ID, 6, "9.50"},
+		{"a sell of someone else", "someone", sell.ID, 1, "9.50"},
+	}
+	for _, test := range rejected {
+		if err := db.MatchLimitOrders(buyer, buy.ID, test.seller, test.sellID, "ABC", test.shares,
+			dollars(test.price)); err == nil {
+			t.Errorf("matched %s", test.name)
+		}
+	}
+	checkAccounts(t, db, seller, "10", "0", 0, 5)
+
+	if err := db.MatchLimitOrders(buyer, buy.ID, seller, sell.ID, "ABC", 5, dollars("9.50")); err != nil {
+		t.Fatal(err)
+	}
+	// 5 bought at 9.50 out of 50 reserved at 10
+	checkAccounts(t, db, buyer, "2.50", "50", 5, 5)
+	checkAccounts(t, db, seller, "57.50", "0", 0, 0)
+	if err := db.MatchLimitOrders(buyer, buy.ID, seller, sell.ID, "ABC", 1, dollars("9.50")); !isStale(err, sell.ID, -1) {
+		t.Errorf("matched a filled sell: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/shopspring/decimal"
+)
+
+// matchLimitScript trades shares between a buy and a sell order of different
+// users at a price, after checking both orders have that many shares left,
+// the price is within both limits, and both have reserved enough
+var matchLimitScript = redis.NewScript(14, checkOrderLua+`
+local shares = tonumber(ARGV[6])
+local price = tonumber(ARGV[7])
+if ARGV[2] == ARGV[5] then
+	return redis.error_reply('SELF a user can not trade with themselves')
+end
+local buy, stale = check(KEYS[1], ARGV[1], ARGV[2], ARGV[3], 'BUY', shares)
+if not buy then
+	return redis.error_reply(stale)
+end
+local sell
+sell, stale = check(KEYS[8], ARGV[4], ARGV[5], ARGV[3], 'SELL', shares)
+if not sell then
+	return redis.error_reply(stale)
+end
+if price > buy.price or price < sell.price then
+	return redis.error_reply('LIMIT price is outside the limits of the orders')
+end
+local reserved = buy.price * shares
+if tonumber(redis.call('GET', KEYS[5]) or '0') < reserved then
+	return redis.error_reply('SHORT not enough reserved funds')
+end
+if tonumber(redis.call('HGET', KEYS[14], ARGV[3]) or '0') < shares then
+	return redis.error_reply('SHORT not enough reserved shares')
+end
+redis.call('DECRBY', KEYS[5], reserved)
+redis.call('INCRBY', KEYS[4], reserved - price * shares)
+redis.call('HINCRBY', KEYS[6], ARGV[3], shares)
+redis.call('HINCRBY', KEYS[14], ARGV[3], -shares)
+redis.call('INCRBY', KEYS[11], price * shares)
+fill(KEYS[1], ARGV[1], KEYS[2], buy, shares)
+fill(KEYS[8], ARGV[4], KEYS[9], sell, shares)
+return 1
+`)
+
+// MatchLimitOrders trades shares of stock at price from the seller's order
+// sellID to the buyer's order buyID in one script, so neither side is
+// changed without the other, and nothing is changed unless both orders allow
+// the trade. The buyer pays out of the funds reserved by their order,
+// getting back what it reserved beyond price, and the seller gives up shares
+// reserved by theirs. A *StaleOrderError names the order that doesn't have
+// that many shares left.
+func (u RedisDatabase) MatchLimitOrders(buyer string, buyID int64, seller string, sellID int64, stock string,
+	shares int64, price decimal.Decimal) error {
+	c := u.DbPool.Get()
+	defer c.Close()
+	keys := append(limitKeys(buyID, buyer), limitKeys(sellID, seller)...)
+	args := append(keys, buyID, buyer, stock, sellID, seller, shares, u.dollarToCents(price))
+	_, err := matchLimitScript.Do(c, args...)
+	return scriptError(err)
+}
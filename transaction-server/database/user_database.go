@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"seng468/transaction-server/database/batch"
+
 	"github.com/garyburd/redigo/redis"
 
 	"github.com/shopspring/decimal"
@@ -15,7 +17,7 @@ var ErrNil = errors.New("redigo: nil returned")
 
 // UserDatabase holds all of the supported database commands
 type UserDatabase interface {
-	GetUserInfo(user string) (info string, err error)
+	GetUserInfo(user string) (info UserInfo, err error)
 
 	AddFunds(string, decimal.Decimal) error
 	GetFunds(string) (decimal.Decimal, error)
@@ -43,32 +45,31 @@ type UserDatabase interface {
 	PushSell(user string, stock string, cost decimal.Decimal, shares decimal.Decimal) error
 	PopSell(user string) (stock string, cost decimal.Decimal, shares decimal.Decimal, err error)
 
-	DbRequestWorker()
-	MakeDbRequests([]*Query)
-}
+	AppendLedger(user string, e LedgerEntry) error
+	GetLedger(user string, offset int, limit int, newest bool) (LedgerPage, error)
+	GetAllLedger(user string) ([]LedgerEntry, error)
 
-// Typical structure of a redis command
-type Query struct {
-	Command    string
-	UserString string
-	Params     []interface{}
-}
+	AddCostBasis(user string, stock string, shares int64, cost decimal.Decimal, placed time.Time) error
+	RemoveCostBasis(user string, stock string, shares int64) (decimal.Decimal, error)
+	GetCostBasis(user string) (map[string]CostBasis, error)
 
-// Represents a response from a redis database
-type Response struct {
-	r   interface{}
-	err error
+	PlaceLimitOrder(o LimitOrder) (LimitOrder, error)
+	FillLimitOrder(user string, id int64, stock string, action string, shares int64, price decimal.Decimal) error
+	MatchLimitOrders(buyer string, buyID int64, seller string, sellID int64, stock string, shares int64, price decimal.Decimal) error
+	CancelLimitOrder(user string, id int64) (LimitOrder, error)
+	GetLimitOrders(user string) ([]LimitOrder, error)
+	OpenLimitOrders() ([]LimitOrder, error)
 }
 
 // RedisDatabase holds the address of the redisDB
 type RedisDatabase struct {
-	Addr         string
-	Port         string
-	DbRequests   chan *Query
-	BatchSize    int
-	PollRate     time.Duration
-	BatchResults chan Response
-	DbPool       *redis.Pool
+	Addr    string
+	Port    string
+	DbPool  *redis.Pool
+	Batcher *batch.Batcher // nil runs each query straight on DbPool
+	// FIFOLots keeps each purchase as a lot and takes the cost of sold shares
+	// from the oldest lots, rather than at the average cost
+	FIFOLots bool
 }
 
 func (u RedisDatabase) getConn() redis.Conn {
@@ -79,39 +80,48 @@ func (u RedisDatabase) getConn() redis.Conn {
 	return c
 }
 
-func NewPool(addr string, port string) *redis.Pool {
+// NewPool connects to redis at port over the network addr, logging in
+// with password if it isn't empty
+func NewPool(addr string, port string, password string) *redis.Pool {
 	return &redis.Pool{
 		MaxIdle:     100,
 		MaxActive:   0,
 		IdleTimeout: 0,
-		Dial:        func() (redis.Conn, error) { return redis.Dial(addr, port) },
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial(addr, port, redis.DialPassword(password))
+		},
 	}
 }
 
-// GetUserInfo returns all of a users information in the database
-func (u RedisDatabase) GetUserInfo(user string) (info string, err error) {
+// Ping checks that redis can be reached through the pool
+func (u RedisDatabase) Ping() error {
 	c := u.DbPool.Get()
+	defer c.Close()
+	_, err := c.Do("PING")
+	return err
+}
+
+// GetUserInfo returns all of a users accounts and pending orders
+func (u RedisDatabase) GetUserInfo(user string) (info UserInfo, err error) {
+	c := u.DbPool.Get()
+	defer c.Close()
 	c.Send("MULTI")
+	sendUserInfo(c, user)
+	r, err := c.Do("EXEC")
+	if err != nil {
+		return UserInfo{}, err
+	}
+	return GetUserInfoFromReply(user, r, time.Now())
+}
+
+// sendUserInfo queues the queries read by GetUserInfoFromReply
+func sendUserInfo(c redis.Conn, user string) {
 	c.Send("GET", user+":Balance")
 	c.Send("HGETALL", user+":Stocks")
-	c.Send("LRANGE", user+":SellOrders", 0, 5)
-	c.Send("LRANGE", user+":BuyOrders", 0, 5)
-	// TODO triggers
-	///c.Send("GET", user+":SellTriggers")
-	//c.Send("GET", user+":BuyTriggers")
+	c.Send("LRANGE", user+":SellOrders", 0, -1)
+	c.Send("LRANGE", user+":BuyOrders", 0, -1)
 	c.Send("GET", user+":BalanceReserve")
 	c.Send("HGETALL", user+":StocksReserve")
-	// TODO history
-	r, err := c.Do("EXEC")
-	if err != nil {
-		return "", err
-	}
-	defer c.Close()
-	userInfo, err := GetUserInfoFromReply(user, r)
-	if err != nil {
-		return "", err
-	}
-	return userInfo.getString(), nil
 }
 
 // PushSell adds a record of the users requested sell to their account
@@ -146,14 +156,7 @@ func (u RedisDatabase) pushOrder(transType string, user string,
 		return errors.New("Bad transaction type of " + transType)
 	}
 
-	query := new(Query)
-	query.Command = "RPUSH"
-	query.UserString = user + accountSuffix
-	query.Params = append(query.Params, encodeOrder(stock, cost, shares))
-	u.DbRequests <- query
-	resp := <-u.BatchResults
-
-	_, err := redis.Int64(resp.r, resp.err)
+	_, err := redis.Int64(u.do("RPUSH", user+accountSuffix, encodeOrder(stock, cost, shares, time.Now())))
 	if err != nil && err.Error() != ErrNil.Error() {
 		return err
 	}
@@ -169,15 +172,8 @@ func (u RedisDatabase) popOrder(transType string, user string) (stock string, co
 	} else {
 		return stock, cost, shares, errors.New("Bad transaction type of " + transType)
 	}
-	query := new(Query)
-	query.Command = "RPOP"
-	query.UserString = user + accountSuffix
-
-	u.DbRequests <- query
-
-	resp := <-u.BatchResults
 
-	recv, err := redis.String(resp.r, resp.err)
+	recv, err := redis.String(u.do("RPOP", user+accountSuffix))
 	if err != nil && err.Error() == ErrNil.Error() {
 		err = nil
 	}
@@ -187,25 +183,33 @@ func (u RedisDatabase) popOrder(transType string, user string) (stock string, co
 
 // Encodes a buy or sell order into a string, to be pushed onto the pending orders stack
 // Returns a string following the format of:
-//		"stock:cost:shares"
-func encodeOrder(stock string, cost decimal.Decimal, shares int64) string {
-	return stock + ":" + cost.String() + ":" + strconv.FormatInt(shares, 10)
+//		"stock:cost:shares:placed"
+// where placed is the time of the order in unix ms
+func encodeOrder(stock string, cost decimal.Decimal, shares int64, placed time.Time) string {
+	return stock + ":" + cost.String() + ":" + strconv.FormatInt(shares, 10) + ":" +
+		strconv.FormatInt(placed.UnixNano()/int64(time.Millisecond), 10)
 }
 
 // Performs the opposite of encodeOrder
 func decodeOrder(order string) (stock string, cost decimal.Decimal, shares int64) {
+	o := parseOrder(order)
+	return o.Stock, o.Cost, o.Shares
+}
+
+// parseOrder reads an order made by encodeOrder, or by its older
+// "stock:cost:shares" form that has no time
+func parseOrder(order string) Order {
 	split := strings.Split(order, ":")
-	if len(split) == 3 {
-		stock = split[0]
-		cost, _ = decimal.NewFromString(split[1])
-		shares, _ = strconv.ParseInt(split[2], 10, 64)
-	} else {
-		stock = ""
-		cost, _ = decimal.NewFromString("0")
-		shares = 0
+	if len(split) != 3 && len(split) != 4 {
+		return Order{Cost: decimal.Zero}
 	}
-
-	return stock, cost, shares
+	o := Order{Stock: split[0]}
+	o.Cost, _ = decimal.NewFromString(split[1])
+	o.Shares, _ = strconv.ParseInt(split[2], 10, 64)
+	if len(split) == 4 {
+		o.Placed, _ = strconv.ParseInt(split[3], 10, 64)
+	}
+	return o
 }
 
 // AddFunds adds amount dollars to the user account
@@ -260,17 +264,12 @@ func (u RedisDatabase) fundAction(action string, user string,
 		return decimal.Decimal{}, errors.New("Bad action attempt on funds")
 	}
 
-	query := new(Query)
-	query.Command = command
-	query.UserString = user + accountSuffix
+	args := []interface{}{user + accountSuffix}
 	if action != "Get" {
-		query.Params = append(query.Params, u.dollarToCents(amount))
+		args = append(args, u.dollarToCents(amount))
 	}
 
-	u.DbRequests <- query
-	resp := <-u.BatchResults
-
-	r, err := redis.Int64(resp.r, resp.err)
+	r, err := redis.Int64(u.do(command, args...))
 	if err != nil && err.Error() == ErrNil.Error() {
 		err = nil
 	}
@@ -328,29 +327,19 @@ func (u RedisDatabase) stockAction(action string, user string,
 		return 0, errors.New("Bad action attempt on stocks")
 	}
 
-	query := new(Query)
-	query.Command = command
-	query.UserString = user + accountSuffix
-	query.Params = append(query.Params, stock)
+	args := []interface{}{user + accountSuffix, stock}
 	if action != "Get" {
-		query.Params = append(query.Params, amount)
+		args = append(args, amount)
 	}
 
-	u.DbRequests <- query
-
-	var r int64
-	var err error
-	resp := <-u.BatchResults
-
+	r, err := redis.Int64(u.do(command, args...))
+	if err != nil && err.Error() == ErrNil.Error() {
+		err = nil
+	}
 	if action == "Get" {
-		r, err = redis.Int64(resp.r, resp.err)
-		if err != nil && err.Error() == ErrNil.Error() {
-			err = nil
-		}
 		return r, nil
 	}
-
-	return 0, nil
+	return 0, err
 }
 
 // DeleteKey deletes a key in the database
@@ -361,55 +350,19 @@ func (u RedisDatabase) DeleteKey(key string) {
 	conn.Close()
 }
 
-func (u RedisDatabase) DbRequestWorker() {
-	reqQue := []*Query{}
-	for {
-		// Block until request received
-		select {
-		case request := <-u.DbRequests:
-			reqQue = append(reqQue, request)
-			if len(reqQue) >= u.BatchSize {
-				u.MakeDbRequests(reqQue)
-				reqQue = nil
-				// Reset poll rate back to default
-				u.PollRate = 20
-			}
-		case <-time.After(u.PollRate * time.Millisecond):
-			// Incremental speed up of slow requests.
-			if u.PollRate > 0 {
-				u.PollRate = u.PollRate / 2
-			}
-
-			u.MakeDbRequests(reqQue)
-			reqQue = nil
-		}
+// do runs a command through the batcher, or on a connection of its own
+// when there is no batcher
+func (u RedisDatabase) do(command string, args ...interface{}) (interface{}, error) {
+	if u.Batcher == nil {
+		return batch.Direct(u.DbPool, command, args...)
 	}
+	return u.Batcher.Do(command, args...)
 }
 
-func (u RedisDatabase) MakeDbRequests(requestQue []*Query) {
-	// Batch size has been reached or poll time has passed,
-	conn := u.DbPool.Get()
-	defer conn.Close()
-	for _, query := range requestQue {
-		if len(query.Params) == 0 {
-			conn.Send(query.Command, query.UserString)
-		} else if len(query.Params) == 1 {
-			conn.Send(query.Command, query.UserString, query.Params[0])
-		} else if len(query.Params) == 2 {
-			conn.Send(query.Command, query.UserString, query.Params[0], query.Params[1])
-		} else {
-			// Should never happen...
-			panic("More params then 3!")
-		}
-	}
-	conn.Flush()
-
-	for i := 0; i < len(requestQue); i++ {
-		r, err := conn.Receive()
-		// Recieve results from queries
-		resp := Response{r, err}
-		// Notify waiting processes of batch execution
-		u.BatchResults <- resp
+// FlushRequests waits until every query queued for a batch has been run
+func (u RedisDatabase) FlushRequests() {
+	if u.Batcher != nil {
+		u.Batcher.Flush()
 	}
 }
 
@@ -7,8 +7,17 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// testDatabase connects to a local redis, skipping the test if there isn't one
+func testDatabase(t *testing.T) RedisDatabase {
+	db := RedisDatabase{Addr: "tcp", Port: ":6379", DbPool: NewPool("tcp", ":6379", "")}
+	if err := db.Ping(); err != nil {
+		t.Skip("no redis on :6379:", err)
+	}
+	return db
+}
+
 func TestAddUser(t *testing.T) {
-	db := RedisDatabase{"tcp", ":6379"}
+	db := testDatabase(t)
 	_, err := db.GetUserInfo("AAA")
 	if err != nil {
 		t.Error(err)
@@ -16,7 +25,7 @@ func TestAddUser(t *testing.T) {
 }
 
 func TestAddFunds(t *testing.T) {
-	db := RedisDatabase{"tcp", ":6379"}
+	db := testDatabase(t)
 	dollar, err := decimal.NewFromString("23.01")
 	err2 := db.AddFunds("AAA", dollar)
 	if err != nil || err2 != nil {
@@ -26,7 +35,7 @@ func TestAddFunds(t *testing.T) {
 }
 
 func TestGetUserInfo(t *testing.T) {
-	db := RedisDatabase{"tcp", ":6379"}
+	db := testDatabase(t)
 	dollar, _ := decimal.NewFromString("23.01")
 	db.AddFunds("AAA", dollar)
 	r, error := db.GetUserInfo("AAA")
@@ -39,7 +48,7 @@ func TestGetUserInfo(t *testing.T) {
 }
 
 func TestRemoveFunds(t *testing.T) {
-	db := RedisDatabase{"tcp", ":6379"}
+	db := testDatabase(t)
 	dollar, err := decimal.NewFromString("23.01")
 	err2 := db.AddFunds("F", dollar)
 	if err != nil || err2 != nil {
@@ -55,7 +64,7 @@ func TestRemoveFunds(t *testing.T) {
 }
 
 func TestGetFunds(t *testing.T) {
-	db := RedisDatabase{"tcp", ":6379"}
+	db := testDatabase(t)
 	dollar, err := decimal.NewFromString("23.01")
 
 	err2 := db.AddFunds("fundGetter", dollar)
@@ -72,11 +81,11 @@ func TestGetFunds(t *testing.T) {
 }
 
 func TestStocks(t *testing.T) {
-	db := RedisDatabase{"tcp", ":6379"}
-	db.AddStock("F", "stockname", decimal.NewFromFloat(22.00))
+	db := testDatabase(t)
+	db.AddStock("F", "stockname", 22)
 
 	amt, err := db.GetStock("F", "stockname")
-	if !amt.Equals(decimal.NewFromFloat(22.00)) {
+	if amt != 22 {
 		t.Error("Wrong value for stocks, should be 22, is ", amt)
 	}
 	if err != nil {
@@ -84,17 +93,17 @@ func TestStocks(t *testing.T) {
 	}
 
 	amt, err = db.GetStock("F", "wrongstockname")
-	if !amt.Equals(decimal.NewFromFloat(0.0)) {
+	if amt != 0 {
 		t.Error("Should get no value for stocks")
 	}
 
-	err = db.RemoveStock("F", "stockname", decimal.NewFromFloat(2.0))
+	err = db.RemoveStock("F", "stockname", 2)
 	if err != nil {
 		t.Error(err)
 	}
 
 	amt, err = db.GetStock("F", "stockname")
-	if !amt.Equals(decimal.NewFromFloat(20.0)) {
+	if amt != 20 {
 		t.Error("Failed to remove stock")
 	} else if err != nil {
 		t.Error(err)
@@ -104,12 +113,12 @@ func TestStocks(t *testing.T) {
 }
 
 func TestOrders(t *testing.T) {
-	db := RedisDatabase{"tcp", ":6379"}
-	err := db.PushSell("SELLER", "AAA", decimal.NewFromFloat(11.11), decimal.NewFromFloat(3))
+	db := testDatabase(t)
+	err := db.PushSell("SELLER", "AAA", decimal.NewFromFloat(11.11), 3)
 	if err != nil {
 		t.Error(err)
 	}
-	err = db.PushSell("SELLER", "BBB", decimal.NewFromFloat(11.11), decimal.NewFromFloat(3))
+	err = db.PushSell("SELLER", "BBB", decimal.NewFromFloat(11.11), 3)
 	if err != nil {
 		t.Error(err)
 	}
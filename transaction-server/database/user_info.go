@@ -1,99 +1,108 @@
 package database
 
 import (
+	"errors"
+	"time"
+
 	"github.com/garyburd/redigo/redis"
-	"fmt"
 	"github.com/shopspring/decimal"
 )
 
+// UserInfo is the state of a user's accounts, as reported by DISPLAY_SUMMARY
 type UserInfo struct {
-	user string
-	funds float64
-	reservedFunds float64
-	reservedStock map[string]string
-	stock map[string]string
-	sellOrders []string
-	buyOrders []string
+	User           string           `json:"user"`
+	Funds          decimal.Decimal  `json:"funds"`
+	ReservedFunds  decimal.Decimal  `json:"reservedFunds"`
+	Stocks         map[string]int64 `json:"stocks"`
+	ReservedStocks map[string]int64 `json:"reservedStocks"`
+	BuyOrders      []Order          `json:"buyOrders"`
+	SellOrders     []Order          `json:"sellOrders"`
 }
 
-func GetUserInfoFromReply(user string, reply interface{}) (UserInfo, error) {
-	var balance float64
-	var stock interface{}
-	var sellOrders []string
-	var buyOrders []string
-	var reservedFunds float64
-	var stockReserve interface{}
-	var stockMap map[string]string
-	var stockReserveMap map[string]string
+// Order is a BUY or SELL waiting to be committed or cancelled
+type Order struct {
+	Stock  string          `json:"stock"`
+	Cost   decimal.Decimal `json:"cost"`
+	Shares int64           `json:"shares"`
+	// Placed is when the order was made in unix ms, and AgeSeconds how long
+	// ago that was. Both are 0 for orders stored before they were recorded.
+	Placed     int64 `json:"placed"`
+	AgeSeconds int64 `json:"ageSeconds"`
+}
 
-	values, err := redis.Values(reply, nil); if err != nil {
+// GetUserInfoFromReply reads the reply to the transaction sent by GetUserInfo,
+// giving the age of orders as of now
+func GetUserInfoFromReply(user string, reply interface{}, now time.Time) (UserInfo, error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
 		return UserInfo{}, err
 	}
+	if len(values) != 6 {
+		return UserInfo{}, errors.New("unexpected number of replies to user info")
+	}
 
-	if _, err := redis.Scan(values, &balance, &stock, &sellOrders, &buyOrders, &reservedFunds, &stockReserve); err != nil {
+	info := UserInfo{User: user}
+	balance, err := int64OrZero(values[0])
+	if err != nil {
 		return UserInfo{}, err
 	}
-
-	stockReserveMap, err = redis.StringMap(stockReserve, err); if err != nil {
+	info.Funds = decimal.New(balance, -2)
+	if info.Stocks, err = holdings(values[1]); err != nil {
 		return UserInfo{}, err
 	}
-
-	stockReserveMap, err = redis.StringMap(stock, err); if err != nil {
+	if info.SellOrders, err = orders(values[2], now); err != nil {
 		return UserInfo{}, err
 	}
-
-	return UserInfo{
-		user: user,
-		funds: balance,
-		reservedFunds: reservedFunds,
-		reservedStock: stockReserveMap,
-		stock: stockMap,
-		sellOrders: sellOrders,
-		buyOrders: buyOrders,
-	}, nil
-}
-
-func (info UserInfo) getString() string {
-	str := fmt.Sprintf("User:\t\t\t%s;Funds:\t\t\t%.2f;", info.user, info.funds)
-	if len(info.stock) > 0 {
-		str += "Stock:;"
+	if info.BuyOrders, err = orders(values[3], now); err != nil {
+		return UserInfo{}, err
 	}
-	for stock, amount := range info.stock {
-		dec, _ := decimal.NewFromString(amount); if dec.GreaterThan(decimal.Zero) {
-			str += fmt.Sprintf("\t%s:\t%s\n", stock, amount)
-		}
+	reserved, err := int64OrZero(values[4])
+	if err != nil {
+		return UserInfo{}, err
 	}
-
-	if len(info.buyOrders) > 0 {
-		str += "Buy Orders:;"
+	info.ReservedFunds = decimal.New(reserved, -2)
+	if info.ReservedStocks, err = holdings(values[5]); err != nil {
+		return UserInfo{}, err
 	}
-	for _, buyOrder := range info.buyOrders {
-		stock, cost, _ := decodeOrder(buyOrder)
-		if cost.GreaterThan(decimal.Zero) {
-			str += fmt.Sprintf("\t%s:\t%s;", stock, cost.StringFixed(2))
-		}
+	return info, nil
+}
+
+// int64OrZero reads a counter, which is nil until it is first set
+func int64OrZero(v interface{}) (int64, error) {
+	n, err := redis.Int64(v, nil)
+	if err == redis.ErrNil {
+		return 0, nil
 	}
+	return n, err
+}
 
-	if len(info.sellOrders) > 0 {
-		str += "Sell Orders:;"
+// holdings reads a hash of shares per stock, leaving out emptied stocks
+func holdings(v interface{}) (map[string]int64, error) {
+	shares, err := redis.Int64Map(v, nil)
+	if err != nil {
+		return nil, err
 	}
-	for _, sellOrder := range info.sellOrders {
-		stock, cost, _ := decodeOrder(sellOrder)
-		if cost.GreaterThan(decimal.Zero) {
-			str += fmt.Sprintf("\t%s:\t%s;", stock, cost.StringFixed(2))
+	for stock, n := range shares {
+		if n == 0 {
+			delete(shares, stock)
 		}
 	}
+	return shares, nil
+}
 
-	str += fmt.Sprintf("Reserved Funds:\t%.2f;", info.reservedFunds)
-
-	if len(info.reservedStock) > 0 {
-		str += "Reserved stock:;"
+// orders reads a list of encoded orders, most recent first as they are popped
+func orders(v interface{}, now time.Time) ([]Order, error) {
+	encoded, err := redis.Strings(v, nil)
+	if err != nil {
+		return nil, err
 	}
-	for key, value := range info.reservedStock {
-		dec, _ := decimal.NewFromString(value); if dec.GreaterThan(decimal.Zero) {
-			str += fmt.Sprintf("\t%s:\t%s;", key, value)
+	list := make([]Order, 0, len(encoded))
+	for i := len(encoded) - 1; i >= 0; i-- {
+		o := parseOrder(encoded[i])
+		if o.Placed != 0 {
+			o.AgeSeconds = (now.UnixNano()/int64(time.Millisecond) - o.Placed) / 1000
 		}
+		list = append(list, o)
 	}
-	str += "\n"
-	return str
-}
\ No newline at end of file
+	return list, nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"seng468/transaction-server/config"
+	"seng468/transaction-server/health"
+)
+
+// readinessChecks checks redis and the servers commands are passed on to
+func readinessChecks(ts *TransactionServer, cfg *config.Config) *health.Checker {
+	checks := new(health.Checker)
+	checks.Add("redis", ts.UserDatabase.Ping)
+	checks.Add("audit server", health.Get(cfg.AuditURL()+"/healthz"))
+	checks.Add("quote server", health.Get(cfg.QuoteURL()+"/healthz"))
+	checks.Add("trigger server", health.Get(cfg.TriggerURL()+"/healthz"))
+	return checks
+}
@@ -0,0 +1,64 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	var c Checker
+	c.Add("redis", func() error { return nil })
+
+	rec := httptest.NewRecorder()
+	c.Ready(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "redis: ok\n" {
+		t.Errorf("got %d %q, want 200 with redis ok", rec.Code, rec.Body.String())
+	}
+
+	c.Add("audit", func() error { return errors.New("connection refused") })
+	rec = httptest.NewRecorder()
+	c.Ready(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503 with a failed check", rec.Code)
+	}
+	if want := "audit: connection refused\nredis: ok\n"; rec.Body.String() != want {
+		t.Errorf("got %q, want %q", rec.Body.String(), want)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	defer func(old time.Duration) { Timeout = old }(Timeout)
+	Timeout = 10 * time.Millisecond
+
+	var c Checker
+	c.Add("slow", func() error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	if err := c.Run()["slow"]; err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(Live))
+	defer up.Close()
+	if err := Get(up.URL + "/healthz")(); err != nil {
+		t.Errorf("healthy server failed: %v", err)
+	}
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	if err := Get(down.URL + "/healthz")(); err == nil {
+		t.Error("server replying 503 passed")
+	}
+	if err := Dial(strings.TrimPrefix(up.URL, "http://"))(); err != nil {
+		t.Errorf("dial failed: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"seng468/transaction-server/database"
+)
+
+// Page sizes of LEDGER
+const (
+	ledgerDefaultLimit = 50
+	ledgerMaxLimit     = 1000
+)
+
+// record appends e to user's ledger, first moving the shares it trades into
+// or out of the user's cost basis. The change it describes has already been
+// made, so failing to record it is reported without failing command.
+func (ts TransactionServer) record(transNum int, command string, user string, e database.LedgerEntry) {
+	action := "BUY"
+	if e.Shares < 0 {
+		action = "SELL"
+	}
+	now := time.Now()
+	e.TransNum = transNum
+	e.Timestamp = now.UnixNano() / int64(time.Millisecond)
+	switch {
+	case e.Stock == "" || e.Shares == 0:
+	case e.Shares > 0:
+		if err := ts.UserDatabase.AddCostBasis(user, e.Stock, e.Shares, e.CashDelta.Neg(), now); err != nil {
+			ts.reportOrderError(transNum, command, action, user, "Error adding to cost basis: "+err.Error(),
+				e.Stock, nil, e.CashDelta.String())
+		}
+	default:
+		basis, err := ts.UserDatabase.RemoveCostBasis(user, e.Stock, -e.Shares)
+		if err != nil {
+			ts.reportOrderError(transNum, command, action, user, "Error removing from cost basis: "+err.Error(),
+				e.Stock, nil, e.CashDelta.String())
+		} else {
+			e.Basis = &basis
+		}
+	}
+	if err := ts.UserDatabase.AppendLedger(user, e); err != nil {
+		ts.reportOrderError(transNum, command, action, user, "Error recording ledger entry: "+err.Error(),
+			e.Stock, nil, e.CashDelta.String())
+	}
+}
+
+// ledgerReply is the reply to LEDGER, sent as one line of JSON
+type ledgerReply struct {
+	database.LedgerPage
+	RealizedPnL database.PnL `json:"realizedPnL"`
+}
+
+// Ledger returns a page of the user's ledger along with the profit or loss
+// realized over all of it
+// Params: user, [offset], [limit], [order]
+// Entries are oldest first unless order is "newest".
+func (ts TransactionServer) Ledger(transNum int, params ...string) string {
+	user := params[0]
+	offset, limit := 0, ledgerDefaultLimit
+	newest := false
+	var err error
+	if len(params) > 1 {
+		if offset, err = strconv.Atoi(params[1]); err != nil || offset < 0 {
+			ts.reportError(transNum, "LEDGER", user, "Bad ledger offset: "+params[1], nil, nil, nil)
+			return "-1"
+		}
+	}
+	if len(params) > 2 {
+		if limit, err = strconv.Atoi(params[2]); err != nil || limit < 1 {
+			ts.reportError(transNum, "LEDGER", user, "Bad ledger limit: "+params[2], nil, nil, nil)
+			return "-1"
+		}
+		if limit > ledgerMaxLimit {
+			limit = ledgerMaxLimit
+		}
+	}
+	if len(params) > 3 {
+		switch params[3] {
+		case "newest":
+			newest = true
+		case "oldest":
+		default:
+			ts.reportError(transNum, "LEDGER", user, "Bad ledger order: "+params[3], nil, nil, nil)
+			return "-1"
+		}
+	}
+
+	page, err := ts.UserDatabase.GetLedger(user, offset, limit, newest)
+	if err != nil {
+		ts.reportError(transNum, "LEDGER", user, "Error getting ledger from database: "+err.Error(), nil, nil, nil)
+		return "-1"
+	}
+	entries, err := ts.UserDatabase.GetAllLedger(user)
+	if err != nil {
+		ts.reportError(transNum, "LEDGER", user, "Error getting ledger from database: "+err.Error(), nil, nil, nil)
+		return "-1"
+	}
+	reply, err := json.Marshal(ledgerReply{page, database.RealizedPnL(entries)})
+	if err != nil {
+		ts.reportError(transNum, "LEDGER", user, "Error encoding ledger: "+err.Error(), nil, nil, nil)
+		return "-1"
+	}
+	return string(reply)
+}
+
+// ledgerSettle is how long a user's accounts are given to settle before
+// they are checked again and reported
+const ledgerSettle = time.Second
+
+// lastDiscrepancies is the number found by the last check of every user
+var lastDiscrepancies int64
+
+// checkLedgers checks every account against its ledger each interval,
+// printing any that don't add up
+func checkLedgers(db database.RedisDatabase, interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := runLedgerCheck(db)
+		if err != nil {
+			fmt.Printf("error: checking ledgers: %v\n", err)
+			continue
+		}
+		for _, d := range report.Discrepancies {
+			fmt.Printf("Ledger discrepancy: %s %s is %s, ledger gives %s\n",
+				d.User, d.Account, d.Actual, d.Expected)
+		}
+	}
+}
+
+func runLedgerCheck(db database.RedisDatabase) (database.CheckReport, error) {
+	report, err := db.CheckAll(ledgerSettle)
+	if err != nil {
+		ledgerChecks.Inc("error")
+		return report, err
+	}
+	atomic.StoreInt64(&lastDiscrepancies, int64(len(report.Discrepancies)))
+	if len(report.Discrepancies) > 0 {
+		ledgerChecks.Inc("discrepancies")
+	} else {
+		ledgerChecks.Inc("ok")
+	}
+	return report, nil
+}
+
+// ledgerCheckHandler checks the accounts of ?username=, or of every user,
+// against their ledgers and replies with the report as JSON
+func ledgerCheckHandler(db database.RedisDatabase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report database.CheckReport
+		var err error
+		if user := r.FormValue("username"); user != "" {
+			report = database.CheckReport{Checked: time.Now(), Users: 1}
+			report.Discrepancies, err = db.CheckUser(user)
+			if report.Discrepancies == nil {
+				report.Discrepancies = []database.Discrepancy{}
+			}
+		} else {
+			report, err = runLedgerCheck(db)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,69 @@
+// Command ledgercheck checks every user's accounts in redis against their
+// ledger, printing the accounts that don't add up and exiting with status 1
+// if there are any. It reads dbaddr, dbport and redispassword the same way
+// the transaction server does, and is best run while no commands are being
+// handled, as otherwise accounts may be caught part way through a command.
+//
+//	./ledgercheck -dbaddr=localhost -dbport=6379 [-username=u] [-json]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"seng468/transaction-server/config"
+	"seng468/transaction-server/database"
+)
+
+type settings struct {
+	DbAddr        string        `key:"dbaddr" required:"true" help:"redis host"`
+	DbPort        string        `key:"dbport" required:"true" help:"redis port"`
+	RedisPassword string        `key:"redispassword" secret:"true" help:"redis password, if one is set"`
+	Username      string        `key:"username" help:"check only this user"`
+	Settle        time.Duration `key:"settle" default:"1" unit:"s" help:"wait before checking accounts that are off again"`
+	JSON          bool          `key:"json" default:"false" help:"print the report as JSON"`
+}
+
+func main() {
+	var cfg settings
+	if err := config.Load(&cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	db := database.RedisDatabase{
+		Addr:   "tcp",
+		Port:   cfg.DbAddr + ":" + cfg.DbPort,
+		DbPool: database.NewPool("tcp", cfg.DbAddr+":"+cfg.DbPort, cfg.RedisPassword),
+	}
+
+	var report database.CheckReport
+	var err error
+	if cfg.Username != "" {
+		report = database.CheckReport{Checked: time.Now(), Users: 1}
+		report.Discrepancies, err = db.CheckUser(cfg.Username)
+	} else {
+		report, err = db.CheckAll(cfg.Settle)
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if cfg.JSON {
+		if report.Discrepancies == nil {
+			report.Discrepancies = []database.Discrepancy{}
+		}
+		json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		for _, d := range report.Discrepancies {
+			fmt.Printf("%s %s is %s, ledger gives %s\n", d.User, d.Account, d.Actual, d.Expected)
+		}
+		fmt.Printf("Checked %d users, %d discrepancies\n", report.Users, len(report.Discrepancies))
+	}
+	if len(report.Discrepancies) > 0 {
+		os.Exit(1)
+	}
+}
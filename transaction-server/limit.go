@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"seng468/transaction-server/database"
+	"seng468/transaction-server/trigger"
+
+	"github.com/shopspring/decimal"
+)
+
+// parseLimit reads the shares and price of a limit order, which is kept to
+// the cent so reserving and filling it add up
+func parseLimit(shares string, price string) (int64, decimal.Decimal, error) {
+	n, err := strconv.ParseInt(shares, 10, 64)
+	if err != nil || n < 1 {
+		return 0, decimal.Zero, fmt.Errorf("%q is not a number of shares", shares)
+	}
+	p, err := decimal.NewFromString(price)
+	if err != nil || !p.Round(2).IsPositive() {
+		return 0, decimal.Zero, fmt.Errorf("%q is not a price", price)
+	}
+	return n, p.Round(2), nil
+}
+
+// LimitBuy reserves the funds to buy shares of stock at price and rests an
+// order in the trigger server's order book, which fills it whenever the
+// quoted price is at or below price
+// Params: user, stock, shares, price
+// Replies with the order as JSON
+func (ts TransactionServer) LimitBuy(transNum int, params ...string) string {
+	return ts.placeLimit(transNum, "LIMIT_BUY", "BUY", params...)
+}
+
+// LimitSell reserves shares of stock and rests an order to sell them in the
+// trigger server's order book, which fills it whenever the quoted price is
+// at or above price
+// Params: user, stock, shares, price
+// Replies with the order as JSON
+func (ts TransactionServer) LimitSell(transNum int, params ...string) string {
+	return ts.placeLimit(transNum, "LIMIT_SELL", "SELL", params...)
+}
+
+// placeLimit stores the order in the database, which reserves what it needs,
+// then rests it in the order book. The order is cancelled again if the
+// trigger server can't take it.
+func (ts TransactionServer) placeLimit(transNum int, command string, action string, params ...string) string {
+	user := params[0]
+	stock := params[1]
+	shares, price, err := parseLimit(params[2], params[3])
+	if err != nil {
+		ts.reportError(transNum, command, user, "Bad limit order: "+err.Error(), stock, nil, nil)
+		return "-1"
+	}
+	var funds interface{}
+	if action == "BUY" {
+		funds = database.Stored(price.Mul(decimal.New(shares, 0))).String()
+	}
+
+	order, err := ts.UserDatabase.PlaceLimitOrder(database.LimitOrder{
+		User:     user,
+		TransNum: transNum,
+		Stock:    stock,
+		Action:   action,
+		Shares:   shares,
+		Price:    price,
+		Placed:   time.Now().UnixNano() / int64(time.Millisecond),
+	})
+	if err != nil {
+		ts.reportError(transNum, command, user, "Could not place limit order: "+err.Error(), stock, nil, funds)
+		return "-1"
+	}
+
+	err = ts.TriggerClient.PlaceLimitOrder(transNum, user, triggerclient.LimitOrder{
+		ID:     order.ID,
+		Stock:  order.Stock,
+		Action: order.Action,
+		Shares: order.Shares,
+		Price:  order.Price,
+		Placed: order.Placed,
+	})
+	if err != nil {
+		ts.reportError(transNum, command, user, "Error resting limit order: "+err.Error(), stock, nil, funds)
+		if _, err := ts.UserDatabase.CancelLimitOrder(user, order.ID); err != nil {
+			ts.reportError(transNum, command, user, "Error releasing limit order: "+err.Error(), stock, nil, funds)
+		}
+		return "-1"
+	}
+	return limitReply(order)
+}
+
+// CancelLimit releases the funds or shares reserved for the part of a limit
+// order not yet filled, then takes it out of the order book
+// Params: user, id
+// Replies with the cancelled order as JSON
+func (ts TransactionServer) CancelLimit(transNum int, params ...string) string {
+	user := params[0]
+	id, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil {
+		ts.reportError(transNum, "CANCEL_LIMIT", user, "Bad limit order id: "+params[1], nil, nil, nil)
+		return "-1"
+	}
+
+	order, err := ts.UserDatabase.CancelLimitOrder(user, id)
+	if err != nil {
+		ts.reportError(transNum, "CANCEL_LIMIT", user, "Error cancelling limit order: "+err.Error(), nil, nil, nil)
+		return "-1"
+	}
+	// The order book drops fills of orders the database has closed, so an
+	// order left in it only costs a stale fill
+	if err := ts.TriggerClient.CancelLimitOrder(transNum, user, id); err != nil {
+		ts.reportOrderError(transNum, "CANCEL_LIMIT", order.Action, user,
+			"Error taking limit order out of the order book: "+err.Error(), order.Stock, nil, nil)
+	}
+	return limitReply(order)
+}
+
+// LimitFill settles part of a limit order filled by the trigger server,
+// moving the reserved funds or shares of the filled shares into the user's
+// accounts at the price it was filled at
+// Params: LIMIT_FILL,<user>,<id>,<stock>,<action>,<shares>,<price>
+// Replies 1 once settled, or 0,<id>,<filled> if the order doesn't have
+// that many shares left, with -1 for a closed order
+func (ts TransactionServer) LimitFill(transNum int, params ...string) string {
+	user := params[0]
+	stock := params[2]
+	action := params[3]
+	id, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil {
+		ts.reportOrderError(transNum, "LIMIT_FILL", action, user, "Bad limit order id: "+params[1], stock, nil, nil)
+		return "-1"
+	}
+	shares, price, err := parseLimit(params[4], params[5])
+	if err != nil {
+		ts.reportOrderError(transNum, "LIMIT_FILL", action, user, "Bad limit order fill: "+err.Error(), stock, nil, nil)
+		return "-1"
+	}
+
+	err = ts.UserDatabase.FillLimitOrder(user, id, stock, action, shares, price)
+	if stale, ok := err.(*database.StaleOrderError); ok {
+		return staleReply(stale)
+	}
+	if err != nil {
+		ts.reportOrderError(transNum, "LIMIT_FILL", action, user, "Error settling limit order: "+err.Error(),
+			stock, nil, nil)
+		return "-1"
+	}
+
+	cost := database.Stored(price.Mul(decimal.New(shares, 0)))
+	entry := database.LedgerEntry{
+		Type:      database.LedgerLimitBuy,
+		Stock:     stock,
+		Shares:    shares,
+		Price:     price,
+		CashDelta: cost.Neg(),
+	}
+	if action != "BUY" {
+		entry.Type = database.LedgerLimitSell
+		entry.Shares = -shares
+		entry.CashDelta = cost
+	}
+	ts.record(transNum, "LIMIT_FILL", user, entry)
+	return "1"
+}
+
+// LimitMatch settles a trade the trigger server's matching engine made
+// between a limit buy and another user's limit sell, moving the buyer's
+// reserved funds and the seller's reserved shares in one transaction
+// Params: LIMIT_MATCH,<buyer>,<buy id>,<seller>,<sell id>,<stock>,<shares>,<price>
+// Replies like LimitFill, naming whichever order is stale
+func (ts TransactionServer) LimitMatch(transNum int, params ...string) string {
+	buyer := params[0]
+	seller := params[2]
+	stock := params[4]
+	buyID, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil {
+		ts.reportOrderError(transNum, "LIMIT_MATCH", "BUY", buyer, "Bad limit order id: "+params[1], stock, nil, nil)
+		return "-1"
+	}
+	sellID, err := strconv.ParseInt(params[3], 10, 64)
+	if err != nil {
+		ts.reportOrderError(transNum, "LIMIT_MATCH", "SELL", seller, "Bad limit order id: "+params[3], stock, nil, nil)
+		return "-1"
+	}
+	shares, price, err := parseLimit(params[5], params[6])
+	if err != nil {
+		ts.reportOrderError(transNum, "LIMIT_MATCH", "BUY", buyer, "Bad limit order match: "+err.Error(),
+			stock, nil, nil)
+		return "-1"
+	}
+
+	cost := database.Stored(price.Mul(decimal.New(shares, 0)))
+	err = ts.UserDatabase.MatchLimitOrders(buyer, buyID, seller, sellID, stock, shares, price)
+	if stale, ok := err.(*database.StaleOrderError); ok {
+		return staleReply(stale)
+	}
+	if err != nil {
+		ts.reportOrderError(transNum, "LIMIT_MATCH", "BUY", buyer,
+			"Error settling matched limit orders: with "+seller+": "+err.Error(), stock, nil, cost.String())
+		return "-1"
+	}
+	ts.Logger.AccountTransaction(ts.Name, transNum, "remove", buyer, cost)
+	ts.Logger.AccountTransaction(ts.Name, transNum, "add", seller, cost)
+
+	ts.record(transNum, "LIMIT_MATCH", buyer, database.LedgerEntry{
+		Type:         database.LedgerMatchBuy,
+		Stock:        stock,
+		Shares:       shares,
+		Price:        price,
+		CashDelta:    cost.Neg(),
+		Counterparty: seller,
+	})
+	ts.record(transNum, "LIMIT_MATCH", seller, database.LedgerEntry{
+		Type:         database.LedgerMatchSell,
+		Stock:        stock,
+		Shares:       -shares,
+		Price:        price,
+		CashDelta:    cost,
+		Counterparty: buyer,
+	})
+	return "1"
+}
+
+// OpenLimitOrders replies with every open limit order as JSON, for the
+// trigger server to rebuild its order book from when it starts
+func (ts TransactionServer) OpenLimitOrders(transNum int, params ...string) string {
+	orders, err := ts.UserDatabase.OpenLimitOrders()
+	if err != nil {
+		// Not a user command, so there is nothing to log it under
+		fmt.Printf("error: getting open limit orders: %v\n", err)
+		return "-1"
+	}
+	reply, err := json.Marshal(orders)
+	if err != nil {
+		fmt.Printf("error: encoding limit orders: %v\n", err)
+		return "-1"
+	}
+	return string(reply)
+}
+
+// staleReply tells the trigger server how much of an order is really filled
+func staleReply(stale *database.StaleOrderError) string {
+	return fmt.Sprintf("0,%d,%d", stale.ID, stale.Filled)
+}
+
+func limitReply(order database.LimitOrder) string {
+	reply, err := json.Marshal(order)
+	if err != nil {
+		fmt.Printf("error: encoding limit order: %v\n", err)
+		return "-1"
+	}
+	return string(reply)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		shares, price string
+		n             int64
+		rounded       string // empty when it is rejected
+	}{
+		{"10", "12.34", 10, "12.34"},
+		{"1", "0.005", 1, "0.01"},
+		{"3", "12.345", 3, "12.35"},
+		{"0", "10", 0, ""},
+		{"-1", "10", 0, ""},
+		{"1.5", "10", 0, ""},
+		{"ten", "10", 0, ""},
+		{"1", "0", 0, ""},
+		{"1", "0.004", 0, ""},
+		{"1", "-10", 0, ""},
+		{"1", "ten", 0, ""},
+	}
+	for _, test := range tests {
+		n, price, err := parseLimit(test.shares, test.price)
+		if test.rounded == "" {
+			if err == nil {
+				t.Errorf("parseLimit(%q, %q) = %d, %s, want an error", test.shares, test.price, n, price)
+			}
+			continue
+		}
+		if err != nil || n != test.n || !price.Equal(dollars(test.rounded)) {
+			t.Errorf("parseLimit(%q, %q) = %d, %s, %v, want %d, %s", test.shares, test.price, n, price, err,
+				test.n, test.rounded)
+		}
+	}
+}
+
+func TestSchemaCommand(t *testing.T) {
+	tests := []struct {
+		command, action, want string
+	}{
+		{"BUY", "", "BUY"},
+		{"LEDGER", "", "DISPLAY_SUMMARY"},
+		{"PORTFOLIO", "", "DISPLAY_SUMMARY"},
+		{"LIMIT_BUY", "", "BUY"},
+		{"LIMIT_SELL", "", "SELL"},
+		{"LIMIT_FILL", "BUY", "BUY"},
+		{"LIMIT_FILL", "SELL", "SELL"},
+		{"LIMIT_MATCH", "", "BUY"},
+		{"LIMIT_MATCH", "SELL", "SELL"},
+		{"CANCEL_LIMIT", "", "CANCEL_BUY"},
+		{"CANCEL_LIMIT", "SELL", "CANCEL_SELL"},
+	}
+	for _, test := range tests {
+		if got := schemaCommand(test.command, test.action); got != test.want {
+			t.Errorf("schemaCommand(%q, %q) = %q, want %q", test.command, test.action, got, test.want)
+		}
+	}
+}
@@ -28,10 +28,14 @@ type Logger interface {
 		filename interface{}, funds interface{})
 
 	DumpLog(filename string, username interface{})
+	History(user string, n int) ([]map[string]string, error)
+
+	StartSpan(server string, transNum int, name string) *Span
 }
 
 type AuditLogger struct {
-	Addr string
+	Addr  string
+	Queue *Queue // queues events instead of sending them one by one, if set
 }
 
 func (al AuditLogger) DumpLog(filename string, username interface{}) {
@@ -41,6 +45,10 @@ func (al AuditLogger) DumpLog(filename string, username interface{}) {
 	if username != nil {
 		params["username"] = username.(string)
 	}
+	if al.Queue != nil {
+		// Make sure the dump includes every event logged before it
+		al.Queue.Flush()
+	}
 	al.SendLog("/dumpLog", params)
 }
 
@@ -78,7 +86,15 @@ func (al AuditLogger) SystemError(server string, transNum int, command string, u
 		params["username"] = user.(string)
 	}
 	if stock != nil {
-		params["stockSymbol"] = stock.(string)
+		// Symbols in the log schema are at most 3 characters, so a longer
+		// one is reported in the message instead
+		if symbol := stock.(string); len(symbol) <= 3 {
+			params["stockSymbol"] = symbol
+		} else if errorMsg != nil {
+			errorMsg = errorMsg.(string) + " (stock " + symbol + ")"
+		} else {
+			errorMsg = "stock " + symbol
+		}
 	}
 	if filename != nil {
 		params["filename"] = filename.(string)
@@ -122,6 +138,10 @@ func (al AuditLogger) QuoteServer(server string, transactionNum int,
 }
 
 func (al AuditLogger) SendLog(slash string, params map[string]string) {
+	if al.Queue != nil && slash != "/dumpLog" {
+		al.Queue.Add(slash, params)
+		return
+	}
 	req, err := http.NewRequest("GET", al.Addr+slash, nil)
 	req.Header.Set("Connection", "close")
 	if err != nil {
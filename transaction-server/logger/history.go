@@ -0,0 +1,42 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// historyClient waits at most a few seconds for the audit server, so a slow
+// log doesn't hold up the commands that show history
+var historyClient = &http.Client{Timeout: 5 * time.Second}
+
+// History returns the n most recent commands of user recorded by the audit
+// server, newest first, each as its fields keyed by name like
+//		{"command": "BUY", "stockSymbol": "ABC", "funds": "100.00", "timestamp": "1521131234567", ...}
+// Commands still waiting in the queue aren't included.
+func (al AuditLogger) History(user string, n int) ([]map[string]string, error) {
+	query := url.Values{
+		"username": {user},
+		"type":     {"userCommand"},
+		"order":    {"newest"},
+		"limit":    {strconv.Itoa(n)},
+	}
+	resp, err := historyClient.Get(al.Addr + "/query?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("audit server replied %s", resp.Status)
+	}
+	var page struct {
+		Events []map[string]string `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return page.Events, nil
+}
@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// auditStub records the events posted to /batch, failing while down is set
+type auditStub struct {
+	lock   sync.Mutex
+	down   bool
+	events []string
+}
+
+func (a *auditStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		a.events = append(a.events, scanner.Text())
+	}
+}
+
+func (a *auditStub) setDown(down bool) {
+	a.lock.Lock()
+	a.down = down
+	a.lock.Unlock()
+}
+
+func (a *auditStub) received() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.events)
+}
+
+func testQueue(t *testing.T, stub *auditStub, maxQueue int) *Queue {
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return NewQueue(server.URL, QueueOptions{
+		BatchSize: 2,
+		Interval:  time.Hour, // only flush when asked to
+		MaxQueue:  maxQueue,
+		Policy:    SpillWhenFull,
+		SpillPath: filepath.Join(t.TempDir(), "spill.jsonl"),
+	})
+}
+
+func TestQueueSpillsAndReplays(t *testing.T) {
+	stub := &auditStub{down: true}
+	q := testQueue(t, stub, 10)
+
+	for i := 0; i < 5; i++ {
+		q.Add("/systemEvent", map[string]string{"server": "test"})
+	}
+	q.Flush()
+	if got := stub.received(); got != 0 {
+		t.Fatalf("audit server is down but received %d events", got)
+	}
+	if q.Len() != 0 {
+		t.Fatalf("%d events left in memory, want them spilled", q.Len())
+	}
+
+	stub.setDown(false)
+	q.Add("/systemEvent", map[string]string{"server": "test"})
+	q.Flush()
+	if got := stub.received(); got != 6 {
+		t.Fatalf("received %d events after reconnect, want 6", got)
+	}
+	if q.Lost() != 0 {
+		t.Errorf("lost %d events", q.Lost())
+	}
+}
+
+func TestQueueSpillsWhenFull(t *testing.T) {
+	stub := &auditStub{}
+	q := testQueue(t, stub, 2)
+
+	// The first two fill the queue and the rest go to the spill file
+	for i := 0; i < 4; i++ {
+		q.Add("/systemEvent", map[string]string{"server": "test"})
+	}
+	if q.Len() > 2 {
+		t.Fatalf("queue holds %d events, want at most 2", q.Len())
+	}
+	q.Flush()
+	if got := stub.received(); got != 4 {
+		t.Fatalf("received %d events, want 4", got)
+	}
+}
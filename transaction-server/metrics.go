@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"seng468/transaction-server/database"
+	"seng468/transaction-server/health"
+	"seng468/transaction-server/logger"
+	"seng468/transaction-server/metrics"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	commandCount = metrics.NewCounter("transaction_commands_total",
+		"Commands handled, by command and result.", "command", "result")
+	commandLatency = metrics.NewHistogram("transaction_command_duration_seconds",
+		"Time taken to handle each command.", metrics.DefaultBuckets, "command")
+	commandErrors = metrics.NewCounter("transaction_errors_total",
+		"Errors reported to the audit server, by command and reason.", "command", "reason")
+	quoteLatency = metrics.NewHistogram("transaction_quote_duration_seconds",
+		"Time taken to get a quote from the quote server.", metrics.DefaultBuckets)
+	ledgerChecks = metrics.NewCounter("transaction_ledger_checks_total",
+		"Checks of every account against its ledger, by result.", "result")
+)
+
+// errorReason shortens an error message to the fixed part before any
+// details, to keep the number of reasons small
+func errorReason(msg string) string {
+	if i := strings.Index(msg, ":"); i >= 0 {
+		msg = msg[:i]
+	}
+	msg = strings.TrimSpace(msg)
+	if len(msg) > 64 {
+		msg = msg[:64]
+	}
+	return msg
+}
+
+// serveMetrics registers the queue gauges and serves /metrics, /healthz,
+// /readyz and /ledgercheck on metricsport, as the transaction server itself
+// only speaks over sockets
+func serveMetrics(port string, db database.RedisDatabase, audit logger.AuditLogger, checks *health.Checker) {
+	if db.Batcher != nil {
+		metrics.NewGaugeFunc("transaction_db_requests_queued",
+			"Database queries waiting to be batched.", func() float64 { return float64(db.Batcher.Len()) })
+		metrics.NewGaugeFunc("transaction_db_batch_size",
+			"Current limit on database queries sent at once.", func() float64 { return float64(db.Batcher.BatchSize()) })
+		metrics.NewGaugeFunc("transaction_db_batch_wait_seconds",
+			"Current wait for a batch of database queries to fill.", func() float64 { return db.Batcher.Wait().Seconds() })
+	}
+	if audit.Queue != nil {
+		metrics.NewGaugeFunc("transaction_audit_events_queued",
+			"Audit events waiting to be sent.", func() float64 { return float64(audit.Queue.Len()) })
+		metrics.NewGaugeFunc("transaction_audit_events_lost",
+			"Audit events rejected or that could not be spilled.", func() float64 { return float64(audit.Queue.Lost()) })
+	}
+	metrics.NewGaugeFunc("transaction_ledger_discrepancies",
+		"Accounts that didn't add up to their ledger at the last check.",
+		func() float64 { return float64(atomic.LoadInt64(&lastDiscrepancies)) })
+
+	if port == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/ledgercheck", ledgerCheckHandler(db))
+	checks.Register(mux)
+	fmt.Printf("Serving metrics on :%s\n", port)
+	if err := http.ListenAndServe(":"+port, mux); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	requests := NewCounter("test_requests_total", "Requests.", "command", "code")
+	latency := NewHistogram("test_request_duration_seconds", "Latency.", []float64{0.1, 1}, "command")
+	NewGaugeFunc("test_queue_length", "Queue.", func() float64 { return 3 })
+
+	handler := Instrument("BUY", requests, latency, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/BUY/", nil))
+	latency.Observe(0.5, "SELL")
+	latency.Observe(5, "SELL")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`test_requests_total{command="BUY",code="400"} 1`,
+		`test_request_duration_seconds_bucket{command="SELL",le="0.1"} 0`,
+		`test_request_duration_seconds_bucket{command="SELL",le="1"} 1`,
+		`test_request_duration_seconds_bucket{command="SELL",le="+Inf"} 2`,
+		`test_request_duration_seconds_count{command="SELL"} 2`,
+		"test_queue_length 3",
+		"go_goroutines ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"seng468/transaction-server/database"
+
+	"github.com/shopspring/decimal"
+)
+
+// holding is one stock of a portfolio. Price, MarketValue and UnrealizedPnL
+// are left out if the stock couldn't be quoted.
+type holding struct {
+	Stock string `json:"stock"`
+	// Shares counts those reserved for sell triggers and pending sells too
+	Shares        int64            `json:"shares"`
+	CostBasis     decimal.Decimal  `json:"costBasis"`
+	AverageCost   decimal.Decimal  `json:"averageCost"`
+	Price         *decimal.Decimal `json:"price,omitempty"`
+	MarketValue   *decimal.Decimal `json:"marketValue,omitempty"`
+	UnrealizedPnL *decimal.Decimal `json:"unrealizedPnL,omitempty"`
+}
+
+// portfolio is the reply to PORTFOLIO, sent as one line of JSON.
+// Totals only include the holdings that could be quoted.
+type portfolio struct {
+	User string `json:"user"`
+	// Cash counts reserved funds and pending buys too
+	Cash          decimal.Decimal `json:"cash"`
+	MarketValue   decimal.Decimal `json:"marketValue"`
+	Equity        decimal.Decimal `json:"equity"`
+	CostBasis     decimal.Decimal `json:"costBasis"`
+	UnrealizedPnL decimal.Decimal `json:"unrealizedPnL"`
+	Holdings      []holding       `json:"holdings"`
+	// Errors lists the stocks that couldn't be quoted
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Portfolio values every stock the user holds at its current price, quoting
+// them all at once, along with what they cost and the user's total equity
+// Params: user
+func (ts TransactionServer) Portfolio(transNum int, params ...string) string {
+	user := params[0]
+	info, err := ts.UserDatabase.GetUserInfo(user)
+	if err != nil {
+		ts.reportError(transNum, "PORTFOLIO", user, "Error getting user info from database: "+err.Error(), nil, nil, nil)
+		return "-1"
+	}
+	basis, err := ts.UserDatabase.GetCostBasis(user)
+	if err != nil {
+		ts.reportError(transNum, "PORTFOLIO", user, "Error getting cost basis from database: "+err.Error(), nil, nil, nil)
+		return "-1"
+	}
+
+	shares, stocks := heldShares(info)
+
+	prices := make([]decimal.Decimal, len(stocks))
+	errs := make([]error, len(stocks))
+	var wg sync.WaitGroup
+	for i, stock := range stocks {
+		wg.Add(1)
+		go func(i int, stock string) {
+			defer wg.Done()
+			span := ts.Logger.StartSpan(ts.Name, transNum, "call quoteserver")
+			start := time.Now()
+			prices[i], errs[i] = ts.QuoteClient.Query(user, stock, transNum)
+			quoteLatency.Since(start)
+			span.End()
+		}(i, stock)
+	}
+	wg.Wait()
+	for i, stock := range stocks {
+		if errs[i] != nil {
+			fmt.Printf("error: quoting %s for %s: %v\n", stock, user, errs[i])
+		}
+	}
+
+	reply, err := json.Marshal(valuePortfolio(info, basis, shares, stocks, prices, errs))
+	if err != nil {
+		ts.reportError(transNum, "PORTFOLIO", user, "Error encoding portfolio: "+err.Error(), nil, nil, nil)
+		return "-1"
+	}
+	return string(reply)
+}
+
+// heldShares counts the shares of each stock the user holds, sorting the
+// stocks they hold any of
+func heldShares(info database.UserInfo) (map[string]int64, []string) {
+	shares := make(map[string]int64)
+	for stock, n := range info.Stocks {
+		shares[stock] += n
+	}
+	for stock, n := range info.ReservedStocks {
+		shares[stock] += n
+	}
+	for _, o := range info.SellOrders {
+		shares[o.Stock] += o.Shares
+	}
+	var stocks []string
+	for stock, n := range shares {
+		if n > 0 {
+			stocks = append(stocks, stock)
+		}
+	}
+	sort.Strings(stocks)
+	return shares, stocks
+}
+
+// valuePortfolio values the shares of each of stocks at its price, unless
+// it couldn't be quoted
+func valuePortfolio(info database.UserInfo, basis map[string]database.CostBasis, shares map[string]int64,
+	stocks []string, prices []decimal.Decimal, errs []error) portfolio {
+	p := portfolio{User: info.User, Cash: info.Funds.Add(info.ReservedFunds), Holdings: []holding{}}
+	for _, o := range info.BuyOrders {
+		p.Cash = p.Cash.Add(o.Cost)
+	}
+	for i, stock := range stocks {
+		h := holding{Stock: stock, Shares: shares[stock]}
+		// Shares held from before cost basis was kept have no known cost
+		if b, ok := basis[stock]; ok {
+			h.CostBasis = b.Cost
+			if b.Shares > h.Shares {
+				h.CostBasis = b.Cost.Mul(decimal.New(h.Shares, 0)).Div(decimal.New(b.Shares, 0)).Round(2)
+			}
+		}
+		h.AverageCost = h.CostBasis.Div(decimal.New(h.Shares, 0)).Round(2)
+		if errs[i] != nil {
+			p.Errors = append(p.Errors, "quote for "+stock+" unavailable")
+			p.Holdings = append(p.Holdings, h)
+			continue
+		}
+		price := prices[i]
+		value := price.Mul(decimal.New(h.Shares, 0)).Round(2)
+		pnl := value.Sub(h.CostBasis)
+		h.Price, h.MarketValue, h.UnrealizedPnL = &price, &value, &pnl
+		p.MarketValue = p.MarketValue.Add(value)
+		p.CostBasis = p.CostBasis.Add(h.CostBasis)
+		p.Holdings = append(p.Holdings, h)
+	}
+	p.Equity = p.Cash.Add(p.MarketValue)
+	p.UnrealizedPnL = p.MarketValue.Sub(p.CostBasis)
+	return p
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"seng468/transaction-server/database"
+
+	"github.com/shopspring/decimal"
+)
+
+func dollars(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func TestValuePortfolio(t *testing.T) {
+	info := database.UserInfo{
+		User:           "bob",
+		Funds:          dollars("100"),
+		ReservedFunds:  dollars("10"),
+		Stocks:         map[string]int64{"ABC": 3, "XYZ": 2, "QQQ": 1, "DEF": 0},
+		ReservedStocks: map[string]int64{"ABC": 1},
+		BuyOrders:      []database.Order{{Stock: "DEF", Cost: dollars("5"), Shares: 1}},
+		SellOrders:     []database.Order{{Stock: "ABC", Cost: dollars("12"), Shares: 1}},
+	}
+	basis := map[string]database.CostBasis{
+		"ABC": {Shares: 5, Cost: dollars("50")},
+		// More shares than held, so only half of the cost is theirs
+		"XYZ": {Shares: 4, Cost: dollars("40")},
+	}
+
+	shares, stocks := heldShares(info)
+	if want := []string{"ABC", "QQQ", "XYZ"}; !reflect.DeepEqual(stocks, want) {
+		t.Fatalf("heldShares() stocks = %v, want %v", stocks, want)
+	}
+	prices := []decimal.Decimal{dollars("12"), dollars("7.50"), {}}
+	errs := []error{nil, nil, errors.New("timed out")}
+	p := valuePortfolio(info, basis, shares, stocks, prices, errs)
+
+	tests := []struct {
+		stock                          string
+		shares                         int64
+		costBasis, averageCost         string
+		price, marketValue, unrealized string // empty when not quoted
+	}{
+		{"ABC", 5, "50", "10", "12", "60", "10"},
+		{"QQQ", 1, "0", "0", "7.50", "7.50", "7.50"},
+		{"XYZ", 2, "20", "10", "", "", ""},
+	}
+	if len(p.Holdings) != len(tests) {
+		t.Fatalf("holdings = %+v, want %d", p.Holdings, len(tests))
+	}
+	for i, test := range tests {
+		h := p.Holdings[i]
+		if h.Stock != test.stock || h.Shares != test.shares || !h.CostBasis.Equal(dollars(test.costBasis)) ||
+			!h.AverageCost.Equal(dollars(test.averageCost)) {
+			t.Errorf("holding %d = %+v, want %+v", i, h, test)
+		}
+		if test.price == "" {
+			if h.Price != nil || h.MarketValue != nil || h.UnrealizedPnL != nil {
+				t.Errorf("%s wasn't quoted but has a price", h.Stock)
+			}
+			continue
+		}
+		if h.Price == nil || !h.Price.Equal(dollars(test.price)) || !h.MarketValue.Equal(dollars(test.marketValue)) ||
+			!h.UnrealizedPnL.Equal(dollars(test.unrealized)) {
+			t.Errorf("%s valued wrong: %+v", h.Stock, h)
+		}
+	}
+
+	totals := []struct {
+		name      string
+		got, want decimal.Decimal
+	}{
+		{"cash", p.Cash, dollars("115")},
+		{"market value", p.MarketValue, dollars("67.50")},
+		{"cost basis", p.CostBasis, dollars("50")},
+		{"unrealized", p.UnrealizedPnL, dollars("17.50")},
+		{"equity", p.Equity, dollars("182.50")},
+	}
+	for _, total := range totals {
+		if !total.got.Equal(total.want) {
+			t.Errorf("%s = %s, want %s", total.name, total.got, total.want)
+		}
+	}
+	if want := []string{"quote for XYZ unavailable"}; !reflect.DeepEqual(p.Errors, want) {
+		t.Errorf("errors = %v, want %v", p.Errors, want)
+	}
+}
@@ -5,15 +5,19 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 
 	"github.com/shopspring/decimal"
 )
 
-func Query(user string, stock string, transNum int) (decimal.Decimal, error) {
+// QuoteClient gets quotes from the quote server at QuoteURL
+type QuoteClient struct {
+	QuoteURL string
+}
+
+func (qc QuoteClient) Query(user string, stock string, transNum int) (decimal.Decimal, error) {
 	http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost = 100
-	req, err := http.NewRequest("GET", "http://"+os.Getenv("quoteaddr")+":"+os.Getenv("quoteport")+"/quote", nil)
+	req, err := http.NewRequest("GET", qc.QuoteURL+"/quote", nil)
 	if err != nil {
 		log.Print(err)
 		panic(err)
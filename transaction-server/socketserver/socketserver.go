@@ -9,6 +9,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type SocketServer struct {
@@ -16,6 +18,17 @@ type SocketServer struct {
 	funcMap  map[string]func(transNum int, args ...string) string
 	paramMap map[string]int
 	transNum int64
+	conns    *connTracker
+}
+
+// connTracker follows the open connections and the requests in flight,
+// so that the server can be drained
+type connTracker struct {
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	requests sync.WaitGroup
 }
 
 func NewSocketServer(addr string) SocketServer {
@@ -24,6 +37,7 @@ func NewSocketServer(addr string) SocketServer {
 		funcMap:  make(map[string]func(transNum int, args ...string) string),
 		paramMap: make(map[string]int),
 		transNum: 0,
+		conns:    &connTracker{conns: make(map[net.Conn]struct{})},
 	}
 }
 
@@ -44,17 +58,107 @@ func (s SocketServer) Run() {
 		os.Exit(1)
 	}
 	defer l.Close()
+	if !s.conns.listening(l) {
+		return
+	}
 	fmt.Println("Listening on " + s.addr)
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if s.conns.isClosing() {
+				return
+			}
 			fmt.Println("Error accepting: ", err.Error())
 			continue
 		}
+		if !s.conns.add(conn) {
+			conn.Close()
+			return
+		}
 		go s.handleRequest(conn)
 	}
 }
 
+// Shutdown stops accepting connections and new requests, then waits up to
+// timeout for the requests in flight to finish before closing every
+// connection. Requests that arrive while draining are answered with -1.
+func (s SocketServer) Shutdown(timeout time.Duration) error {
+	t := s.conns
+	t.lock.Lock()
+	t.closing = true
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	// Wake up connections waiting for their next request
+	for conn := range t.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	t.lock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		t.requests.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		err = fmt.Errorf("requests still running after %v", timeout)
+	}
+
+	t.lock.Lock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.lock.Unlock()
+	return err
+}
+
+func (t *connTracker) listening(l net.Listener) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.listener = l
+	return !t.closing
+}
+
+func (t *connTracker) isClosing() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.closing
+}
+
+func (t *connTracker) add(conn net.Conn) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closing {
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *connTracker) remove(conn net.Conn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.conns, conn)
+}
+
+// begin marks the start of a request, or returns false once draining
+func (t *connTracker) begin() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closing {
+		return false
+	}
+	t.requests.Add(1)
+	return true
+}
+
+func (t *connTracker) end() {
+	t.requests.Done()
+}
+
 func (s SocketServer) getRoute(command string) (func(transNum int, args ...string) string, []string) {
 	command = string(bytes.Trim([]byte(command), "\x00"))
 	result := strings.Split(strings.TrimSpace(command), ",")
@@ -64,7 +168,7 @@ func (s SocketServer) getRoute(command string) (func(transNum int, args ...strin
 		return nil, nil
 	}
 	switch result[0] {
-	case "COMMIT_BUY", "CANCEL_BUY", "COMMIT_SELL", "CANCEL_SELL", "DISPLAY_SUMMARY":
+	case "COMMIT_BUY", "CANCEL_BUY", "COMMIT_SELL", "CANCEL_SELL", "DISPLAY_SUMMARY", "PORTFOLIO":
 		if len(params) != 1 {
 			return nil, nil
 		}
@@ -80,32 +184,77 @@ func (s SocketServer) getRoute(command string) (func(transNum int, args ...strin
 		}
 		break
 	case "DUMPLOG":
-		if len(params) != 1 || len(params) != 2 {
+		if len(params) != 2 {
 			return nil, nil
 		}
 	case "TRIGGER_SUCCESS":
 		if len(params) != 5 {
 			return nil, nil
 		}
+	case "CANCEL_LIMIT":
+		if len(params) != 2 {
+			return nil, nil
+		}
+	case "LIMIT_BUY", "LIMIT_SELL":
+		if len(params) != 4 {
+			return nil, nil
+		}
+	case "LIMIT_FILL":
+		if len(params) != 6 {
+			return nil, nil
+		}
+	case "LIMIT_MATCH":
+		if len(params) != 7 {
+			return nil, nil
+		}
+	case "LIMIT_ORDERS":
+		if len(params) != 0 {
+			return nil, nil
+		}
+	case "LEDGER":
+		if len(params) < 1 || len(params) > 4 {
+			return nil, nil
+		}
 	default:
 		return nil, nil
 	}
 	return function, params
 }
 
+// isPing reports whether recv is a PING health check, sent either on its own
+// or after a transaction number like any other command
+func isPing(recv string) bool {
+	command := strings.TrimSpace(recv)
+	if i := strings.Index(command, ";"); i >= 0 {
+		command = command[i+1:]
+	}
+	return command == "PING"
+}
+
 // Handles incoming requests.
 func (s SocketServer) handleRequest(conn net.Conn) {
+	defer s.conns.remove(conn)
+	defer conn.Close()
 	reader := bufio.NewReader(conn)
 	for {
 		recv, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Println("ERROR1: ", err)
+			if !s.conns.isClosing() {
+				fmt.Println("ERROR1: ", err)
+			}
 			return
 		}
 		if recv == "\n" { //weird...
 			fmt.Println("Received only a newline")
 			continue
 		}
+		if isPing(recv) {
+			if _, err := conn.Write([]byte("PONG\n")); err != nil {
+				fmt.Println("ERROR writing back PONG ", err)
+				return
+			}
+			continue
+		}
 		fmt.Println("recvd: ", recv)
 
 		sepTransCommand := strings.Split(recv, ";")
@@ -124,6 +273,10 @@ func (s SocketServer) handleRequest(conn net.Conn) {
 			return
 		}
 
+		if !s.conns.begin() {
+			conn.Write([]byte("-1\n"))
+			return
+		}
 		res := function(transNum, params...)
 		res += "\n"
 		fmt.Println(res)
@@ -135,5 +288,6 @@ func (s SocketServer) handleRequest(conn net.Conn) {
 		} else {
 			fmt.Println("Wrote back ", n, " bytes")
 		}
+		s.conns.end()
 	}
 }
@@ -0,0 +1,103 @@
+package socketserver
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// startServer runs s on a free port and returns its address
+func startServer(t *testing.T, s SocketServer) string {
+	go s.Run()
+	for i := 0; i < 100; i++ {
+		s.conns.lock.Lock()
+		l := s.conns.listener
+		s.conns.lock.Unlock()
+		if l != nil {
+			return l.Addr().String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+	return ""
+}
+
+func TestPing(t *testing.T) {
+	s := NewSocketServer("127.0.0.1:0")
+	conn, err := net.Dial("tcp", startServer(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, ping := range []string{"PING\n", "7;PING\n"} {
+		conn.Write([]byte(ping))
+		if reply, err := reader.ReadString('\n'); err != nil || reply != "PONG\n" {
+			t.Errorf("%q got %q, %v", ping, reply, err)
+		}
+	}
+	s.Shutdown(time.Second)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	s := NewSocketServer("127.0.0.1:0")
+	started := make(chan struct{})
+	s.Route("BUY", func(transNum int, args ...string) string {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return "1"
+	})
+	addr := startServer(t, s)
+
+	busy, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	busy.Write([]byte("1;BUY,user,ABC,10\n"))
+	<-started
+	if err := s.Shutdown(time.Second); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	// The request in flight finishes and gets its reply
+	reply, err := bufio.NewReader(busy).ReadString('\n')
+	if err != nil || reply != "1\n" {
+		t.Errorf("in flight request got %q, %v", reply, err)
+	}
+	// The idle connection is closed rather than left waiting
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := bufio.NewReader(idle).ReadString('\n'); err == nil {
+		t.Error("idle connection still open after shutdown")
+	}
+	if _, err := net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := NewSocketServer("127.0.0.1:0")
+	started := make(chan struct{})
+	s.Route("BUY", func(transNum int, args ...string) string {
+		close(started)
+		time.Sleep(time.Second)
+		return "1"
+	})
+	conn, err := net.Dial("tcp", startServer(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("1;BUY,user,ABC,10\n"))
+	<-started
+	if err := s.Shutdown(10 * time.Millisecond); err == nil {
+		t.Error("shutdown with a request still running returned no error")
+	}
+}
@@ -1,5 +1,7 @@
 package tests
 
+import "seng468/transaction-server/logger"
+
 type MockLogger struct {
 }
 
@@ -24,3 +26,11 @@ func (MockLogger) SystemEvent(server string, transNum int, command string, usern
 func (MockLogger) DumpLog(filename string, username interface{}) {
 
 }
+
+func (MockLogger) History(user string, n int) ([]map[string]string, error) {
+	return nil, nil
+}
+
+func (MockLogger) StartSpan(server string, transNum int, name string) *logger.Span {
+	return nil
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"seng468/transaction-server/config"
 	"seng468/transaction-server/database"
+	"seng468/transaction-server/database/batch"
 	"seng468/transaction-server/logger"
 	"seng468/transaction-server/quote"
 	"seng468/transaction-server/socketserver"
 	"seng468/transaction-server/trigger"
 	"strconv"
+	"sync"
+	"time"
 
 	"errors"
 
@@ -24,27 +32,36 @@ type TransactionServer struct {
 	Logger        logger.Logger
 	UserDatabase  database.RedisDatabase
 	TriggerClient triggerclient.TriggerClient
+	QuoteClient   quoteclient.QuoteClient
 }
 
 func main() {
-	serverAddr := ":" + os.Getenv("transport")
+	var cfg config.Config
+	if err := config.Load(&cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	config.Print(os.Stdout, &cfg)
+
+	serverAddr := ":" + cfg.TransPort
 	databaseAddr := "tcp"
-	databasePort := os.Getenv("dbaddr") + ":" + os.Getenv("dbport")
-	auditAddr := "http://" + os.Getenv("auditaddr") + ":" + os.Getenv("auditport")
-	triggerURL := "http://" + os.Getenv("triggeraddr") + ":" + os.Getenv("triggerport")
+	databasePort := cfg.DbAddr + ":" + cfg.DbPort
 
 	server := socketserver.NewSocketServer(serverAddr)
 	database := database.RedisDatabase{
-		Addr:         databaseAddr,
-		Port:         databasePort,
-		DbRequests:   make(chan *database.Query, 1000),
-		BatchSize:    100,
-		PollRate:     20,
-		BatchResults: make(chan database.Response, 1000),
-		DbPool:       database.NewPool(databaseAddr, databasePort),
+		Addr:   databaseAddr,
+		Port:   databasePort,
+		DbPool: database.NewPool(databaseAddr, databasePort, cfg.RedisPassword),
+	}
+	database.FIFOLots = cfg.BasisMethod == "fifo"
+	if cfg.DbBatchSize > 1 {
+		database.Batcher = batch.New(database.DbPool, cfg.BatchOptions())
 	}
-	logger := logger.AuditLogger{Addr: auditAddr}
-	triggerclient := triggerclient.TriggerClient{TriggerURL: triggerURL}
+	logger := logger.AuditLogger{
+		Addr:  cfg.AuditURL(),
+		Queue: logger.NewQueue(cfg.AuditURL(), cfg.QueueOptions()),
+	}
+	triggerclient := triggerclient.TriggerClient{TriggerURL: cfg.TriggerURL()}
 
 	ts := &TransactionServer{
 		Name:          "transactionserve",
@@ -53,27 +70,75 @@ func main() {
 		Logger:        logger,
 		UserDatabase:  database,
 		TriggerClient: triggerclient,
+		QuoteClient:   quoteclient.QuoteClient{QuoteURL: cfg.QuoteURL()},
+	}
+
+	server.Route("ADD", ts.traced("ADD", ts.Add))
+	server.Route("QUOTE", ts.traced("QUOTE", ts.Quote))
+	server.Route("BUY", ts.traced("BUY", ts.Buy))
+	server.Route("COMMIT_BUY", ts.traced("COMMIT_BUY", ts.CommitBuy))
+	server.Route("CANCEL_BUY", ts.traced("CANCEL_BUY", ts.CancelBuy))
+	server.Route("SELL", ts.traced("SELL", ts.Sell))
+	server.Route("COMMIT_SELL", ts.traced("COMMIT_SELL", ts.CommitSell))
+	server.Route("CANCEL_SELL", ts.traced("CANCEL_SELL", ts.CancelSell))
+	server.Route("SET_BUY_AMOUNT", ts.traced("SET_BUY_AMOUNT", ts.SetBuyAmount))
+	server.Route("CANCEL_SET_BUY", ts.traced("CANCEL_SET_BUY", ts.CancelSetBuy))
+	server.Route("SET_BUY_TRIGGER", ts.traced("SET_BUY_TRIGGER", ts.SetBuyTrigger))
+	server.Route("SET_SELL_AMOUNT", ts.traced("SET_SELL_AMOUNT", ts.SetSellAmount))
+	server.Route("SET_SELL_TRIGGER", ts.traced("SET_SELL_TRIGGER", ts.SetSellTrigger))
+	server.Route("TRIGGER_SUCCESS", ts.traced("TRIGGER_SUCCESS", ts.TriggerSuccess))
+	server.Route("CANCEL_SET_SELL", ts.traced("CANCEL_SET_SELL", ts.CancelSetSell))
+	server.Route("DUMPLOG", ts.traced("DUMPLOG", ts.DumpLogUser))
+	server.Route("DISPLAY_SUMMARY", ts.traced("DISPLAY_SUMMARY", ts.DisplaySummary))
+	server.Route("LEDGER", ts.traced("LEDGER", ts.Ledger))
+	server.Route("PORTFOLIO", ts.traced("PORTFOLIO", ts.Portfolio))
+	server.Route("LIMIT_BUY", ts.traced("LIMIT_BUY", ts.LimitBuy))
+	server.Route("LIMIT_SELL", ts.traced("LIMIT_SELL", ts.LimitSell))
+	server.Route("CANCEL_LIMIT", ts.traced("CANCEL_LIMIT", ts.CancelLimit))
+	server.Route("LIMIT_FILL", ts.traced("LIMIT_FILL", ts.LimitFill))
+	server.Route("LIMIT_MATCH", ts.traced("LIMIT_MATCH", ts.LimitMatch))
+	// Asked for by the trigger server rather than a user, so not traced
+	server.Route("LIMIT_ORDERS", ts.OpenLimitOrders)
+	go serveMetrics(cfg.MetricsPort, database, logger, readinessChecks(ts, &cfg))
+	if cfg.LedgerCheck > 0 {
+		go checkLedgers(database, cfg.LedgerCheck)
+	}
+	go server.Run()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	fmt.Printf("Received %v, draining requests\n", <-stop)
+	ts.shutdown(logger, cfg.DrainTimeout)
+}
+
+// shutdown drains the requests in flight, then runs the database queries
+// and sends the audit events they left queued
+func (ts *TransactionServer) shutdown(audit logger.AuditLogger, drainTimeout time.Duration) {
+	if err := ts.Server.Shutdown(drainTimeout); err != nil {
+		fmt.Printf("error: %v\n", err)
 	}
+	ts.UserDatabase.FlushRequests()
+	if audit.Queue != nil {
+		audit.Queue.Flush()
+	}
+	fmt.Println("Transaction server stopped")
+}
 
-	server.Route("ADD", ts.Add)
-	server.Route("QUOTE", ts.Quote)
-	server.Route("BUY", ts.Buy)
-	server.Route("COMMIT_BUY", ts.CommitBuy)
-	server.Route("CANCEL_BUY", ts.CancelBuy)
-	server.Route("SELL", ts.Sell)
-	server.Route("COMMIT_SELL", ts.CommitBuy)
-	server.Route("CANCEL_SELL", ts.CancelBuy)
-	server.Route("SET_BUY_AMOUNT", ts.SetBuyAmount)
-	server.Route("CANCEL_SET_BUY", ts.CancelSetBuy)
-	server.Route("SET_BUY_TRIGGER", ts.SetBuyTrigger)
-	server.Route("SET_SELL_AMOUNT", ts.SetSellAmount)
-	server.Route("SET_SELL_TRIGGER", ts.SetSellTrigger)
-	server.Route("TRIGGER_SUCCESS", ts.TriggerSuccess)
-	server.Route("CANCEL_SET_SELL", ts.CancelSetSell)
-	server.Route("DUMPLOG", ts.DumpLogUser)
-	server.Route("DISPLAY_SUMMARY", ts.DisplaySummary)
-	go ts.UserDatabase.DbRequestWorker()
-	server.Run()
+// traced wraps the handler of command so that each call is recorded as a
+// span and counted in the command metrics
+func (ts TransactionServer) traced(command string, f func(transNum int, params ...string) string) func(transNum int, params ...string) string {
+	return func(transNum int, params ...string) string {
+		defer ts.Logger.StartSpan(ts.Name, transNum, "handle "+command).End()
+		start := time.Now()
+		res := f(transNum, params...)
+		commandLatency.Since(start, command)
+		if res == "-1" {
+			commandCount.Inc(command, "error")
+		} else {
+			commandCount.Inc(command, "ok")
+		}
+		return res
+	}
 }
 
 // Add the given amount of money to the user's account
@@ -94,7 +159,8 @@ func (ts TransactionServer) Add(transNum int, params ...string) string {
 			nil, nil, amount.String())
 		return "-1"
 	}
-	go ts.Logger.AccountTransaction(ts.Name, transNum, "ADD", user, amount)
+	ts.Logger.AccountTransaction(ts.Name, transNum, "ADD", user, amount)
+	ts.record(transNum, "ADD", user, database.LedgerEntry{Type: database.LedgerAdd, CashDelta: database.Stored(amount)})
 	return "1"
 }
 
@@ -104,7 +170,11 @@ func (ts TransactionServer) Add(transNum int, params ...string) string {
 func (ts TransactionServer) Quote(transNum int, params ...string) string {
 	user := params[0]
 	stock := params[1]
-	dec, err := quoteclient.Query(user, stock, transNum)
+	span := ts.Logger.StartSpan(ts.Name, transNum, "call quoteserver")
+	start := time.Now()
+	dec, err := ts.QuoteClient.Query(user, stock, transNum)
+	quoteLatency.Since(start)
+	span.End()
 	if err != nil {
 		ts.reportError(transNum, "QUOTE", user, err.Error(),
 			stock, nil, nil)
@@ -158,7 +228,7 @@ func (ts TransactionServer) Buy(transNum int, params ...string) string {
 		return "-1"
 	}
 
-	go ts.Logger.AccountTransaction(ts.Name, transNum, "remove", user, amount)
+	ts.Logger.AccountTransaction(ts.Name, transNum, "remove", user, amount)
 	return "1"
 }
 
@@ -170,20 +240,32 @@ func (ts TransactionServer) Buy(transNum int, params ...string) string {
 // 		(b) the user's account for the given stock is increased by the purchase amount
 func (ts TransactionServer) CommitBuy(transNum int, params ...string) string {
 	user := params[0]
-	go ts.Logger.SystemEvent(ts.Name, transNum, "COMMIT_BUY", user, nil, nil, nil)
-	stock, _, shares, err := ts.UserDatabase.PopBuy(user)
+	ts.Logger.SystemEvent(ts.Name, transNum, "COMMIT_BUY", user, nil, nil, nil)
+	stock, cost, shares, err := ts.UserDatabase.PopBuy(user)
 	if err != nil {
 		ts.reportError(transNum, "COMMIT_BUY", user, "Error popping command in commit buy: "+err.Error(),
 			stock, nil, nil)
 		return "-1"
 	}
 
+	if stock == "" {
+		ts.reportError(transNum, "COMMIT_BUY", user, "No pending buy orders to pop", nil, nil, nil)
+		return "-1"
+	}
+
 	err = ts.UserDatabase.AddStock(user, stock, shares)
 	if err != nil {
 		ts.reportError(transNum, "COMMIT_BUY", user, "Error connecting to database to add stock: "+err.Error(),
-			stock, nil, string(shares))
+			stock, nil, strconv.FormatInt(shares, 10))
 		return "-1"
 	}
+	ts.record(transNum, "COMMIT_BUY", user, database.LedgerEntry{
+		Type:      database.LedgerBuy,
+		Stock:     stock,
+		Shares:    shares,
+		Price:     sharePrice(cost, shares),
+		CashDelta: cost.Neg(),
+	})
 	return "1"
 }
 
@@ -245,7 +327,7 @@ func (ts TransactionServer) Sell(transNum int, params ...string) string {
 	err = ts.UserDatabase.RemoveStock(user, stock, shares)
 	if err != nil {
 		ts.reportError(transNum, "SELL", user, "Error removing stock from database: "+err.Error(), stock, nil,
-			string(shares))
+			strconv.FormatInt(shares, 10))
 		return "-1"
 	}
 
@@ -255,7 +337,7 @@ func (ts TransactionServer) Sell(transNum int, params ...string) string {
 			stock, nil, amount.String())
 		return "-1"
 	}
-	return "-1"
+	return "1"
 }
 
 // CommitSell commits the most recently executed SELL command
@@ -266,23 +348,34 @@ func (ts TransactionServer) Sell(transNum int, params ...string) string {
 // 		(b) the user's cash account is increased by the sell amount
 func (ts TransactionServer) CommitSell(transNum int, params ...string) string {
 	user := params[0]
-	go ts.Logger.SystemEvent(ts.Name, transNum, "COMMIT_SELL", user, nil, nil, nil)
+	ts.Logger.SystemEvent(ts.Name, transNum, "COMMIT_SELL", user, nil, nil, nil)
 
-	stock, cost, _, err := ts.UserDatabase.PopSell(user)
+	stock, cost, shares, err := ts.UserDatabase.PopSell(user)
 	if err != nil {
 		ts.reportError(transNum, "COMMIT_SELL", user, "Error connecting to database to pop command: "+err.Error(),
 			stock, nil, nil)
 		return "-1"
 	}
 
+	if stock == "" {
+		ts.reportError(transNum, "COMMIT_SELL", user, "No pending sell orders to pop", nil, nil, nil)
+		return "-1"
+	}
+
 	err = ts.UserDatabase.AddFunds(user, cost)
 	if err != nil {
 		ts.reportError(transNum, "COMMIT_SELL", user, "Error connecting to database to add funds: "+err.Error(),
 			stock, nil, nil)
 		return "-1"
 	}
+	ts.record(transNum, "COMMIT_SELL", user, database.LedgerEntry{
+		Type:      database.LedgerSell,
+		Stock:     stock,
+		Shares:    -shares,
+		Price:     sharePrice(cost, shares),
+		CashDelta: cost,
+	})
 	return "1"
-
 }
 
 // CancelSell cancels the most recently executed SELL Command
@@ -413,7 +506,7 @@ func (ts TransactionServer) SetBuyTrigger(transNum int, params ...string) string
 	stock := params[1]
 	triggerAmount, err := decimal.NewFromString(params[2])
 	if err != nil {
-		go ts.Logger.SystemError(ts.Name, transNum, "SET_BUY_TRIGGER", user, stock, nil, nil,
+		ts.Logger.SystemError(ts.Name, transNum, "SET_BUY_TRIGGER", user, stock, nil, nil,
 			"Could not parse set buy trigger amount to decimal")
 		return "-1"
 	}
@@ -447,20 +540,20 @@ func (ts TransactionServer) SetSellAmount(transNum int, params ...string) string
 	curr, err := ts.UserDatabase.GetStock(user, stock)
 	if err != nil {
 		ts.reportError(transNum, "SET_SELL_AMOUNT", user, "Could not get stock from database: "+err.Error(),
-			stock, nil, string(amount))
+			stock, nil, strconv.FormatInt(amount, 10))
 		return "-1"
 	}
 
 	if amount > curr {
 		ts.reportError(transNum, "SET_SELL_AMOUNT", user, "Cannot set sell trigger for more stock than you own",
-			stock, nil, string(amount))
+			stock, nil, strconv.FormatInt(amount, 10))
 		return "-1"
 	}
 
 	err = ts.TriggerClient.SetNewSellTrigger(transNum, user, stock, amount)
 	if err != nil {
 		ts.reportError(transNum, "SET_SELL_AMOUNT", user, "Failed to make new sell trigger: "+err.Error(),
-			stock, nil, string(amount))
+			stock, nil, strconv.FormatInt(amount, 10))
 		return "-1"
 	}
 	return "1"
@@ -509,7 +602,7 @@ func (ts TransactionServer) SetSellTrigger(transNum int, params ...string) strin
 		return "-1"
 	}
 
-	go ts.Logger.SystemEvent(ts.Name, transNum, "SET_SELL_TRIGGER", user, stock, nil, price)
+	ts.Logger.SystemEvent(ts.Name, transNum, "SET_SELL_TRIGGER", user, stock, nil, price)
 	return "1"
 
 }
@@ -578,81 +671,131 @@ func (ts TransactionServer) TriggerSuccess(transNum int, params ...string) strin
 	}
 	priceDec, err := decimal.NewFromString(price)
 	if action == "BUY" {
-		err = ts.buyExecute(user, stock, amountDec, priceDec)
+		entry, err := ts.buyExecute(user, stock, amountDec, priceDec)
 		if err != nil {
 			return "-1"
 		}
+		ts.record(transNum, "SET_BUY_TRIGGER", user, entry)
 		return "1"
 	} else if action == "SELL" {
-		err = ts.sellExecute(user, stock, amountDec, priceDec)
+		entry, err := ts.sellExecute(user, stock, amountDec, priceDec)
 		if err != nil {
 			return "-1"
 		}
+		ts.record(transNum, "SET_SELL_TRIGGER", user, entry)
 		return "1"
 	}
 	return "-1"
 }
 
 func (ts TransactionServer) reportError(transNum int, command string, user string, errorMsg string, stock interface{}, filename interface{}, funds interface{}) {
-	go ts.Logger.SystemError(ts.Name, transNum, command, user, stock, filename, funds,
+	ts.reportOrderError(transNum, command, "", user, errorMsg, stock, filename, funds)
+}
+
+// reportOrderError reports an error of command on a BUY or SELL action,
+// which picks the command it is logged under for limit orders
+func (ts TransactionServer) reportOrderError(transNum int, command string, action string, user string, errorMsg string, stock interface{}, filename interface{}, funds interface{}) {
+	ts.Logger.SystemError(ts.Name, transNum, schemaCommand(command, action), user, stock, filename, funds,
 		errorMsg)
+	commandErrors.Inc(command, errorReason(errorMsg))
 	fmt.Println(errorMsg)
 }
 
-func (ts TransactionServer) sellExecute(user string, stock string, amount decimal.Decimal, price decimal.Decimal) error {
+// schemaCommand is the command of the audit log schema that errors of
+// command on action are logged under. Metrics keep the real command.
+func schemaCommand(command string, action string) string {
+	switch command {
+	case "LEDGER", "PORTFOLIO":
+		return "DISPLAY_SUMMARY"
+	case "LIMIT_BUY":
+		return "BUY"
+	case "LIMIT_SELL":
+		return "SELL"
+	case "LIMIT_FILL", "LIMIT_MATCH":
+		if action == "SELL" {
+			return "SELL"
+		}
+		return "BUY"
+	case "CANCEL_LIMIT":
+		if action == "SELL" {
+			return "CANCEL_SELL"
+		}
+		return "CANCEL_BUY"
+	}
+	return command
+}
+
+// sellExecute sells the shares reserved by a sell trigger at price
+func (ts TransactionServer) sellExecute(user string, stock string, amount decimal.Decimal, price decimal.Decimal) (database.LedgerEntry, error) {
 	amountShares := amount.IntPart()
 
 	reserved, err := ts.UserDatabase.GetReserveStock(user, stock)
 	if err != nil {
-		return fmt.Errorf("error getting reserved stock from database:  %s", err.Error())
+		return database.LedgerEntry{}, fmt.Errorf("error getting reserved stock from database:  %s", err.Error())
 	}
 
 	if reserved < amountShares {
-		return errors.New("reserved stock is less than trigger amount")
+		return database.LedgerEntry{}, errors.New("reserved stock is less than trigger amount")
 	}
 
 	err = ts.UserDatabase.RemoveReserveStock(user, stock, amountShares)
 	if err != nil {
-		return fmt.Errorf("error removing reserved stock from database:  %s", err.Error())
+		return database.LedgerEntry{}, fmt.Errorf("error removing reserved stock from database:  %s", err.Error())
 	}
 
-	err = ts.UserDatabase.AddFunds(user, amount.Mul(price))
+	proceeds := amount.Mul(price)
+	err = ts.UserDatabase.AddFunds(user, proceeds)
 	if err != nil {
-		return fmt.Errorf("error adding difference between stock cost and reserved:  %s", err.Error())
+		return database.LedgerEntry{}, fmt.Errorf("error adding difference between stock cost and reserved:  %s", err.Error())
 	}
-	return nil
+	return database.LedgerEntry{
+		Type:      database.LedgerTriggerSell,
+		Stock:     stock,
+		Shares:    -amountShares,
+		Price:     price,
+		CashDelta: database.Stored(proceeds),
+	}, nil
 }
 
-func (ts TransactionServer) buyExecute(user string, stock string, amount decimal.Decimal, price decimal.Decimal) error {
+// buyExecute spends the funds reserved by a buy trigger at price
+func (ts TransactionServer) buyExecute(user string, stock string, amount decimal.Decimal, price decimal.Decimal) (database.LedgerEntry, error) {
 	cost, shares, _ := ts.getMaxPurchase(user, stock, amount, price, nil)
 
 	reserved, err := ts.UserDatabase.GetReserveFunds(user)
 	if err != nil {
-		return fmt.Errorf("error getting reserved funds from database:  %s", err.Error())
+		return database.LedgerEntry{}, fmt.Errorf("error getting reserved funds from database:  %s", err.Error())
 	}
 
 	if reserved.LessThan(amount) {
-		return errors.New("should not have less than the trigger amount in your reserve account")
+		return database.LedgerEntry{}, errors.New("should not have less than the trigger amount in your reserve account")
 	}
 
 	err = ts.UserDatabase.RemoveReserveFunds(user, amount)
 	if err != nil {
-		return fmt.Errorf("error removing reserved funds: %s", err.Error())
+		return database.LedgerEntry{}, fmt.Errorf("error removing reserved funds: %s", err.Error())
 	}
 
+	spent := database.Stored(amount)
 	// Price was lower than the buy trigger
 	if amount.GreaterThan(cost) {
+		spent = spent.Sub(database.Stored(amount.Sub(cost)))
 		err = ts.UserDatabase.AddFunds(user, amount.Sub(cost))
 		if err != nil {
-			return fmt.Errorf("error adding difference between stock cost and reserve amount: %s", err.Error())
+			return database.LedgerEntry{}, fmt.Errorf("error adding difference between stock cost and reserve amount: %s", err.Error())
 		}
 	}
 
 	err = ts.UserDatabase.AddStock(user, stock, shares)
 	if err != nil {
-		return fmt.Errorf("error adding stock to database: %s", err.Error())
+		return database.LedgerEntry{}, fmt.Errorf("error adding stock to database: %s", err.Error())
 	}
-	return nil
+	return database.LedgerEntry{
+		Type:      database.LedgerTriggerBuy,
+		Stock:     stock,
+		Shares:    shares,
+		Price:     price,
+		CashDelta: spent.Neg(),
+	}, nil
 }
 
 // DumpLogUser Print out the history of the users transactions
@@ -664,18 +807,92 @@ func (ts TransactionServer) DumpLogUser(transNum int, params ...string) string {
 	return "1"
 }
 
+// summaryHistory is the number of recent commands shown by DISPLAY_SUMMARY
+const summaryHistory = 20
+
+// accountSummary is the reply to DISPLAY_SUMMARY, sent as one line of JSON
+type accountSummary struct {
+	database.UserInfo
+	// CostBasis is what the user paid for the shares of each stock they hold
+	CostBasis   map[string]database.CostBasis `json:"costBasis"`
+	Triggers    []triggerclient.UserTrigger   `json:"triggers"`
+	LimitOrders []database.LimitOrder         `json:"limitOrders"`
+	History     []map[string]string           `json:"history"`
+	// Errors lists the parts of the summary that couldn't be fetched
+	Errors []string `json:"errors,omitempty"`
+}
+
 // DisplaySummary provides a summary to the client of the given user's
 // transaction history and the current status of their accounts as well
 // as any set buy or sell triggers and their parameters.
+// The accounts come from the database, which must be reachable, while
+// triggers and history are left empty and named in errors if the trigger
+// or audit server can't be reached.
 func (ts TransactionServer) DisplaySummary(transNum int, params ...string) string {
 	user := params[0]
+	summary := accountSummary{
+		Triggers:    []triggerclient.UserTrigger{},
+		LimitOrders: []database.LimitOrder{},
+		History:     []map[string]string{},
+	}
+
+	var wg sync.WaitGroup
+	var triggersErr, ordersErr, historyErr error
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		triggers, err := ts.TriggerClient.UserTriggers(user)
+		if err == nil && triggers != nil {
+			summary.Triggers = triggers
+		}
+		triggersErr = err
+	}()
+	go func() {
+		defer wg.Done()
+		orders, err := ts.UserDatabase.GetLimitOrders(user)
+		if err == nil && orders != nil {
+			summary.LimitOrders = orders
+		}
+		ordersErr = err
+	}()
+	go func() {
+		defer wg.Done()
+		history, err := ts.Logger.History(user, summaryHistory)
+		if err == nil && history != nil {
+			summary.History = history
+		}
+		historyErr = err
+	}()
 	info, err := ts.UserDatabase.GetUserInfo(user)
+	if err == nil {
+		summary.CostBasis, err = ts.UserDatabase.GetCostBasis(user)
+	}
+	wg.Wait()
 	if err != nil {
 		ts.reportError(transNum, "DISPLAY_SUMMARY", user,
 			fmt.Sprintf("Error getting user information from database:  %s", err.Error()), nil, nil, nil)
 		return "-1"
 	}
-	return info
+	summary.UserInfo = info
+	if triggersErr != nil {
+		fmt.Printf("error: getting triggers of %s: %v\n", user, triggersErr)
+		summary.Errors = append(summary.Errors, "triggers unavailable")
+	}
+	if ordersErr != nil {
+		fmt.Printf("error: getting limit orders of %s: %v\n", user, ordersErr)
+		summary.Errors = append(summary.Errors, "limit orders unavailable")
+	}
+	if historyErr != nil {
+		fmt.Printf("error: getting history of %s: %v\n", user, historyErr)
+		summary.Errors = append(summary.Errors, "history unavailable")
+	}
+
+	reply, err := json.Marshal(summary)
+	if err != nil {
+		ts.reportError(transNum, "DISPLAY_SUMMARY", user, "Error encoding summary: "+err.Error(), nil, nil, nil)
+		return "-1"
+	}
+	return string(reply)
 }
 
 // Work with whole numbers for now
@@ -688,7 +905,11 @@ func (ts TransactionServer) getMaxPurchase(user string, stock string, availableF
 	if stockPrice != nil {
 		price = stockPrice.(decimal.Decimal)
 	} else {
-		resp, err := quoteclient.Query(user, stock, transNum.(int))
+		span := ts.Logger.StartSpan(ts.Name, transNum.(int), "call quoteserver")
+		start := time.Now()
+		resp, err := ts.QuoteClient.Query(user, stock, transNum.(int))
+		quoteLatency.Since(start)
+		span.End()
 		if err != nil {
 			return decimal.Decimal{}, 0, err
 		}
@@ -698,3 +919,11 @@ func (ts TransactionServer) getMaxPurchase(user string, stock string, availableF
 	money := price.Mul(decimal.New(shares, 0))
 	return money.Round(2), shares, nil
 }
+
+// sharePrice is the price of each share of an order costing cost in total
+func sharePrice(cost decimal.Decimal, shares int64) decimal.Decimal {
+	if shares == 0 {
+		return decimal.Zero
+	}
+	return cost.Div(decimal.New(shares, 0)).Round(2)
+}
@@ -1,7 +1,10 @@
 package triggerclient
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -16,6 +19,10 @@ const (
 	startEndpoint  = "/startTrigger"
 	cancelEndpoint = "/cancelTrigger"
 	listEndpoint   = "/runningTriggers"
+	userEndpoint   = "/userTriggers"
+
+	limitEndpoint       = "/limitOrder"
+	cancelLimitEndpoint = "/cancelLimitOrder"
 )
 
 // TriggerFunctions are all of the functionality needed to support the trigger
@@ -33,6 +40,10 @@ type TriggerFunctions interface {
 	CancelBuyTrigger(transNum int, username string, stock string) (Trigger, error)
 
 	ListRunningTriggers()
+	UserTriggers(username string) ([]UserTrigger, error)
+
+	PlaceLimitOrder(transNum int, username string, order LimitOrder) error
+	CancelLimitOrder(transNum int, username string, id int64) error
 }
 
 // TriggerClient acts as an interface for the trigger server
@@ -185,6 +196,33 @@ func (tc TriggerClient) ListRunningTriggers() {
 	}
 }
 
+// UserTrigger is one of a user's triggers as listed by the trigger server.
+// Price is zero until the trigger is started.
+type UserTrigger struct {
+	Stock   string          `json:"stock"`
+	Action  string          `json:"action"`
+	Amount  decimal.Decimal `json:"amount"`
+	Price   decimal.Decimal `json:"price"`
+	Running bool            `json:"running"`
+}
+
+// UserTriggers returns the waiting and running triggers of username
+func (tc TriggerClient) UserTriggers(username string) ([]UserTrigger, error) {
+	resp, err := http.Get(tc.TriggerURL + userEndpoint + "?" + url.Values{"username": {username}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("trigger server replied %s", resp.Status)
+	}
+	var triggers []UserTrigger
+	if err := json.NewDecoder(resp.Body).Decode(&triggers); err != nil {
+		return nil, err
+	}
+	return triggers, nil
+}
+
 func (tc TriggerClient) getTriggerFromResponse(resp *http.Response) (Trigger, error) {
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -223,3 +261,53 @@ func (tc TriggerClient) parseTriggerFromString(trigStr string) (Trigger, error)
 	}
 	return trig, nil
 }
+
+// LimitOrder is an order placed in the database for the trigger server to
+// rest in the book of its stock
+type LimitOrder struct {
+	ID     int64
+	Stock  string
+	Action string
+	Shares int64
+	Price  decimal.Decimal
+	Placed int64 // unix ms
+}
+
+// PlaceLimitOrder rests the order of username in the order book. Placing
+// an order that is already there does nothing.
+func (tc TriggerClient) PlaceLimitOrder(transNum int, username string, order LimitOrder) error {
+	values := url.Values{
+		"id":       {strconv.FormatInt(order.ID, 10)},
+		"action":   {order.Action},
+		"transnum": {strconv.Itoa(transNum)},
+		"username": {username},
+		"stock":    {order.Stock},
+		"shares":   {strconv.FormatInt(order.Shares, 10)},
+		"price":    {order.Price.String()},
+		"placed":   {strconv.FormatInt(order.Placed, 10)},
+	}
+	return tc.postLimitOrder(limitEndpoint, values)
+}
+
+// CancelLimitOrder takes the user's order id out of the order book
+func (tc TriggerClient) CancelLimitOrder(transNum int, username string, id int64) error {
+	values := url.Values{
+		"transnum": {strconv.Itoa(transNum)},
+		"username": {username},
+		"id":       {strconv.FormatInt(id, 10)},
+	}
+	return tc.postLimitOrder(cancelLimitEndpoint, values)
+}
+
+func (tc TriggerClient) postLimitOrder(endpoint string, values url.Values) error {
+	resp, err := http.PostForm(tc.TriggerURL+endpoint, values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("trigger server replied %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
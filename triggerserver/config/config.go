@@ -0,0 +1,48 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// Config holds the settings of the trigger server
+type Config struct {
+	TriggerAddr     string        `key:"triggeraddr" help:"host this server is reached at"`
+	TriggerPort     string        `key:"triggerport" required:"true" help:"port to serve on"`
+	DrainTimeout    time.Duration `key:"shutdowntimeout" default:"20" unit:"s" help:"time to let alerts finish at shutdown"`
+	PollInterval    time.Duration `key:"triggerpollinterval" default:"60001" unit:"ms" help:"time between quotes for each running trigger"`
+	LimitFillShares int64         `key:"limitfillshares" default:"0" help:"most shares of a limit order filled at each price, 0 fills it all at once"`
+	MatchOrders     bool          `key:"matchorders" help:"match limit buys with other users' sells before filling them against the quote"`
+	QuoteAddr       string        `key:"quoteaddr" required:"true"`
+	QuotePort       string        `key:"quoteport" required:"true"`
+	SharedCacheAddr string        `key:"quotecacheaddr" help:"redis host of the quote servers' shared cache, if any"`
+	SharedCachePort string        `key:"quotecacheport" help:"redis port of the shared quote cache"`
+	RedisPassword   string        `key:"redispassword" secret:"true" help:"redis password, if one is set"`
+	TransAddr       string        `key:"transaddr" required:"true" help:"transaction server host, alerted when triggers succeed"`
+	TransPort       string        `key:"transport" required:"true" help:"transaction server port"`
+	AlertTimeout    time.Duration `key:"alerttimeout" default:"15" unit:"s" help:"time to wait connecting to the transaction server, and for it to settle a limit order fill"`
+}
+
+// Validate checks the settings that can't be wrong on their own
+func (c *Config) Validate() error {
+	if c.SharedCacheAddr != "" && c.SharedCachePort == "" {
+		return errors.New("quotecacheport is required with quotecacheaddr")
+	}
+	if c.LimitFillShares < 0 {
+		return errors.New("limitfillshares can't be negative")
+	}
+	if c.PollInterval <= 0 || c.AlertTimeout <= 0 || c.DrainTimeout <= 0 {
+		return errors.New("durations must be positive")
+	}
+	return nil
+}
+
+// QuoteURL is the base URL of the quote server
+func (c *Config) QuoteURL() string {
+	return "http://" + c.QuoteAddr + ":" + c.QuotePort
+}
+
+// TransAddress is the host:port of the transaction server
+func (c *Config) TransAddress() string {
+	return c.TransAddr + ":" + c.TransPort
+}
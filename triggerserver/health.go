@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"seng468/triggerserver/health"
+	"strings"
+	"time"
+)
+
+// readinessChecks checks the quote server triggers watch and the
+// transaction server they alert
+func readinessChecks() *health.Checker {
+	checks := new(health.Checker)
+	checks.Add("quote server", health.Get(cfg.QuoteURL()+"/healthz"))
+	checks.Add("transaction server", pingTransactionServer)
+	return checks
+}
+
+// pingTransactionServer sends the socket server a PING and waits for its PONG
+func pingTransactionServer() error {
+	conn, err := net.DialTimeout("tcp", cfg.TransAddress(), health.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(health.Timeout))
+
+	if _, err := conn.Write([]byte("0;PING\n")); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(reply) != "PONG" {
+		return fmt.Errorf("unexpected reply to PING %q", reply)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"seng468/triggerserver/metrics"
+)
+
+var (
+	requestCount = metrics.NewCounter("trigger_requests_total",
+		"Requests handled, by endpoint and status code.", "endpoint", "code")
+	requestLatency = metrics.NewHistogram("trigger_request_duration_seconds",
+		"Time taken to handle each request.", metrics.DefaultBuckets, "endpoint")
+)
+
+func init() {
+	metrics.NewGaugeFunc("trigger_successes_queued",
+		"Triggers that have fired but not yet been handled.", func() float64 { return float64(len(successListener)) })
+	metrics.NewGaugeFunc("trigger_running", "Triggers watching for their price.", func() float64 {
+		triggersLock.Lock()
+		defer triggersLock.Unlock()
+		return float64(len(runningTriggers))
+	})
+	metrics.NewGaugeFunc("trigger_waiting", "Triggers set but not yet started.", func() float64 {
+		triggersLock.Lock()
+		defer triggersLock.Unlock()
+		return float64(len(waitingTriggers))
+	})
+	metrics.NewGaugeFunc("trigger_limit_orders", "Limit orders resting in the books.", func() float64 {
+		booksLock.Lock()
+		defer booksLock.Unlock()
+		n := 0
+		for _, b := range books {
+			n += len(b.buys) + len(b.sells)
+		}
+		return float64(n)
+	})
+}
+
+// handle serves fn at endpoint, counting and timing its requests
+func handle(endpoint string, fn func(w http.ResponseWriter, r *http.Request)) {
+	http.HandleFunc(endpoint, metrics.Instrument(endpoint, requestCount, requestLatency, fn))
+}
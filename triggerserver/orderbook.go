@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// limitOrder is a LIMIT_BUY or LIMIT_SELL resting in the book of its stock
+// until the quoted price crosses its limit. It may be filled in parts.
+type limitOrder struct {
+	ID       int64           `json:"id"`
+	TransNum int             `json:"-"`
+	Username string          `json:"-"`
+	Stock    string          `json:"stock"`
+	Action   string          `json:"action"`
+	Shares   int64           `json:"shares"`
+	Filled   int64           `json:"filled"`
+	Price    decimal.Decimal `json:"price"`
+	Placed   int64           `json:"placed"` // unix ms
+	// pending is the shares sent to the transaction server to settle that
+	// it hasn't confirmed yet
+	pending int64
+}
+
+// remaining is the number of shares still to be filled
+func (o *limitOrder) remaining() int64 {
+	if n := o.Shares - o.Filled - o.pending; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// Status is "open" until part of the order is filled and "partial" after
+func (o limitOrder) MarshalJSON() ([]byte, error) {
+	type plain limitOrder
+	status := "open"
+	if o.Filled > 0 {
+		status = "partial"
+	}
+	return json.Marshal(struct {
+		plain
+		Status string `json:"status"`
+	}{plain(o), status})
+}
+
+// crosses reports whether the order can be filled at price
+func (o *limitOrder) crosses(price decimal.Decimal) bool {
+	if o.Action == "BUY" {
+		return price.LessThanOrEqual(o.Price)
+	}
+	return price.GreaterThanOrEqual(o.Price)
+}
+
+// fill is part of a limit order executed at a price, to be settled by the
+// transaction server. A buy matched with another user's sell has that sell
+// as against, and is settled as a trade between the two.
+type fill struct {
+	order   limitOrder
+	shares  int64
+	price   decimal.Decimal
+	against *limitOrder
+}
+
+func (f fill) String() string {
+	if f.against != nil {
+		return fmt.Sprintf("LIMIT_MATCH,%v,%v,%v,%v,%v,%v,%v\n", f.order.Username, f.order.ID,
+			f.against.Username, f.against.ID, f.order.Stock, f.shares, f.price)
+	}
+	return fmt.Sprintf("LIMIT_FILL,%v,%v,%v,%v,%v,%v\n", f.order.Username, f.order.ID,
+		f.order.Stock, f.order.Action, f.shares, f.price)
+}
+
+// book holds the resting orders of a stock by price-time priority: highest
+// buys and lowest sells first, then the oldest at each price
+type book struct {
+	buys, sells []*limitOrder
+	polling     bool
+}
+
+func (b *book) side(action string) *[]*limitOrder {
+	if action == "BUY" {
+		return &b.buys
+	}
+	return &b.sells
+}
+
+func (b *book) add(o *limitOrder) {
+	side := b.side(o.Action)
+	// Orders at the same price go after those already there
+	i := sort.Search(len(*side), func(i int) bool {
+		other := (*side)[i]
+		if o.Action == "BUY" {
+			return other.Price.LessThan(o.Price)
+		}
+		return other.Price.GreaterThan(o.Price)
+	})
+	*side = append(*side, nil)
+	copy((*side)[i+1:], (*side)[i:])
+	(*side)[i] = o
+}
+
+// remove takes the order id of username out of the book
+func (b *book) remove(id int64, username string) (*limitOrder, bool) {
+	for _, side := range []*[]*limitOrder{&b.buys, &b.sells} {
+		for i, o := range *side {
+			if o.ID == id && o.Username == username {
+				*side = append((*side)[:i], (*side)[i+1:]...)
+				return o, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// find returns the order id, or nil if it isn't in the book
+func (b *book) find(id int64) *limitOrder {
+	for _, side := range [][]*limitOrder{b.buys, b.sells} {
+		for _, o := range side {
+			if o.ID == id {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+func (b *book) empty() bool {
+	return len(b.buys) == 0 && len(b.sells) == 0
+}
+
+// match fills the orders that cross price, in priority order, taking at
+// most cfg.LimitFillShares of each at a time. With cfg.MatchOrders, buys are
+// first matched with other users' sells at price, and only what can't be
+// matched is filled against the quote. The shares filled stay pending until
+// the transaction server settles them.
+func (b *book) match(price decimal.Decimal) []fill {
+	var fills []fill
+	filled := make(map[*limitOrder]int64)
+	// room is what is left of o to fill at this price
+	room := func(o *limitOrder) int64 {
+		n := o.remaining()
+		if cfg.LimitFillShares > 0 && n > cfg.LimitFillShares-filled[o] {
+			n = cfg.LimitFillShares - filled[o]
+		}
+		return n
+	}
+
+	if cfg.MatchOrders {
+		// Both sides are in priority order, so the orders crossing price
+		// come before those that don't
+		for _, buy := range b.buys {
+			if !buy.crosses(price) {
+				break
+			}
+			for _, sell := range b.sells {
+				if room(buy) == 0 || !sell.crosses(price) {
+					break
+				}
+				shares := room(sell)
+				if sell.Username == buy.Username || shares == 0 {
+					continue
+				}
+				if shares > room(buy) {
+					shares = room(buy)
+				}
+				buy.pending += shares
+				sell.pending += shares
+				filled[buy] += shares
+				filled[sell] += shares
+				against := *sell
+				fills = append(fills, fill{*buy, shares, price, &against})
+			}
+		}
+	}
+
+	for _, side := range [][]*limitOrder{b.buys, b.sells} {
+		for _, o := range side {
+			if !o.crosses(price) {
+				continue
+			}
+			if shares := room(o); shares > 0 {
+				o.pending += shares
+				filled[o] += shares
+				fills = append(fills, fill{*o, shares, price, nil})
+			}
+		}
+	}
+	return fills
+}
+
+// settle applies the transaction server's reply to f. Settled shares are
+// filled, and orders leave the book once every share is. A reply of
+// 0,<id>,<filled> corrects the order id, which the transaction server has
+// closed if filled is -1. The shares of any other reply are released to be
+// filled again at a later price.
+func (b *book) settle(f fill, reply string) {
+	var staleID, staleFilled int64
+	_, err := fmt.Sscanf(reply, "0,%d,%d", &staleID, &staleFilled)
+	stale := err == nil
+
+	ids := []int64{f.order.ID}
+	if f.against != nil {
+		ids = append(ids, f.against.ID)
+	}
+	for _, id := range ids {
+		o := b.find(id)
+		if o == nil {
+			continue
+		}
+		o.pending -= f.shares
+		switch {
+		case reply == "1":
+			o.Filled += f.shares
+		case stale && id == staleID && staleFilled < 0:
+			o.Filled = o.Shares
+		case stale && id == staleID:
+			o.Filled = staleFilled
+		}
+		if o.Filled >= o.Shares {
+			b.remove(o.ID, o.Username)
+		}
+	}
+}
+
+var (
+	books     = make(map[string]*book)
+	booksLock sync.Mutex
+)
+
+var fillListener = make(chan fill, 2048)
+
+// rest adds o to the book of its stock unless it is already there, starting
+// to poll the book if it isn't yet. booksLock must be held.
+func rest(o *limitOrder) *limitOrder {
+	b, ok := books[o.Stock]
+	if !ok {
+		b = new(book)
+		books[o.Stock] = b
+	}
+	if resting := b.find(o.ID); resting != nil {
+		return resting
+	}
+	b.add(o)
+	if !b.polling {
+		b.polling = true
+		go pollBook(o.Stock)
+	}
+	return o
+}
+
+// limitOrderHandler rests a limit order, replying with it as JSON. The
+// transaction server has already stored it and reserved the funds or shares
+// it needs, so resting an order twice leaves the first.
+func limitOrderHandler(w http.ResponseWriter, r *http.Request) {
+	action := r.FormValue("action")
+	if !verifyAction(action) {
+		http.Error(w, "action must be BUY or SELL", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	transNum, err := strconv.Atoi(r.FormValue("transnum"))
+	if err != nil {
+		http.Error(w, "bad transnum", http.StatusBadRequest)
+		return
+	}
+	shares, err := strconv.ParseInt(r.FormValue("shares"), 10, 64)
+	if err != nil || shares < 1 {
+		http.Error(w, "bad shares", http.StatusBadRequest)
+		return
+	}
+	price, err := decimal.NewFromString(r.FormValue("price"))
+	if err != nil || !price.IsPositive() {
+		http.Error(w, "bad price", http.StatusBadRequest)
+		return
+	}
+	placed, err := strconv.ParseInt(r.FormValue("placed"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad placed", http.StatusBadRequest)
+		return
+	}
+	username, stock := r.FormValue("username"), r.FormValue("stock")
+	if username == "" || stock == "" {
+		http.Error(w, "username and stock are required", http.StatusBadRequest)
+		return
+	}
+
+	booksLock.Lock()
+	o := rest(&limitOrder{
+		ID:       id,
+		TransNum: transNum,
+		Username: username,
+		Stock:    stock,
+		Action:   action,
+		Shares:   shares,
+		Price:    price,
+		Placed:   placed,
+	})
+	resting := *o
+	booksLock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resting)
+}
+
+// cancelLimitOrderHandler takes a user's order out of the book, replying with
+// it as JSON so the transaction server can release what is left unfilled
+func cancelLimitOrderHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+
+	booksLock.Lock()
+	var cancelled *limitOrder
+	for _, b := range books {
+		if o, ok := b.remove(id, username); ok {
+			cancelled = o
+			break
+		}
+	}
+	booksLock.Unlock()
+
+	if cancelled == nil {
+		http.Error(w, "no such order", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cancelled)
+}
+
+// userLimitOrdersHandler lists the resting orders of a user as JSON, oldest first
+func userLimitOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	if username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	list := []limitOrder{}
+	booksLock.Lock()
+	for _, b := range books {
+		for _, side := range [][]*limitOrder{b.buys, b.sells} {
+			for _, o := range side {
+				if o.Username == username {
+					list = append(list, *o)
+				}
+			}
+		}
+	}
+	booksLock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
+
+// checkLimitOrders is called with each fresh price from the quote server's
+// stream, filling any orders on that stock that it crosses
+func checkLimitOrders(stock string, price decimal.Decimal) {
+	var fills []fill
+	booksLock.Lock()
+	if b, ok := books[stock]; ok {
+		fills = b.match(price)
+	}
+	booksLock.Unlock()
+
+	// Sent without the lock, which the fills need to be settled
+	for _, f := range fills {
+		fillListener <- f
+	}
+}
+
+// pollBook quotes stock every poll interval while it has resting orders,
+// like a running trigger does
+func pollBook(stock string) {
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		booksLock.Lock()
+		b := books[stock]
+		if b.empty() {
+			b.polling = false
+			delete(books, stock)
+			booksLock.Unlock()
+			return
+		}
+		first := b.buys
+		if len(first) == 0 {
+			first = b.sells
+		}
+		user, transNum := first[0].Username, first[0].TransNum
+		booksLock.Unlock()
+
+		price, err := quotes.Query(user, stock, transNum)
+		if err != nil {
+			fmt.Printf("error: quoting %s for limit orders: %v\n", stock, err)
+		} else {
+			checkLimitOrders(stock, price)
+		}
+		<-ticker.C
+	}
+}
+
+func startFillListener() {
+	for f := range fillListener {
+		atomic.AddInt64(&pendingAlerts, 1)
+		go func(f fill) {
+			defer atomic.AddInt64(&pendingAlerts, -1)
+			reply, err := requestTransactionServer(f.order.TransNum, f.String())
+			if err != nil {
+				fmt.Printf("error: settling %s: %v\n", strings.TrimSpace(f.String()), err)
+			}
+			booksLock.Lock()
+			if b, ok := books[f.order.Stock]; ok {
+				b.settle(f, reply)
+			}
+			booksLock.Unlock()
+		}(f)
+	}
+}
+
+// storedOrder is an open limit order as the transaction server stores it
+type storedOrder struct {
+	ID       int64           `json:"id"`
+	User     string          `json:"user"`
+	TransNum int             `json:"transNum"`
+	Stock    string          `json:"stock"`
+	Action   string          `json:"action"`
+	Shares   int64           `json:"shares"`
+	Filled   int64           `json:"filled"`
+	Price    decimal.Decimal `json:"price"`
+	Placed   int64           `json:"placed"`
+}
+
+// restoreLimitOrders rests every open order the transaction server has
+// stored, so the books aren't lost when the server restarts. It retries
+// until the transaction server can answer.
+func restoreLimitOrders() {
+	for {
+		reply, err := requestTransactionServer(0, "LIMIT_ORDERS\n")
+		var orders []storedOrder
+		if err == nil {
+			err = json.Unmarshal([]byte(reply), &orders)
+		}
+		if err != nil {
+			fmt.Printf("error: restoring limit orders: %v -- retrying\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		booksLock.Lock()
+		for _, o := range orders {
+			rest(&limitOrder{
+				ID:       o.ID,
+				TransNum: o.TransNum,
+				Username: o.User,
+				Stock:    o.Stock,
+				Action:   o.Action,
+				Shares:   o.Shares,
+				Filled:   o.Filled,
+				Price:    o.Price,
+				Placed:   o.Placed,
+			})
+		}
+		booksLock.Unlock()
+		fmt.Printf("Restored %d limit orders\n", len(orders))
+		return
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func dollars(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func order(id int64, user string, action string, shares int64, price string) *limitOrder {
+	return &limitOrder{ID: id, Username: user, Stock: "ABC", Action: action, Shares: shares, Price: dollars(price)}
+}
+
+// ids lists the orders of a side of the book in order
+func ids(side []*limitOrder) []int64 {
+	list := []int64{}
+	for _, o := range side {
+		list = append(list, o.ID)
+	}
+	return list
+}
+
+func sameIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withConfig runs test with the matching settings, restoring cfg after
+func withConfig(matchOrders bool, limitFillShares int64, test func()) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	cfg.MatchOrders = matchOrders
+	cfg.LimitFillShares = limitFillShares
+	test()
+}
+
+func TestBookAdd(t *testing.T) {
+	b := new(book)
+	b.add(order(1, "a", "BUY", 1, "10"))
+	b.add(order(2, "a", "BUY", 1, "12"))
+	b.add(order(3, "a", "BUY", 1, "10"))
+	b.add(order(4, "a", "BUY", 1, "11"))
+	b.add(order(5, "a", "SELL", 1, "20"))
+	b.add(order(6, "a", "SELL", 1, "15"))
+	b.add(order(7, "a", "SELL", 1, "20"))
+	b.add(order(8, "a", "SELL", 1, "15.50"))
+
+	// Best price first, then the oldest at each price
+	if got, want := ids(b.buys), []int64{2, 4, 1, 3}; !sameIDs(got, want) {
+		t.Errorf("buys = %v, want %v", got, want)
+	}
+	if got, want := ids(b.sells), []int64{6, 8, 5, 7}; !sameIDs(got, want) {
+		t.Errorf("sells = %v, want %v", got, want)
+	}
+}
+
+func TestMatchAgainstQuote(t *testing.T) {
+	withConfig(false, 0, func() {
+		b := new(book)
+		b.add(order(1, "a", "BUY", 10, "10"))
+		b.add(order(2, "b", "BUY", 5, "9"))
+		b.add(order(3, "c", "SELL", 4, "10"))
+		b.add(order(4, "d", "SELL", 4, "11"))
+
+		fills := b.match(dollars("10"))
+		if len(fills) != 2 {
+			t.Fatalf("fills = %+v, want orders 1 and 3", fills)
+		}
+		for i, want := range []struct {
+			id     int64
+			shares int64
+		}{{1, 10}, {3, 4}} {
+			f := fills[i]
+			if f.order.ID != want.id || f.shares != want.shares || !f.price.Equal(dollars("10")) || f.against != nil {
+				t.Errorf("fill %d = %+v, want %d shares of order %d at 10", i, f, want.shares, want.id)
+			}
+		}
+		if got, want := fills[0].String(), "LIMIT_FILL,a,1,ABC,BUY,10,10\n"; got != want {
+			t.Errorf("fill = %q, want %q", got, want)
+		}
+
+		// Nothing is filled again while the fills are unsettled
+		if again := b.match(dollars("10")); len(again) != 0 {
+			t.Errorf("pending orders filled again: %+v", again)
+		}
+		if got, want := ids(b.buys), []int64{1, 2}; !sameIDs(got, want) {
+			t.Errorf("buys = %v, want %v until settled", got, want)
+		}
+	})
+}
+
+func TestMatchPartialFills(t *testing.T) {
+	withConfig(false, 3, func() {
+		b := new(book)
+		b.add(order(1, "a", "SELL", 7, "10"))
+
+		for _, want := range []int64{3, 3, 1} {
+			fills := b.match(dollars("10"))
+			if len(fills) != 1 || fills[0].shares != want {
+				t.Fatalf("fills = %+v, want %d shares", fills, want)
+			}
+			b.settle(fills[0], "1")
+		}
+		if !b.empty() {
+			t.Errorf("filled order left in the book: %v", ids(b.sells))
+		}
+	})
+}
+
+func TestSettle(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		filled  int64
+		resting bool
+	}{
+		{"settled", "1", 4, true},
+		{"settled the rest", "1", 10, false},
+		{"failed", "-1", 0, true},
+		{"no reply", "", 0, true},
+		{"filled further", "0,1,6", 6, true},
+		{"filled already", "0,1,10", 10, false},
+		{"closed", "0,1,-1", 10, false},
+		{"other order stale", "0,2,6", 0, true},
+	}
+	for _, test := range tests {
+		withConfig(false, 4, func() {
+			b := new(book)
+			o := order(1, "a", "BUY", 10, "10")
+			if test.name == "settled the rest" {
+				o.Filled = 6
+			}
+			b.add(o)
+			fills := b.match(dollars("10"))
+			if len(fills) != 1 {
+				t.Fatalf("%s: fills = %+v", test.name, fills)
+			}
+
+			b.settle(fills[0], test.reply)
+			if o.Filled != test.filled || o.pending != 0 {
+				t.Errorf("%s: filled %d with %d pending, want %d", test.name, o.Filled, o.pending, test.filled)
+			}
+			if resting := b.find(1) != nil; resting != test.resting {
+				t.Errorf("%s: order resting = %v, want %v", test.name, resting, test.resting)
+			}
+		})
+	}
+}
+
+func TestMatchBetweenUsers(t *testing.T) {
+	withConfig(true, 0, func() {
+		b := new(book)
+		b.add(order(1, "a", "BUY", 10, "11"))
+		b.add(order(2, "b", "BUY", 3, "10"))
+		b.add(order(3, "a", "SELL", 4, "9"))
+		b.add(order(4, "c", "SELL", 4, "9.50"))
+		b.add(order(5, "d", "SELL", 4, "10"))
+		b.add(order(6, "e", "SELL", 4, "12"))
+
+		fills := b.match(dollars("10"))
+		want := []struct {
+			buy, sell int64 // sell is 0 for a fill against the quote
+			shares    int64
+		}{
+			// a's sell is skipped for a's buy, then matched with b's
+			{1, 4, 4},
+			{1, 5, 4},
+			{2, 3, 3},
+			{1, 0, 2},
+			{3, 0, 1},
+		}
+		if len(fills) != len(want) {
+			t.Fatalf("fills = %+v, want %d", fills, len(want))
+		}
+		for i, w := range want {
+			f := fills[i]
+			var sell int64
+			if f.against != nil {
+				sell = f.against.ID
+			}
+			if f.order.ID != w.buy || sell != w.sell || f.shares != w.shares || !f.price.Equal(dollars("10")) {
+				t.Errorf("fill %d = order %d against %d for %d, want %d against %d for %d",
+					i, f.order.ID, sell, f.shares, w.buy, w.sell, w.shares)
+			}
+		}
+		if got, want := fills[0].String(), "LIMIT_MATCH,a,1,c,4,ABC,4,10\n"; got != want {
+			t.Errorf("match = %q, want %q", got, want)
+		}
+
+		// A settled match fills both sides, a stale one corrects only the
+		// order it names
+		b.settle(fills[0], "1")
+		b.settle(fills[1], "0,5,-1")
+		if o := b.find(1); o.Filled != 4 || o.pending != 2 {
+			t.Errorf("buy filled %d with %d pending, want 4 and 2", o.Filled, o.pending)
+		}
+		if b.find(4) != nil || b.find(5) != nil {
+			t.Error("filled and closed sells left in the book")
+		}
+	})
+}
+
+func TestMatchNeverWithOwnOrder(t *testing.T) {
+	withConfig(true, 0, func() {
+		b := new(book)
+		b.add(order(1, "a", "BUY", 5, "10"))
+		b.add(order(2, "a", "SELL", 5, "10"))
+
+		for _, f := range b.match(dollars("10")) {
+			if f.against != nil {
+				t.Errorf("matched a with themselves: %+v", f)
+			}
+		}
+	})
+}
@@ -1,18 +1,75 @@
 package quoteclient
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/shopspring/decimal"
 )
 
-func Query(user string, stock string, transNum int) (decimal.Decimal, error) {
+// Client gets quotes from the quote server at QuoteURL, reading the quote
+// cache shared with the quote servers first if there is one
+type Client struct {
+	QuoteURL  string
+	cachePool *redis.Pool
+}
+
+// NewClient returns a client of the quote server at quoteURL. The shared
+// cache at cacheAddr:cachePort is only used if cacheAddr isn't empty.
+func NewClient(quoteURL string, cacheAddr string, cachePort string, password string) *Client {
+	return &Client{
+		QuoteURL:  quoteURL,
+		cachePool: newCachePool(cacheAddr, cachePort, password),
+	}
+}
+
+func newCachePool(addr string, port string, password string) *redis.Pool {
+	if addr == "" {
+		return nil
+	}
+	return &redis.Pool{
+		MaxIdle:     100,
+		MaxActive:   0,
+		IdleTimeout: time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr+":"+port, redis.DialPassword(password))
+		},
+	}
+}
+
+// cachedQuote returns the price the quote servers last cached for stock
+func (c *Client) cachedQuote(stock string) (decimal.Decimal, bool) {
+	if c.cachePool == nil {
+		return decimal.Decimal{}, false
+	}
+	conn := c.cachePool.Get()
+	defer conn.Close()
+
+	price, err := redis.String(conn.Do("GET", "quote:"+stock))
+	if err != nil {
+		return decimal.Decimal{}, false
+	}
+	dec, err := decimal.NewFromString(price)
+	if err != nil {
+		return decimal.Decimal{}, false
+	}
+	return dec, true
+}
+
+func (c *Client) Query(user string, stock string, transNum int) (decimal.Decimal, error) {
+	if price, found := c.cachedQuote(stock); found {
+		return price, nil
+	}
+
 	http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost = 100
-	req, err := http.NewRequest("GET", "http://"+os.Getenv("quoteaddr")+":"+os.Getenv("quoteport")+"/quote", nil)
+	req, err := http.NewRequest("GET", c.QuoteURL+"/quote", nil)
 	if err != nil {
 		panic(err)
 	}
@@ -43,3 +100,40 @@ func Query(user string, stock string, transNum int) (decimal.Decimal, error) {
 	resp.Body.Close()
 	return decimal.NewFromString(string(amount))
 }
+
+// Stream follows the quote server's price feed, calling fn with every fresh quote.
+// Blocks forever, reconnecting whenever the stream drops.
+func (c *Client) Stream(fn func(stock string, price decimal.Decimal)) {
+	streamURL := c.QuoteURL + "/stream"
+	for {
+		resp, err := http.Get(streamURL)
+		if err != nil {
+			fmt.Println("Quote stream unavailable -- retrying")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var update struct {
+				Stock string `json:"stock"`
+				Price string `json:"price"`
+			}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &update); err != nil {
+				continue
+			}
+			price, err := decimal.NewFromString(update.Price)
+			if err != nil {
+				continue
+			}
+			fn(update.Stock, price)
+		}
+		resp.Body.Close()
+		fmt.Println("Quote stream closed -- reconnecting")
+		time.Sleep(time.Second)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"seng468/triggerserver/quote"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -44,7 +43,7 @@ func (t trigger) StartPolling() {
 		return
 	}
 
-	ticker := time.NewTicker((time.Second * 60) + time.Millisecond)
+	ticker := time.NewTicker(cfg.PollInterval)
 	defer ticker.Stop()
 
 	for _ = range ticker.C {
@@ -69,7 +68,7 @@ func (t trigger) checkTriggerStatus() bool {
 }
 
 func (t trigger) hitQuoteServer() decimal.Decimal {
-	result, err := quoteclient.Query(t.username, t.stockname, t.transNum)
+	result, err := quotes.Query(t.username, t.stockname, t.transNum)
 	if err != nil {
 		panic(err)
 	}
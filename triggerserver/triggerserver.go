@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"seng468/triggerserver/config"
+	"seng468/triggerserver/metrics"
+	"seng468/triggerserver/quote"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	// _ "net/http/pprof"
 
@@ -25,20 +35,65 @@ var runningTriggers = make(map[triggersKey]trigger)
 
 var successListener = make(chan trigger, 2048)
 
+// pendingAlerts counts fired triggers whose success hasn't been sent to the
+// transaction server yet
+var pendingAlerts int64
+
+var cfg config.Config
+
+// quotes gets the prices triggers are checked against
+var quotes *quoteclient.Client
+
 func main() {
 	fmt.Println("Launching server...")
-	http.HandleFunc("/setTrigger", setTriggerHandler)
-	http.HandleFunc("/startTrigger", startTriggerHandler)
-	http.HandleFunc("/cancelTrigger", cancelTriggerHandler)
-	http.HandleFunc("/runningTriggers", getRunningTriggersHandler)
-	http.HandleFunc("/waitingTriggers", getWaitingTriggersHandler)
+	if err := config.Load(&cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	config.Print(os.Stdout, &cfg)
+	quotes = quoteclient.NewClient(cfg.QuoteURL(), cfg.SharedCacheAddr, cfg.SharedCachePort, cfg.RedisPassword)
+
+	handle("/setTrigger", setTriggerHandler)
+	handle("/startTrigger", startTriggerHandler)
+	handle("/cancelTrigger", cancelTriggerHandler)
+	handle("/runningTriggers", getRunningTriggersHandler)
+	handle("/waitingTriggers", getWaitingTriggersHandler)
+	handle("/userTriggers", userTriggersHandler)
+	handle("/limitOrder", limitOrderHandler)
+	handle("/cancelLimitOrder", cancelLimitOrderHandler)
+	handle("/userLimitOrders", userLimitOrdersHandler)
+	http.Handle("/metrics", metrics.Handler())
+	readinessChecks().Register(http.DefaultServeMux)
 
 	go startSuccessListener()
-
-	fmt.Printf("Trigger server listening on %s:%s\n", os.Getenv("triggeraddr"), os.Getenv("triggerport"))
-	if err := http.ListenAndServe(":"+os.Getenv("triggerport"), nil); err != nil {
+	go startFillListener()
+	go restoreLimitOrders()
+	go quotes.Stream(func(stock string, price decimal.Decimal) {
+		checkRunningTriggers(stock, price)
+		checkLimitOrders(stock, price)
+	})
+
+	fmt.Printf("Trigger server listening on %s:%s\n", cfg.TriggerAddr, cfg.TriggerPort)
+	if err := serveUntilSignal(&http.Server{Addr: ":" + cfg.TriggerPort}, cfg.DrainTimeout); err != nil {
 		panic(err)
 	}
+	if !drainAlerts(cfg.DrainTimeout) {
+		fmt.Println("error: trigger successes still unsent at shutdown")
+	}
+	fmt.Println("Trigger server stopped")
+}
+
+// drainAlerts waits up to timeout for every fired trigger and limit order
+// fill to be sent to the transaction server, reporting whether they all were
+func drainAlerts(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for len(successListener) > 0 || len(fillListener) > 0 || atomic.LoadInt64(&pendingAlerts) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
 }
 
 func startTriggerHandler(w http.ResponseWriter, r *http.Request) {
@@ -144,41 +199,52 @@ func startSuccessListener() {
 	for {
 		select {
 		case trig := <-successListener:
-			go handleTriggerSuccess(trig)
+			atomic.AddInt64(&pendingAlerts, 1)
+			go func() {
+				defer atomic.AddInt64(&pendingAlerts, -1)
+				handleTriggerSuccess(trig)
+			}()
+		}
+	}
+}
+
+// checkRunningTriggers is called with each fresh price from the quote server's
+// stream, firing any running triggers on that stock without waiting for their poll.
+func checkRunningTriggers(stock string, price decimal.Decimal) {
+	triggersLock.Lock()
+	defer triggersLock.Unlock()
+	for key, t := range runningTriggers {
+		if key.stock == stock && t.checkResult(price) {
+			successListener <- t
 		}
 	}
 }
 
 func handleTriggerSuccess(trig trigger) {
-	go alertTriggerSuccess(trig)
 	//fmt.Println("Closing successful trigger: ", trig)
 
+	// Triggers can succeed from both their poll and the price stream,
+	// only alert the transaction server the first time.
 	triggersLock.Lock()
-	delete(runningTriggers, triggersKey{trig.action, trig.stockname, trig.username})
+	key := triggersKey{trig.action, trig.stockname, trig.username}
+	_, running := runningTriggers[key]
+	delete(runningTriggers, key)
 	triggersLock.Unlock()
 
+	if running {
+		alertTransactionServer(trig.transNum, trig.getSuccessString())
+	}
+
 	//fmt.Println("Trigger should be closed: ", trig)
 
 }
 
-// Send an alert back to the transaction server when a trigger successfully finishes
-func alertTriggerSuccess(t trigger) {
-	var conn net.Conn
-	var err error
-	for {
-		conn, err = net.DialTimeout("tcp",
-			os.Getenv("transaddr")+":"+os.Getenv("transport"),
-			time.Second*15,
-		)
-		if err != nil { // trans server down? retry
-			fmt.Println("Trans server timedout -- retrying")
-		} else {
-			break
-		}
-	}
-
-	//fmt.Println(strconv.Itoa(t.transNum) + ";" + t.getSuccessString())
-	_, err = fmt.Fprintf(conn, strconv.Itoa(t.transNum)+";"+t.getSuccessString())
+// Send an alert back to the transaction server when a trigger successfully
+// finishes
+func alertTransactionServer(transNum int, message string) {
+	conn := dialTransactionServer()
+	//fmt.Println(strconv.Itoa(transNum) + ";" + message)
+	_, err := fmt.Fprintf(conn, strconv.Itoa(transNum)+";"+message)
 	if err != nil {
 		panic(err)
 	}
@@ -189,6 +255,34 @@ func alertTriggerSuccess(t trigger) {
 	}
 }
 
+// requestTransactionServer sends message to the transaction server and
+// returns its reply, waiting up to cfg.AlertTimeout for it
+func requestTransactionServer(transNum int, message string) (string, error) {
+	conn := dialTransactionServer()
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "%d;%s", transNum, message); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(cfg.AlertTimeout))
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	// Commands it doesn't know are answered without a newline
+	if err == io.EOF && reply != "" {
+		err = nil
+	}
+	return strings.TrimSpace(reply), err
+}
+
+func dialTransactionServer() net.Conn {
+	for {
+		conn, err := net.DialTimeout("tcp", cfg.TransAddress(), cfg.AlertTimeout)
+		if err == nil {
+			return conn
+		}
+		// trans server down? retry
+		fmt.Println("Trans server timedout -- retrying")
+	}
+}
+
 func getRunningTriggersHandler(w http.ResponseWriter, r *http.Request) {
 	triggersLock.Lock()
 	fmt.Fprintln(w, runningTriggers)
@@ -201,6 +295,50 @@ func getWaitingTriggersHandler(w http.ResponseWriter, r *http.Request) {
 	triggersLock.Unlock()
 }
 
+// userTrigger describes one of a user's triggers to /userTriggers
+type userTrigger struct {
+	Stock   string          `json:"stock"`
+	Action  string          `json:"action"`
+	Amount  decimal.Decimal `json:"amount"`
+	Price   decimal.Decimal `json:"price"`
+	Running bool            `json:"running"`
+}
+
+// userTriggersHandler lists the waiting and running triggers of a user as JSON.
+// Waiting triggers have an amount but no price yet.
+func userTriggersHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	if username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	list := []userTrigger{}
+	triggersLock.Lock()
+	for key, t := range runningTriggers {
+		if key.user == username {
+			list = append(list, userTrigger{t.stockname, t.action, t.amount, t.price, true})
+		}
+	}
+	for key, t := range waitingTriggers {
+		if key.user == username {
+			list = append(list, userTrigger{t.stockname, t.action, t.amount, t.price, false})
+		}
+	}
+	triggersLock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Stock != list[j].Stock {
+			return list[i].Stock < list[j].Stock
+		}
+		return list[i].Action < list[j].Action
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
+
 func verifyAction(action string) bool {
 	if action != "BUY" && action != "SELL" {
 		return false